@@ -0,0 +1,180 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Part of the URL of Linux binary repositories, such as Posit Package Manager
+// https://packagemanager.posit.co/cran/__linux__/<distribution-name>/latest
+const linuxBinaryRepositoryPath = "/__linux__/"
+
+// Package type of binary packages for Linux. Such packages are installed without running R CMD build.
+const linuxBinaryPackageType = "linux-binary"
+
+// User-Agent sent to Linux binary repositories, which serve binary packages only to clients identifying
+// themselves as R, e.g. R (4.3.2 x86_64-pc-linux-gnu x86_64 linux-gnu). Other clients get source packages.
+var linuxBinaryUserAgent string
+var linuxBinaryUserAgentOnce sync.Once
+
+// getRUserAgent returns the User-Agent sent by R (HTTPUserAgent option) on Linux, based on the output
+// of R --version and Go architecture name. Returns empty string if the R version is unknown.
+func getRUserAgent(rVersionOutput string, architecture string) string {
+	// First line of R --version output, e.g. R version 4.3.2 (2023-10-31) -- "Eye Holes"
+	fields := strings.Fields(rVersionOutput)
+	if len(fields) < 3 || fields[0] != "R" || fields[1] != "version" {
+		return ""
+	}
+	rArchitectures := map[string]string{"amd64": "x86_64", "arm64": "aarch64", "386": "i686"}
+	rArchitecture, ok := rArchitectures[architecture]
+	if !ok {
+		rArchitecture = architecture
+	}
+	vendor := "unknown"
+	if rArchitecture == "x86_64" {
+		vendor = "pc"
+	}
+	return "R (" + fields[2] + " " + rArchitecture + "-" + vendor + "-linux-gnu " + rArchitecture + " linux-gnu)"
+}
+
+// setLinuxBinaryUserAgent sets the User-Agent of R for requests to Linux binary repositories.
+// The User-Agent is determined once, based on the version of R on the system.
+func setLinuxBinaryUserAgent(req *http.Request) {
+	if getLinuxBinaryDistribution(req.URL.String()) == "" {
+		return
+	}
+	linuxBinaryUserAgentOnce.Do(func() {
+		linuxBinaryUserAgent = getRUserAgent(getSystemRVersion(), runtime.GOARCH)
+		if linuxBinaryUserAgent == "" {
+			log.Warn("Couldn't determine R version, so Linux binary repositories may serve source packages.")
+		}
+	})
+	if linuxBinaryUserAgent != "" {
+		req.Header.Set("User-Agent", linuxBinaryUserAgent)
+	}
+}
+
+// getLinuxBinaryDistribution returns the name of Linux distribution (e.g. jammy) for which
+// the repository serves binary packages, or empty string if repoURL is not a Linux binary repository.
+func getLinuxBinaryDistribution(repoURL string) string {
+	_, repositoryPath, found := strings.Cut(repoURL, linuxBinaryRepositoryPath)
+	if !found {
+		return ""
+	}
+	distribution, _, _ := strings.Cut(repositoryPath, "/")
+	return distribution
+}
+
+// getLinuxSourceRepositoryURL returns the URL of the repository serving source versions
+// of the packages from Linux binary repository, e.g. https://packagemanager.posit.co/cran/latest
+// for https://packagemanager.posit.co/cran/__linux__/jammy/latest.
+func getLinuxSourceRepositoryURL(repoURL string) string {
+	repositoryURL, repositoryPath, found := strings.Cut(repoURL, linuxBinaryRepositoryPath)
+	if !found {
+		return repoURL
+	}
+	_, snapshotPath, _ := strings.Cut(repositoryPath, "/")
+	if snapshotPath == "" {
+		return repositoryURL
+	}
+	return repositoryURL + "/" + snapshotPath
+}
+
+// getLinuxBinaryPackageDetails returns the same information as getPackageDetails for packages
+// from Linux binary repositories. The binary package is downloaded to a file with the name
+// of distribution appended to the package version, so that it's not mixed up with the source package.
+// Based on the PACKAGES file of the repository (packageInfo), the binary package is downloaded from
+// the repository or from its Archive, like in case of source repositories. If the PACKAGES file
+// couldn't be retrieved, it's assumed that the requested version is the newest one.
+// If the binary package in the requested version is not available, the source package is downloaded
+// from the corresponding source repository as a fallback.
+// The package is retrieved from cache if its checksum from the PACKAGES file matches one of
+// the cached packages, or if the binary package has already been downloaded.
+func getLinuxBinaryPackageDetails(packageName string, packageVersion string, repoURL string,
+	distribution string, packageInfo map[string]*PackageInfo, localArchiveChecksums map[string]*CacheInfo,
+) (string, string, string, string, string, string, int64) {
+	packageFileName := packageName + "_" + packageVersion + tarGzExtension
+	packageURL := repoURL + srcContrib + packageFileName
+	outputLocation := localOutputDirectory + archivesSubdirectory + packageName +
+		"_" + packageVersion + "_" + distribution + tarGzExtension
+	sourceRepositoryURL := getLinuxSourceRepositoryURL(repoURL)
+	fallbackPackageURL := sourceRepositoryURL + "/src/contrib/Archive/" + packageName + "/" + packageFileName
+	currentPackageInfo, ok := packageInfo[packageName]
+	switch {
+	case ok && currentPackageInfo.Version == packageVersion:
+		if localCachedFile, ok := localArchiveChecksums[currentPackageInfo.Checksum]; ok {
+			return cache, linuxBinaryPackageType, packageURL, "", localCachedFile.Path, "", localCachedFile.Length
+		}
+		// The source package in the same version should be in the current source repository as well.
+		fallbackPackageURL = sourceRepositoryURL + srcContrib + packageFileName
+	case packageInfo != nil:
+		log.Debug(repoURL, " current doesn't have ", packageName, " version ", packageVersion, ".")
+		packageURL = repoURL + "/src/contrib/Archive/" + packageName + "/" + packageFileName
+	}
+	if info, err := os.Stat(outputLocation); err == nil && isLinuxBinaryPackage(outputLocation, packageName) {
+		return cache, linuxBinaryPackageType, packageURL, "", outputLocation, "", info.Size()
+	}
+	fallbackOutputLocation := localOutputDirectory + archivesSubdirectory + packageFileName
+	log.Debug("Downloading Linux binary package for ", distribution, " from ", packageURL)
+	return download, linuxBinaryPackageType, packageURL, fallbackPackageURL, outputLocation,
+		fallbackOutputLocation, 0
+}
+
+// isLinuxBinaryPackage checks whether the tar.gz file contains a binary package,
+// i.e. a package which has already been installed, as opposed to a source package.
+func isLinuxBinaryPackage(filePath string, packageName string) bool {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return false
+	}
+	defer gzipReader.Close()
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err != nil {
+			// End of archive, or the file is not a valid archive.
+			return false
+		}
+		// Metadata of the package is created during installation.
+		if header.Name == packageName+"/Meta/package.rds" {
+			return true
+		}
+	}
+}
+
+// getDownloadedPackageType returns the type of the package retrieved to outputLocation.
+// Linux binary repositories may serve source packages in case binaries are not available,
+// and source packages can be downloaded as a fallback, so the contents of such packages are verified.
+func getDownloadedPackageType(packageType string, outputLocation string, packageName string) string {
+	if packageType == linuxBinaryPackageType && !isLinuxBinaryPackage(outputLocation, packageName) {
+		log.Info("Binary package ", packageName, " is not available, so source package ",
+			outputLocation, " will be used.")
+		return targzExtensionFile
+	}
+	return packageType
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestPackageArchive writes tar.gz file containing empty files with the given names.
+func writeTestPackageArchive(t *testing.T, filePath string, fileNames []string) {
+	file, err := os.Create(filePath)
+	assert.NoError(t, err)
+	defer file.Close()
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, fileName := range fileNames {
+		err = tarWriter.WriteHeader(&tar.Header{Name: fileName, Mode: 0600, Typeflag: tar.TypeReg})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tarWriter.Close())
+	assert.NoError(t, gzipWriter.Close())
+}
+
+func Test_getLinuxBinaryDistribution(t *testing.T) {
+	assert.Equal(t, getLinuxBinaryDistribution("https://packagemanager.posit.co/cran/__linux__/jammy/latest"), "jammy")
+	assert.Equal(t, getLinuxBinaryDistribution("https://packagemanager.posit.co/cran/__linux__/rhel9/2024-01-15"),
+		"rhel9")
+	assert.Equal(t, getLinuxBinaryDistribution("https://packagemanager.posit.co/cran/latest"), "")
+	assert.Equal(t, getLinuxBinaryDistribution("https://cloud.r-project.org"), "")
+}
+
+func Test_getLinuxSourceRepositoryURL(t *testing.T) {
+	assert.Equal(t, getLinuxSourceRepositoryURL("https://packagemanager.posit.co/cran/__linux__/jammy/latest"),
+		"https://packagemanager.posit.co/cran/latest")
+	assert.Equal(t, getLinuxSourceRepositoryURL("https://ppm.example.com/cran/__linux__/noble/2024-01-15"),
+		"https://ppm.example.com/cran/2024-01-15")
+	assert.Equal(t, getLinuxSourceRepositoryURL("https://ppm.example.com/cran/__linux__/noble"),
+		"https://ppm.example.com/cran")
+	assert.Equal(t, getLinuxSourceRepositoryURL("https://ppm.example.com/cran/latest"),
+		"https://ppm.example.com/cran/latest")
+}
+
+func Test_getLinuxBinaryPackageDetails(t *testing.T) {
+	previousOutputDirectory := localOutputDirectory
+	localOutputDirectory = "/tmp/scribe/downloaded_packages"
+	defer func() { localOutputDirectory = previousOutputDirectory }()
+	action, packageType, packageURL, fallbackPackageURL, outputLocation, fallbackOutputLocation, savedBandwidth :=
+		getPackageDetails("package1", "1.0.0", "https://packagemanager.posit.co/cran/__linux__/jammy/latest",
+			"Repository", map[string]map[string]*PackageInfo{}, nil, nil, map[string]*CacheInfo{})
+	assert.Equal(t, action, "download")
+	assert.Equal(t, packageType, linuxBinaryPackageType)
+	assert.Equal(t, packageURL,
+		"https://packagemanager.posit.co/cran/__linux__/jammy/latest/src/contrib/package1_1.0.0.tar.gz")
+	assert.Equal(t, fallbackPackageURL,
+		"https://packagemanager.posit.co/cran/latest/src/contrib/Archive/package1/package1_1.0.0.tar.gz")
+	assert.Equal(t, outputLocation,
+		"/tmp/scribe/downloaded_packages/package_archives/package1_1.0.0_jammy.tar.gz")
+	assert.Equal(t, fallbackOutputLocation,
+		"/tmp/scribe/downloaded_packages/package_archives/package1_1.0.0.tar.gz")
+	assert.Equal(t, savedBandwidth, int64(0))
+}
+
+func Test_getLinuxBinaryPackageDetailsPackagesFile(t *testing.T) {
+	previousOutputDirectory := localOutputDirectory
+	localOutputDirectory = "/tmp/scribe/downloaded_packages"
+	defer func() { localOutputDirectory = previousOutputDirectory }()
+	repoURL := "https://packagemanager.posit.co/cran/__linux__/jammy/latest"
+	packageInfo := map[string]*PackageInfo{"package1": {"1.1.0", "aaabbbccc", ""}}
+
+	// Current version of the package.
+	action, _, packageURL, fallbackPackageURL, _, _, _ := getLinuxBinaryPackageDetails("package1", "1.1.0",
+		repoURL, "jammy", packageInfo, map[string]*CacheInfo{})
+	assert.Equal(t, action, download)
+	assert.Equal(t, packageURL, repoURL+"/src/contrib/package1_1.1.0.tar.gz")
+	assert.Equal(t, fallbackPackageURL, "https://packagemanager.posit.co/cran/latest/src/contrib/package1_1.1.0.tar.gz")
+
+	// Older version of the package.
+	action, _, packageURL, fallbackPackageURL, _, _, _ = getLinuxBinaryPackageDetails("package1", "1.0.0",
+		repoURL, "jammy", packageInfo, map[string]*CacheInfo{})
+	assert.Equal(t, action, download)
+	assert.Equal(t, packageURL, repoURL+"/src/contrib/Archive/package1/package1_1.0.0.tar.gz")
+	assert.Equal(t, fallbackPackageURL,
+		"https://packagemanager.posit.co/cran/latest/src/contrib/Archive/package1/package1_1.0.0.tar.gz")
+}
+
+func Test_getLinuxBinaryPackageDetailsCached(t *testing.T) {
+	previousOutputDirectory := localOutputDirectory
+	localOutputDirectory = t.TempDir()
+	defer func() { localOutputDirectory = previousOutputDirectory }()
+	err := os.MkdirAll(localOutputDirectory+archivesSubdirectory, os.ModePerm)
+	assert.NoError(t, err)
+	repoURL := "https://packagemanager.posit.co/cran/__linux__/jammy/latest"
+
+	// Checksum from PACKAGES file matches a cached package.
+	packageInfo := map[string]*PackageInfo{"package1": {"1.0.0", "aaabbbccc", ""}}
+	localArchiveChecksums := map[string]*CacheInfo{"aaabbbccc": {"/tmp/scribe/package1_1.0.0_jammy.tar.gz", 1000}}
+	action, packageType, _, _, outputLocation, _, savedBandwidth := getLinuxBinaryPackageDetails("package1",
+		"1.0.0", repoURL, "jammy", packageInfo, localArchiveChecksums)
+	assert.Equal(t, action, cache)
+	assert.Equal(t, packageType, linuxBinaryPackageType)
+	assert.Equal(t, outputLocation, "/tmp/scribe/package1_1.0.0_jammy.tar.gz")
+	assert.Equal(t, savedBandwidth, int64(1000))
+
+	// Binary package has already been downloaded.
+	binaryPackage := localOutputDirectory + archivesSubdirectory + "package2_1.0.0_jammy.tar.gz"
+	writeTestPackageArchive(t, binaryPackage, []string{"package2/DESCRIPTION", "package2/Meta/package.rds"})
+	action, _, _, _, outputLocation, _, savedBandwidth = getLinuxBinaryPackageDetails("package2", "1.0.0",
+		repoURL, "jammy", nil, localArchiveChecksums)
+	assert.Equal(t, action, cache)
+	assert.Equal(t, outputLocation, binaryPackage)
+	assert.Greater(t, savedBandwidth, int64(0))
+
+	// Source package downloaded previously instead of the binary one is not reused.
+	sourcePackage := localOutputDirectory + archivesSubdirectory + "package3_1.0.0_jammy.tar.gz"
+	writeTestPackageArchive(t, sourcePackage, []string{"package3/DESCRIPTION"})
+	action, _, _, _, _, _, _ = getLinuxBinaryPackageDetails("package3", "1.0.0", repoURL, "jammy", nil,
+		localArchiveChecksums)
+	assert.Equal(t, action, download)
+}
+
+func Test_getRUserAgent(t *testing.T) {
+	rVersionOutput := `R version 4.3.2 (2023-10-31) -- "Eye Holes"`
+	assert.Equal(t, getRUserAgent(rVersionOutput, "amd64"), "R (4.3.2 x86_64-pc-linux-gnu x86_64 linux-gnu)")
+	assert.Equal(t, getRUserAgent(rVersionOutput, "arm64"),
+		"R (4.3.2 aarch64-unknown-linux-gnu aarch64 linux-gnu)")
+	assert.Equal(t, getRUserAgent("", "amd64"), "")
+}
+
+func Test_downloadFileLinuxBinaryUserAgent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("User-Agent"))
+	}))
+	defer server.Close()
+	previousUserAgent := linuxBinaryUserAgent
+	defer func() { linuxBinaryUserAgent = previousUserAgent }()
+	// Don't determine the User-Agent based on the R version on the system.
+	linuxBinaryUserAgentOnce.Do(func() {})
+	linuxBinaryUserAgent = "R (4.3.2 x86_64-pc-linux-gnu x86_64 linux-gnu)"
+
+	outputFile := t.TempDir() + "/PACKAGES"
+	statusCode, _ := downloadFile(server.URL+"/cran/__linux__/jammy/latest/src/contrib/PACKAGES", outputFile)
+	assert.Equal(t, statusCode, http.StatusOK)
+	contents, err := os.ReadFile(outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, string(contents), "R (4.3.2 x86_64-pc-linux-gnu x86_64 linux-gnu)")
+
+	statusCode, _ = downloadFile(server.URL+"/cran/latest/src/contrib/PACKAGES", outputFile)
+	assert.Equal(t, statusCode, http.StatusOK)
+	contents, err = os.ReadFile(outputFile)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(contents), "linux-gnu")
+}
+
+func Test_isLinuxBinaryPackage(t *testing.T) {
+	binaryPackage := t.TempDir() + "/package1_1.0.0_jammy.tar.gz"
+	writeTestPackageArchive(t, binaryPackage, []string{"package1/DESCRIPTION", "package1/Meta/package.rds"})
+	sourcePackage := t.TempDir() + "/package1_1.0.0.tar.gz"
+	writeTestPackageArchive(t, sourcePackage, []string{"package1/DESCRIPTION", "package1/R/package1.R"})
+	assert.True(t, isLinuxBinaryPackage(binaryPackage, "package1"))
+	assert.False(t, isLinuxBinaryPackage(sourcePackage, "package1"))
+	assert.False(t, isLinuxBinaryPackage(t.TempDir()+"/nonexistent.tar.gz", "package1"))
+}
+
+func Test_downloadSinglePackageLinuxBinary(t *testing.T) {
+	previousOutputDirectory := localOutputDirectory
+	localOutputDirectory = t.TempDir()
+	defer func() { localOutputDirectory = previousOutputDirectory }()
+	err := os.MkdirAll(localOutputDirectory+archivesSubdirectory, os.ModePerm)
+	assert.NoError(t, err)
+	repoURL := "https://packagemanager.posit.co/cran/__linux__/jammy/latest"
+	// Binary of package1 is available, binary of package2 is not available in the requested version.
+	downloadFileFunction := func(url string, outputFile string) (int, int64) {
+		switch {
+		case strings.HasSuffix(url, "/src/contrib/package1_1.0.0.tar.gz"):
+			writeTestPackageArchive(t, outputFile, []string{"package1/DESCRIPTION", "package1/Meta/package.rds"})
+			return http.StatusOK, 1
+		case strings.HasSuffix(url, "/src/contrib/Archive/package2/package2_1.0.0.tar.gz"):
+			writeTestPackageArchive(t, outputFile, []string{"package2/DESCRIPTION"})
+			return http.StatusOK, 1
+		}
+		return http.StatusNotFound, 0
+	}
+	messages := make(chan DownloadInfo, 1)
+	guard := make(chan struct{}, 1)
+
+	guard <- struct{}{}
+	downloadSinglePackage(context.Background(), "package1", "1.0.0", repoURL, "", "",
+		"Repository", "PPM", "", "", map[string]map[string]*PackageInfo{}, nil, nil, map[string]*CacheInfo{},
+		downloadFileFunction, mockedCloneGitRepo, messages, guard)
+	msg := <-messages
+	assert.Equal(t, msg.StatusCode, http.StatusOK)
+	assert.Equal(t, msg.DownloadedPackageType, linuxBinaryPackageType)
+	assert.Equal(t, msg.OutputLocation, localOutputDirectory+archivesSubdirectory+"package1_1.0.0_jammy.tar.gz")
+
+	guard <- struct{}{}
+	downloadSinglePackage(context.Background(), "package2", "1.0.0", repoURL, "", "",
+		"Repository", "PPM", "", "", map[string]map[string]*PackageInfo{}, nil, nil, map[string]*CacheInfo{},
+		downloadFileFunction, mockedCloneGitRepo, messages, guard)
+	msg = <-messages
+	assert.Equal(t, msg.StatusCode, http.StatusOK)
+	assert.Equal(t, msg.DownloadedPackageType, targzExtensionFile)
+	assert.Equal(t, msg.OutputLocation, localOutputDirectory+archivesSubdirectory+"package2_1.0.0.tar.gz")
+	assert.Equal(t, msg.SuccessfulURL,
+		"https://packagemanager.posit.co/cran/latest/src/contrib/Archive/package2/package2_1.0.0.tar.gz")
+}
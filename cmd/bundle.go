@@ -0,0 +1,572 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	locksmith "github.com/insightsengineering/locksmith/cmd"
+	"github.com/spf13/cobra"
+)
+
+// Names of files in the bundle. Downloaded packages are stored in bundlePackagesDirectory
+// at the same paths as in localOutputDirectory.
+const bundleChecksumsFileName = "SHA256SUMS"
+const bundleRenvLockFileName = "renv.lock"
+const bundlePackagesDirectory = "downloaded_packages"
+
+// Maximum total size of files extracted from a bundle.
+const maxBundleExtractedSize int64 = 64 << 30
+
+// getBundleDownloadInfo returns download results with OutputLocation relative to localOutputDirectory,
+// and the list of these relative locations. Packages which haven't been downloaded to
+// localOutputDirectory are skipped.
+func getBundleDownloadInfo(allDownloadInfo []DownloadInfo) ([]DownloadInfo, []string) {
+	var bundleDownloadInfo []DownloadInfo
+	var locations []string
+	for _, p := range allDownloadInfo {
+		if p.OutputLocation != "" {
+			relativeLocation, err := filepath.Rel(localOutputDirectory, p.OutputLocation)
+			if err != nil || strings.HasPrefix(relativeLocation, "..") {
+				log.Warn("Skipping ", p.PackageName, " because ", p.OutputLocation, " is outside of ",
+					localOutputDirectory, ".")
+				continue
+			}
+			p.OutputLocation = filepath.ToSlash(relativeLocation)
+			locations = append(locations, p.OutputLocation)
+		} else {
+			log.Warn("Package ", p.PackageName, " hasn't been downloaded properly.")
+		}
+		bundleDownloadInfo = append(bundleDownloadInfo, p)
+	}
+	return bundleDownloadInfo, locations
+}
+
+// bundleWriter writes files to the tar.gz bundle and computes their checksums.
+type bundleWriter struct {
+	tarWriter *tar.Writer
+	checksums map[string]string
+}
+
+// addBytes adds the file with contents to the bundle at archivePath.
+func (w *bundleWriter) addBytes(archivePath string, contents []byte) error {
+	err := w.tarWriter.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg, Name: archivePath, Mode: 0644, Size: int64(len(contents)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.tarWriter.Write(contents)
+	hash := sha256.Sum256(contents)
+	w.checksums[archivePath] = hex.EncodeToString(hash[:])
+	return err
+}
+
+// addPath adds the file or directory at filePath (together with its contents) to the bundle at archivePath.
+func (w *bundleWriter) addPath(filePath string, archivePath string) error {
+	return filepath.Walk(filePath, func(currentPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(filePath, currentPath)
+		if err != nil {
+			return err
+		}
+		currentArchivePath := path.Join(archivePath, filepath.ToSlash(relativePath))
+		switch {
+		case info.IsDir():
+			return nil
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(currentPath)
+			if err != nil {
+				return err
+			}
+			hash := sha256.Sum256([]byte(target))
+			w.checksums[currentArchivePath] = hex.EncodeToString(hash[:])
+			return w.tarWriter.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeSymlink, Name: currentArchivePath, Linkname: target, Mode: 0777,
+			})
+		case !info.Mode().IsRegular():
+			return nil
+		}
+		file, err := os.Open(currentPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		err = w.tarWriter.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg, Name: currentArchivePath, Mode: int64(info.Mode().Perm()), Size: info.Size(),
+			ModTime: info.ModTime(),
+		})
+		if err != nil {
+			return err
+		}
+		hash := sha256.New()
+		_, err = io.Copy(w.tarWriter, io.TeeReader(file, hash))
+		w.checksums[currentArchivePath] = hex.EncodeToString(hash.Sum(nil))
+		return err
+	})
+}
+
+// getChecksumsFileContents returns the list of checksums in the format of sha256sum.
+func getChecksumsFileContents(checksums map[string]string) []byte {
+	var archivePaths []string
+	for archivePath := range checksums {
+		archivePaths = append(archivePaths, archivePath)
+	}
+	sort.Strings(archivePaths)
+	var contents bytes.Buffer
+	for _, archivePath := range archivePaths {
+		contents.WriteString(checksums[archivePath] + "  " + archivePath + "\n")
+	}
+	return contents.Bytes()
+}
+
+// parseChecksumsFile parses the list of checksums in the format of sha256sum.
+func parseChecksumsFile(contents string) (map[string]string, error) {
+	checksums := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for scanner.Scan() {
+		checksum, archivePath, found := strings.Cut(scanner.Text(), "  ")
+		if !found {
+			return nil, errors.New("invalid line in " + bundleChecksumsFileName + ": " + scanner.Text())
+		}
+		checksums[archivePath] = checksum
+	}
+	return checksums, scanner.Err()
+}
+
+// writeBundle creates tar.gz bundle with the renv.lock, download results, downloaded packages
+// and copies of PACKAGES files, together with the list of SHA-256 checksums of these files.
+func writeBundle(bundleFile string, renvLockFile string, allDownloadInfo []DownloadInfo) error {
+	renvLockContents, err := os.ReadFile(renvLockFile)
+	if err != nil {
+		return err
+	}
+	bundleDownloadInfo, locations := getBundleDownloadInfo(allDownloadInfo)
+	downloadInfoContents, err := json.MarshalIndent(bundleDownloadInfo, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(bundleFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gzipWriter := gzip.NewWriter(file)
+	defer gzipWriter.Close()
+	w := &bundleWriter{tar.NewWriter(gzipWriter), make(map[string]string)}
+	defer w.tarWriter.Close()
+	err = w.addBytes(bundleRenvLockFileName, renvLockContents)
+	if err != nil {
+		return err
+	}
+	err = w.addBytes(downloadInfoFileName, downloadInfoContents)
+	if err != nil {
+		return err
+	}
+	snapshotsDirectory := strings.TrimPrefix(packagesSnapshotsSubdirectory, "/")
+	if _, err = os.Stat(filepath.Join(localOutputDirectory, snapshotsDirectory)); err == nil {
+		locations = append(locations, snapshotsDirectory)
+	}
+	addedLocations := make(map[string]bool)
+	for _, location := range locations {
+		// Several packages may be stored at the same location.
+		if addedLocations[location] {
+			continue
+		}
+		addedLocations[location] = true
+		log.Debug("Adding ", location, " to ", bundleFile)
+		err = w.addPath(filepath.Join(localOutputDirectory, filepath.FromSlash(location)),
+			path.Join(bundlePackagesDirectory, location))
+		if err != nil {
+			return err
+		}
+	}
+	err = w.addBytes(bundleChecksumsFileName, getChecksumsFileContents(w.checksums))
+	if err != nil {
+		return err
+	}
+	// Close explicitly to report errors while flushing the archive.
+	err = w.tarWriter.Close()
+	if err != nil {
+		return err
+	}
+	err = gzipWriter.Close()
+	if err != nil {
+		return err
+	}
+	log.Info("Created bundle ", bundleFile, " with ", len(w.checksums), " files.")
+	return file.Close()
+}
+
+// getBundleEntryPath returns the path where the bundle entry should be extracted in directory,
+// or an error if the entry path is empty, absolute, or would point to directory itself or outside of it.
+func getBundleEntryPath(directory string, archivePath string) (string, error) {
+	if path.IsAbs(archivePath) || filepath.IsAbs(archivePath) {
+		return "", errors.New("invalid path in bundle: " + archivePath)
+	}
+	entryPath := filepath.Join(directory, filepath.FromSlash(archivePath))
+	if !strings.HasPrefix(entryPath, filepath.Clean(directory)+string(os.PathSeparator)) {
+		return "", errors.New("invalid path in bundle: " + archivePath)
+	}
+	return entryPath, nil
+}
+
+// checkBundleEntryParents returns an error if any of the existing parent directories of entryPath
+// within directory is a symbolic link, so that the entry can't be extracted outside of directory
+// through a symbolic link extracted earlier.
+func checkBundleEntryParents(directory string, entryPath string) error {
+	relativePath, err := filepath.Rel(directory, filepath.Dir(entryPath))
+	if err != nil {
+		return err
+	}
+	currentPath := directory
+	for _, pathElement := range strings.Split(relativePath, string(os.PathSeparator)) {
+		if pathElement == "." {
+			continue
+		}
+		currentPath = filepath.Join(currentPath, pathElement)
+		info, err := os.Lstat(currentPath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return errors.New("invalid path in bundle through symbolic link: " + entryPath)
+		}
+	}
+	return nil
+}
+
+// checkBundleSymlinks returns an error if any of the symbolic links extracted to directory
+// doesn't resolve to directory or a path within it.
+func checkBundleSymlinks(directory string, symlinks []string) error {
+	resolvedDirectory, err := filepath.EvalSymlinks(directory)
+	if err != nil {
+		return err
+	}
+	for _, symlink := range symlinks {
+		resolvedPath, err := filepath.EvalSymlinks(filepath.Join(directory, filepath.FromSlash(symlink)))
+		if err != nil || (resolvedPath != resolvedDirectory &&
+			!strings.HasPrefix(resolvedPath, resolvedDirectory+string(os.PathSeparator))) {
+			return errors.New("invalid symbolic link in bundle: " + symlink)
+		}
+	}
+	return nil
+}
+
+// extractBundleEntry writes the current tar entry to entryPath, failing if the file already exists
+// or its contents are larger than maxSize bytes. Returns the SHA-256 checksum and the size of the entry.
+func extractBundleEntry(tarReader *tar.Reader, header *tar.Header, entryPath string,
+	maxSize int64) (string, int64, error) {
+	err := os.MkdirAll(filepath.Dir(entryPath), os.ModePerm)
+	if err != nil {
+		return "", 0, err
+	}
+	if header.Typeflag == tar.TypeSymlink {
+		if filepath.IsAbs(header.Linkname) ||
+			strings.HasPrefix(path.Clean(path.Join(path.Dir(header.Name), header.Linkname)), "..") {
+			return "", 0, errors.New("invalid symbolic link in bundle: " + header.Name)
+		}
+		hash := sha256.Sum256([]byte(header.Linkname))
+		return hex.EncodeToString(hash[:]), 0, os.Symlink(header.Linkname, entryPath)
+	}
+	if header.Size > maxSize {
+		return "", 0, errors.New("bundle exceeds the maximum extracted size")
+	}
+	out, err := os.OpenFile(entryPath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, os.FileMode(header.Mode).Perm())
+	if err != nil {
+		return "", 0, err
+	}
+	defer out.Close()
+	hash := sha256.New()
+	// #nosec G110 -- the size of the contents is limited to maxSize.
+	size, err := io.CopyN(io.MultiWriter(out, hash), tarReader, maxSize+1)
+	if err != nil && err != io.EOF {
+		return "", size, err
+	}
+	if size > maxSize {
+		return "", size, errors.New("bundle exceeds the maximum extracted size")
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, out.Close()
+}
+
+// extractBundle extracts the bundle to directory, and verifies the checksums of the extracted files
+// against the list of checksums stored in the bundle. Extraction fails if the total size of the files
+// exceeds maxBundleExtractedSize.
+func extractBundle(bundleFile string, directory string) error {
+	file, err := os.Open(bundleFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+	tarReader := tar.NewReader(gzipReader)
+	checksums := make(map[string]string)
+	var symlinks []string
+	remainingSize := maxBundleExtractedSize
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeSymlink {
+			return errors.New("unsupported entry in bundle: " + header.Name)
+		}
+		entryPath, err := getBundleEntryPath(directory, header.Name)
+		if err != nil {
+			return err
+		}
+		err = checkBundleEntryParents(directory, entryPath)
+		if err != nil {
+			return err
+		}
+		var size int64
+		checksums[header.Name], size, err = extractBundleEntry(tarReader, header, entryPath, remainingSize)
+		if err != nil {
+			return err
+		}
+		remainingSize -= size
+		if header.Typeflag == tar.TypeSymlink {
+			symlinks = append(symlinks, header.Name)
+		}
+	}
+	err = checkBundleSymlinks(directory, symlinks)
+	if err != nil {
+		return err
+	}
+	checksumsFileContents, err := os.ReadFile(filepath.Join(directory, bundleChecksumsFileName))
+	if err != nil {
+		return errors.New(bundleChecksumsFileName + " not found in bundle")
+	}
+	expectedChecksums, err := parseChecksumsFile(string(checksumsFileContents))
+	if err != nil {
+		return err
+	}
+	delete(checksums, bundleChecksumsFileName)
+	for archivePath, checksum := range checksums {
+		if expectedChecksum, ok := expectedChecksums[archivePath]; !ok || checksum != expectedChecksum {
+			return fmt.Errorf("checksum of %s is %s instead of %s", archivePath, checksum, expectedChecksum)
+		}
+	}
+	for archivePath := range expectedChecksums {
+		if _, ok := checksums[archivePath]; !ok {
+			return errors.New(archivePath + " not found in bundle")
+		}
+	}
+	log.Info("Verified checksums of ", len(checksums), " files in ", bundleFile, ".")
+	return nil
+}
+
+// moveBundlePackages moves the packages extracted from the bundle to sourceDirectory
+// to localOutputDirectory, replacing any packages already stored at the same locations.
+// Returns an error before modifying localOutputDirectory if any of the locations is invalid.
+func moveBundlePackages(sourceDirectory string, locations []string) error {
+	var locationPaths []string
+	for _, location := range locations {
+		locationPath, err := getBundleEntryPath(localOutputDirectory, location)
+		if err != nil {
+			return err
+		}
+		locationPaths = append(locationPaths, locationPath)
+	}
+	for _, locationPath := range locationPaths {
+		err := os.RemoveAll(locationPath)
+		if err != nil {
+			return err
+		}
+	}
+	return filepath.Walk(sourceDirectory, func(currentPath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relativePath, err := filepath.Rel(sourceDirectory, currentPath)
+		if err != nil {
+			return err
+		}
+		destinationPath := filepath.Join(localOutputDirectory, relativePath)
+		err = os.MkdirAll(filepath.Dir(destinationPath), os.ModePerm)
+		if err != nil {
+			return err
+		}
+		err = os.RemoveAll(destinationPath)
+		if err != nil {
+			return err
+		}
+		return os.Rename(currentPath, destinationPath)
+	})
+}
+
+// importRenvLock saves the renv.lock from the bundle to renvLockFile, unless it already exists.
+// Returns an error if the existing renvLockFile differs from the renv.lock in the bundle.
+func importRenvLock(bundleRenvLockFile string, renvLockFile string) error {
+	contents, err := os.ReadFile(bundleRenvLockFile)
+	if err != nil {
+		return err
+	}
+	existingContents, err := os.ReadFile(renvLockFile)
+	if err == nil {
+		if !bytes.Equal(contents, existingContents) {
+			return errors.New(renvLockFile + " differs from " + bundleRenvLockFileName + " in the bundle")
+		}
+		return nil
+	}
+	log.Info("Saving ", bundleRenvLockFileName, " from the bundle to ", renvLockFile, ".")
+	return os.WriteFile(renvLockFile, contents, 0644) //#nosec
+}
+
+// importBundle verifies the bundle created by writeBundle, extracts the packages to localOutputDirectory,
+// and saves the download results (with OutputLocation pointing to localOutputDirectory) to tempCacheDirectory,
+// so that the installation stage can use them.
+func importBundle(bundleFile string) error {
+	err := os.MkdirAll(localOutputDirectory, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	// Extracting next to localOutputDirectory, so that the files can be moved rather than copied.
+	stagingDirectory, err := os.MkdirTemp(filepath.Dir(filepath.Clean(localOutputDirectory)), "scribe-bundle-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(stagingDirectory)
+	err = extractBundle(bundleFile, stagingDirectory)
+	if err != nil {
+		return err
+	}
+	var allDownloadInfo []DownloadInfo
+	contents, err := os.ReadFile(filepath.Join(stagingDirectory, downloadInfoFileName))
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(contents, &allDownloadInfo)
+	if err != nil {
+		return err
+	}
+	// Locations are validated before any changes are made to renvLockFilename or localOutputDirectory.
+	var locations []string
+	for i, p := range allDownloadInfo {
+		if p.OutputLocation != "" {
+			outputLocation, err := getBundleEntryPath(localOutputDirectory, p.OutputLocation)
+			if err != nil {
+				return err
+			}
+			locations = append(locations, p.OutputLocation)
+			allDownloadInfo[i].OutputLocation = outputLocation
+		}
+	}
+	err = importRenvLock(filepath.Join(stagingDirectory, bundleRenvLockFileName), renvLockFilename)
+	if err != nil {
+		return err
+	}
+	err = moveBundlePackages(filepath.Join(stagingDirectory, bundlePackagesDirectory), locations)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(tempCacheDirectory, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	downloadInfoFile := filepath.Join(tempCacheDirectory, downloadInfoFileName)
+	writeJSON(downloadInfoFile, &allDownloadInfo)
+	// Results of the previous download stage, if any, have been replaced.
+	removeStageFingerprint(downloadInfoFile)
+	log.Info("Imported ", len(allDownloadInfo), " packages from ", bundleFile, " to ", localOutputDirectory, ".")
+	return nil
+}
+
+// saveRepositoryPackagesSnapshots downloads PACKAGES files of repositories from renv.lock and CRAN,
+// which are used to determine package dependencies, so that they're available in offline mode.
+func saveRepositoryPackagesSnapshots(repositories []Rrepository) {
+	repositoryURLs := map[string]bool{defaultCranMirrorURL: true}
+	for _, repository := range repositories {
+		repositoryURLs[repository.URL] = true
+	}
+	for repositoryURL := range repositoryURLs {
+		locksmith.GetPackagesFileContent(repositoryURL, withPackagesTextSnapshots(downloadTextFile))
+	}
+}
+
+func newBundleCommand() *cobra.Command {
+	bundleCmd := &cobra.Command{
+		Use:   "bundle",
+		Short: "Transfer downloaded packages to an air-gapped environment",
+		Long: `Creates a bundle with packages downloaded on a host with network access,
+and imports it on a host without network access.`,
+	}
+	bundleCmd.AddCommand(&cobra.Command{
+		Use:   "create <bundle file>",
+		Short: "Create a bundle with packages from renv.lock",
+		Long: `Downloads packages defined in renv.lock (unless they have already been downloaded)
+and saves them to a tar.gz bundle, together with the renv.lock, ` + downloadInfoFileName + `,
+copies of PACKAGES files of package repositories, and SHA-256 checksums of all these files.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			initializeRun()
+			ctx, stop := newRunContext()
+			defer stop()
+			renvLock, _ := loadRenvLock()
+			downloadFingerprint := getDownloadFingerprint(getFileHash(renvLockFilename), getSystemRVersion())
+			allDownloadInfo := runDownloadStage(ctx, renvLock, downloadFingerprint, true)
+			exitIfCancelled(ctx)
+			saveRepositoryPackagesSnapshots(getRenvRepositories(renvLock))
+			err := writeBundle(args[0], renvLockFilename, allDownloadInfo)
+			if err != nil {
+				log.Fatal("Couldn't create bundle: ", err)
+			}
+		},
+	})
+	bundleCmd.AddCommand(&cobra.Command{
+		Use:   "import <bundle file>",
+		Short: "Import a bundle created with 'scribe bundle create'",
+		Long: `Verifies the checksums of files in the bundle, extracts the packages to the download directory,
+and saves the download results to ` + downloadInfoFileName + ` in the cache directory.
+The renv.lock from the bundle is saved to --renvLockFilename, unless the file already exists.
+Afterwards, the packages can be installed with 'scribe install --offline'.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			initializeRun()
+			err := importBundle(args[0])
+			if err != nil {
+				log.Fatal("Couldn't import bundle: ", err)
+			}
+		},
+	})
+	return bundleCmd
+}
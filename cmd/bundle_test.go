@@ -0,0 +1,268 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestFile writes contents to the file, creating its parent directories.
+func writeTestFile(t *testing.T, filePath string, contents string) {
+	err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filePath, []byte(contents), 0600)
+	assert.NoError(t, err)
+}
+
+// createTestBundle creates a bundle with one package archive, one package from git repository
+// (stored in a subdirectory) and one PACKAGES snapshot. Returns the path to the bundle.
+func createTestBundle(t *testing.T) string {
+	localOutputDirectory = t.TempDir()
+	writeTestFile(t, filepath.Join(localOutputDirectory, "package_archives", "package1_1.0.0.tar.gz"), "package1")
+	writeTestFile(t, filepath.Join(localOutputDirectory, "github", "user", "repo", "package2", "DESCRIPTION"),
+		"Package: package2\n")
+	err := os.Symlink("DESCRIPTION", filepath.Join(localOutputDirectory, "github", "user", "repo", "package2",
+		"DESCRIPTION.link"))
+	assert.NoError(t, err)
+	writeTestFile(t, getPackagesSnapshotPath("https://cloud.r-project.org/src/contrib/PACKAGES"),
+		"Package: package1\n")
+	renvLockFile := filepath.Join(t.TempDir(), "renv.lock")
+	writeTestFile(t, renvLockFile, "{}")
+	allDownloadInfo := []DownloadInfo{
+		{StatusCode: 200, PackageName: "package1",
+			OutputLocation: filepath.Join(localOutputDirectory, "package_archives", "package1_1.0.0.tar.gz")},
+		{StatusCode: 200, PackageName: "package2",
+			OutputLocation: filepath.Join(localOutputDirectory, "github", "user", "repo", "package2")},
+		{StatusCode: 404, PackageName: "package3"},
+	}
+	bundleFile := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	err = writeBundle(bundleFile, renvLockFile, allDownloadInfo)
+	assert.NoError(t, err)
+	return bundleFile
+}
+
+func Test_importBundle(t *testing.T) {
+	previousOutputDirectory := localOutputDirectory
+	previousCacheDirectory := tempCacheDirectory
+	previousRenvLockFilename := renvLockFilename
+	defer func() {
+		localOutputDirectory = previousOutputDirectory
+		tempCacheDirectory = previousCacheDirectory
+		renvLockFilename = previousRenvLockFilename
+	}()
+	bundleFile := createTestBundle(t)
+
+	// Import on another host.
+	localOutputDirectory = filepath.Join(t.TempDir(), "downloaded_packages")
+	tempCacheDirectory = t.TempDir()
+	renvLockFilename = filepath.Join(t.TempDir(), "renv.lock")
+	// Stale file from the previous run.
+	writeTestFile(t, filepath.Join(localOutputDirectory, "github", "user", "repo", "package2", "NAMESPACE"), "")
+	err := importBundle(bundleFile)
+	assert.NoError(t, err)
+
+	var allDownloadInfo []DownloadInfo
+	readJSON(filepath.Join(tempCacheDirectory, downloadInfoFileName), &allDownloadInfo)
+	assert.Equal(t, len(allDownloadInfo), 3)
+	assert.Equal(t, allDownloadInfo[0].OutputLocation,
+		filepath.Join(localOutputDirectory, "package_archives", "package1_1.0.0.tar.gz"))
+	assert.Equal(t, allDownloadInfo[2].OutputLocation, "")
+	contents, err := os.ReadFile(allDownloadInfo[0].OutputLocation)
+	assert.NoError(t, err)
+	assert.Equal(t, string(contents), "package1")
+	contents, err = os.ReadFile(filepath.Join(allDownloadInfo[1].OutputLocation, "DESCRIPTION.link"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(contents), "Package: package2\n")
+	_, err = os.Stat(filepath.Join(allDownloadInfo[1].OutputLocation, "NAMESPACE"))
+	assert.True(t, os.IsNotExist(err))
+	contents, err = os.ReadFile(getPackagesSnapshotPath("https://cloud.r-project.org/src/contrib/PACKAGES"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(contents), "Package: package1\n")
+	contents, err = os.ReadFile(renvLockFilename)
+	assert.NoError(t, err)
+	assert.Equal(t, string(contents), "{}")
+
+	// Different lockfile already exists.
+	writeTestFile(t, renvLockFilename, `{"R": {}}`)
+	err = importBundle(bundleFile)
+	assert.ErrorContains(t, err, "differs from renv.lock in the bundle")
+}
+
+func Test_importBundleCorrupted(t *testing.T) {
+	previousOutputDirectory := localOutputDirectory
+	previousCacheDirectory := tempCacheDirectory
+	defer func() {
+		localOutputDirectory = previousOutputDirectory
+		tempCacheDirectory = previousCacheDirectory
+	}()
+	bundleFile := createTestBundle(t)
+
+	// Replace the contents of the package archive in the bundle.
+	in, err := os.Open(bundleFile)
+	assert.NoError(t, err)
+	defer in.Close()
+	gzipReader, err := gzip.NewReader(in)
+	assert.NoError(t, err)
+	tarReader := tar.NewReader(gzipReader)
+	corruptedBundleFile := filepath.Join(t.TempDir(), "corrupted.tar.gz")
+	out, err := os.Create(corruptedBundleFile)
+	assert.NoError(t, err)
+	gzipWriter := gzip.NewWriter(out)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		contents, err := io.ReadAll(tarReader)
+		assert.NoError(t, err)
+		if header.Name == "downloaded_packages/package_archives/package1_1.0.0.tar.gz" {
+			contents = []byte("package9")
+		}
+		err = tarWriter.WriteHeader(header)
+		assert.NoError(t, err)
+		_, err = tarWriter.Write(contents)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tarWriter.Close())
+	assert.NoError(t, gzipWriter.Close())
+	assert.NoError(t, out.Close())
+
+	localOutputDirectory = filepath.Join(t.TempDir(), "downloaded_packages")
+	tempCacheDirectory = t.TempDir()
+	err = importBundle(corruptedBundleFile)
+	assert.ErrorContains(t, err, "checksum of downloaded_packages/package_archives/package1_1.0.0.tar.gz")
+	// Nothing is imported from the corrupted bundle.
+	_, err = os.Stat(filepath.Join(localOutputDirectory, "package_archives"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(tempCacheDirectory, downloadInfoFileName))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_getBundleEntryPath(t *testing.T) {
+	_, err := getBundleEntryPath("/tmp/bundle", "../outside")
+	assert.Error(t, err)
+	_, err = getBundleEntryPath("/tmp/bundle", "package_archives/../../outside")
+	assert.Error(t, err)
+	_, err = getBundleEntryPath("/tmp/bundle", "/etc/passwd")
+	assert.Error(t, err)
+	_, err = getBundleEntryPath("/tmp/bundle", "")
+	assert.Error(t, err)
+	_, err = getBundleEntryPath("/tmp/bundle", ".")
+	assert.Error(t, err)
+	entryPath, err := getBundleEntryPath("/tmp/bundle", "downloaded_packages/package1.tar.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, entryPath, "/tmp/bundle/downloaded_packages/package1.tar.gz")
+}
+
+func Test_moveBundlePackagesInvalidLocation(t *testing.T) {
+	previousOutputDirectory := localOutputDirectory
+	defer func() { localOutputDirectory = previousOutputDirectory }()
+	localOutputDirectory = t.TempDir()
+	writeTestFile(t, filepath.Join(localOutputDirectory, "package_archives", "package1_1.0.0.tar.gz"), "package1")
+	for _, location := range []string{"", ".", "..", "/tmp", "package_archives/../.."} {
+		err := moveBundlePackages(t.TempDir(), []string{"package_archives/package2_1.0.0.tar.gz", location})
+		assert.Error(t, err)
+	}
+	// Nothing has been removed from localOutputDirectory.
+	_, err := os.Stat(filepath.Join(localOutputDirectory, "package_archives", "package1_1.0.0.tar.gz"))
+	assert.NoError(t, err)
+}
+
+// writeTestTarEntries writes a bundle consisting of the entries, with contents of regular files
+// given by the contents map. Returns the path to the bundle.
+func writeTestTarEntries(t *testing.T, entries []tar.Header, contents map[string]string) string {
+	bundleFile := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	out, err := os.Create(bundleFile)
+	assert.NoError(t, err)
+	defer out.Close()
+	gzipWriter := gzip.NewWriter(out)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, header := range entries {
+		header.Mode = 0600
+		header.Size = int64(len(contents[header.Name]))
+		assert.NoError(t, tarWriter.WriteHeader(&header))
+		_, err = tarWriter.Write([]byte(contents[header.Name]))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tarWriter.Close())
+	assert.NoError(t, gzipWriter.Close())
+	return bundleFile
+}
+
+func Test_extractBundleUnsafeEntries(t *testing.T) {
+	// Entry extracted through symbolic links to the parent of the extraction directory.
+	bundleFile := writeTestTarEntries(t, []tar.Header{
+		{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "."},
+		{Name: "a/b", Typeflag: tar.TypeSymlink, Linkname: ".."},
+		{Name: "a/b/evil", Typeflag: tar.TypeReg},
+	}, map[string]string{"a/b/evil": "evil"})
+	directory := filepath.Join(t.TempDir(), "staging")
+	err := extractBundle(bundleFile, directory)
+	assert.ErrorContains(t, err, "through symbolic link")
+	_, err = os.Lstat(filepath.Join(filepath.Dir(directory), "evil"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Symbolic link resolved outside of the extraction directory through another symbolic link.
+	bundleFile = writeTestTarEntries(t, []tar.Header{
+		{Name: "x", Typeflag: tar.TypeSymlink, Linkname: "."},
+		{Name: "y", Typeflag: tar.TypeSymlink, Linkname: "x/x/x/.."},
+	}, nil)
+	err = extractBundle(bundleFile, filepath.Join(t.TempDir(), "staging"))
+	assert.ErrorContains(t, err, "invalid symbolic link in bundle: y")
+
+	// File overwriting a symbolic link extracted earlier.
+	outsideFile := filepath.Join(t.TempDir(), "outside")
+	writeTestFile(t, outsideFile, "outside")
+	directory = filepath.Join(t.TempDir(), "staging")
+	assert.NoError(t, os.MkdirAll(directory, os.ModePerm))
+	assert.NoError(t, os.Symlink(outsideFile, filepath.Join(directory, "link")))
+	bundleFile = writeTestTarEntries(t, []tar.Header{{Name: "link", Typeflag: tar.TypeReg}},
+		map[string]string{"link": "evil"})
+	err = extractBundle(bundleFile, directory)
+	assert.Error(t, err)
+	contents, err := os.ReadFile(outsideFile)
+	assert.NoError(t, err)
+	assert.Equal(t, string(contents), "outside")
+}
+
+func Test_extractBundleEntryMaxSize(t *testing.T) {
+	bundleFile := writeTestTarEntries(t, []tar.Header{{Name: "file", Typeflag: tar.TypeReg}},
+		map[string]string{"file": "0123456789"})
+	in, err := os.Open(bundleFile)
+	assert.NoError(t, err)
+	defer in.Close()
+	gzipReader, err := gzip.NewReader(in)
+	assert.NoError(t, err)
+	tarReader := tar.NewReader(gzipReader)
+	header, err := tarReader.Next()
+	assert.NoError(t, err)
+	// The size in the header is not trusted.
+	header.Size = 5
+	_, _, err = extractBundleEntry(tarReader, header, filepath.Join(t.TempDir(), "file"), 5)
+	assert.ErrorContains(t, err, "exceeds the maximum extracted size")
+	header.Size = 10
+	_, _, err = extractBundleEntry(tarReader, header, filepath.Join(t.TempDir(), "file"), 9)
+	assert.ErrorContains(t, err, "exceeds the maximum extracted size")
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Exit status used when the run has been cancelled by SIGINT or SIGTERM.
+const exitStatusCancelled = 130
+
+var errCommandCancelled = errors.New("command cancelled")
+
+// Status code of DownloadInfo for packages whose download has been cancelled.
+const downloadStatusCancelled = -6
+
+// newRunContext returns the context which is cancelled when scribe receives SIGINT or SIGTERM.
+// Upon cancellation, running R CMD processes are killed, no new downloads, installations or checks
+// are started, and the results collected so far are saved and included in the report.
+// Receiving the signal for the second time terminates scribe immediately.
+func newRunContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		// Restore the default behavior, so that the next signal terminates scribe.
+		stop()
+		log.Warn("Cancelling the run. Results collected so far will be saved and included in the report. ",
+			"Send the signal again to exit immediately.")
+	}()
+	return ctx, stop
+}
+
+// isCancelled checks whether the run has been cancelled.
+func isCancelled(ctx context.Context) bool {
+	return ctx.Err() != nil
+}
+
+// exitIfCancelled terminates scribe with exitStatusCancelled if the run has been cancelled.
+func exitIfCancelled(ctx context.Context) {
+	if isCancelled(ctx) {
+		os.Exit(exitStatusCancelled)
+	}
+}
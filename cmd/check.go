@@ -17,6 +17,8 @@ package cmd
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"os"
 	"regexp"
 	"sort"
@@ -24,22 +26,29 @@ import (
 	"time"
 )
 
-var checkLogPath = "/tmp/scribe/check_logs"
+var checkLogPath string
 
 const errConst = "ERROR"
 const warnConst = "WARNING"
 const noteConst = "NOTE"
+const timeoutConst = "TIMEOUT"
+const cancelledConst = "CANCELLED"
 
 type ItemCheckInfo struct {
 	CheckItemType    string // NOTE, WARNING or ERROR
 	CheckItemContent string // content of NOTE, WARNING or ERROR
 }
 
+type CmdCheckChanInfo struct {
+	Output string
+	Err    error
+}
+
 type PackageCheckInfo struct {
 	PackagePath         string // path to directory where the package has been installed
 	PackageName         string
 	LogFilePath         string // path to the file containing log of R CMD check for the package
-	MostSevereCheckItem string // OK, NOTE, WARNING or ERROR
+	MostSevereCheckItem string // OK, NOTE, WARNING, ERROR, TIMEOUT or CANCELLED
 	Info                []ItemCheckInfo
 	CheckTime           int
 	ShouldFail          bool // Whether a NOTE or WARNING occurred that would cause the check to fail.
@@ -200,8 +209,9 @@ results_receiver_loop:
 }
 
 // runCmdCheck executes R CMD check for a given package in a goroutine.
-func runCmdCheck(cmdCheckChan chan string, packageFile string, logFilePath string,
-	additionalOptions string) {
+// R CMD check is killed if it doesn't complete within the timeout.
+func runCmdCheck(ctx context.Context, cmdCheckChan chan CmdCheckChanInfo, packageFile string, logFilePath string,
+	additionalOptions string, timeout time.Duration) {
 	log.Trace("Check logs/outputs will be saved to ", logFilePath, ".")
 	logFile, err := os.OpenFile(logFilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
 	checkError(err)
@@ -209,30 +219,30 @@ func runCmdCheck(cmdCheckChan chan string, packageFile string, logFilePath strin
 	// Add HTML tags to highlight logs
 	if _, createHTMLTagsErr := logFile.Write([]byte("<pre><code>\n")); createHTMLTagsErr != nil {
 		log.Error("Error saving log to ", logFilePath, ": ", createHTMLTagsErr)
-		cmdCheckChan <- ""
+		cmdCheckChan <- CmdCheckChanInfo{"", createHTMLTagsErr}
 		return
 	}
 	cmd := rExecutable + " CMD check " + additionalOptions + " " + packageFile
 	log.Debug("Executing command: ", cmd)
-	output, err := execCommand(cmd, false,
+	output, err := execCommandContext(ctx, cmd, timeout, false,
 		[]string{rLibsVarName + rLibsPaths, "LANG=en_US.UTF-8"}, logFile, true)
 	checkError(err)
 	// Close HTML tags
 	if _, closeHTMLTagsErr := logFile.Write([]byte("\n</code></pre>\n")); closeHTMLTagsErr != nil {
 		log.Error("Error saving log to ", logFilePath, ": ", closeHTMLTagsErr)
-		cmdCheckChan <- ""
+		cmdCheckChan <- CmdCheckChanInfo{"", closeHTMLTagsErr}
 		return
 	}
-	cmdCheckChan <- output
+	cmdCheckChan <- CmdCheckChanInfo{output, err}
 }
 
 // checkSinglePackage runs the goroutine with R CMD check for a single package, and waits for its completion.
-func checkSinglePackage(messages chan PackageCheckInfo, guard chan struct{},
+func checkSinglePackage(ctx context.Context, messages chan PackageCheckInfo, guard chan struct{},
 	packageFile string, additionalOptions string) {
-	cmdCheckChan := make(chan string)
+	cmdCheckChan := make(chan CmdCheckChanInfo)
 	packageName := strings.Split(packageFile, "_")[0]
 	logFilePath := checkLogPath + "/" + packageName + htmlExtension
-	go runCmdCheck(cmdCheckChan, packageFile, logFilePath, additionalOptions)
+	go runCmdCheck(ctx, cmdCheckChan, packageFile, logFilePath, additionalOptions, getTimeout(packageName, checkStage))
 	var singlePackageCheckInfo []ItemCheckInfo
 	var waitInterval = 1
 	var totalWaitTime = 0
@@ -241,7 +251,13 @@ check_single_package_loop:
 	for {
 		select {
 		case msg := <-cmdCheckChan:
-			mostSevereCheckItem, shouldFail := parseCheckOutput(msg, &singlePackageCheckInfo, packageName)
+			mostSevereCheckItem, shouldFail := parseCheckOutput(msg.Output, &singlePackageCheckInfo, packageName)
+			switch {
+			case errors.Is(msg.Err, errCommandCancelled):
+				mostSevereCheckItem = cancelledConst
+			case errors.Is(msg.Err, errCommandTimeout):
+				mostSevereCheckItem = timeoutConst
+			}
 			messages <- PackageCheckInfo{packageFile, packageName, logFilePath,
 				mostSevereCheckItem, singlePackageCheckInfo, totalWaitTime, shouldFail}
 			<-guard
@@ -302,13 +318,39 @@ func getCheckedPackages(checkExpression string, checkAllPackages bool, builtPack
 	return checkPackageFiles
 }
 
-func checkPackages(outputFile string, additionalOptions string) {
+// filterReusedCheckResults removes from the list of package files those packages for which
+// check results from the previous run are reused.
+func filterReusedCheckResults(checkPackagesFiles []string, reusableCheckInfo []PackageCheckInfo) []string {
+	var filteredPackagesFiles []string
+	for _, packageFile := range checkPackagesFiles {
+		packageName := strings.Split(packageFile, "_")[0]
+		reused := false
+		for _, p := range reusableCheckInfo {
+			if p.PackageName == packageName {
+				reused = true
+				break
+			}
+		}
+		if reused {
+			log.Info("Reusing R CMD check results of ", packageName, " from the previous run.")
+		} else {
+			filteredPackagesFiles = append(filteredPackagesFiles, packageFile)
+		}
+	}
+	return filteredPackagesFiles
+}
+
+// checkPackages runs R CMD check on packages matching the check expression, except for packages
+// with results in reusableCheckInfo, and saves the results of all of them to outputFile.
+func checkPackages(ctx context.Context, outputFile string, additionalOptions string, reusableCheckInfo []PackageCheckInfo) {
 	err := os.MkdirAll(checkLogPath, os.ModePerm)
 	checkError(err)
 	// Built packages are stored in current directory.
 	// Check component assumes that tar.gz packages which should be checked
 	// have been previously built and saved to current working directory.
-	checkPackagesFiles := getCheckedPackages(checkPackageExpression, checkAllPackages, ".")
+	checkPackagesFiles := filterReusedCheckResults(
+		getCheckedPackages(checkPackageExpression, checkAllPackages, "."), reusableCheckInfo,
+	)
 	// Channel to wait until all checks have completed.
 	checkWaiter := make(chan struct{})
 
@@ -323,13 +365,32 @@ func checkPackages(outputFile string, additionalOptions string) {
 	if len(checkPackagesFiles) > 0 {
 		go checkResultsReceiver(messages, checkWaiter, len(checkPackagesFiles), outputFile)
 		for _, packageFile := range checkPackagesFiles {
-			guard <- struct{}{}
-			go checkSinglePackage(messages, guard, packageFile, additionalOptions)
+			select {
+			case <-ctx.Done():
+			case guard <- struct{}{}:
+			}
+			if isCancelled(ctx) {
+				// Don't start any new checks, but send the results so that they're saved together with other results.
+				packageName := strings.Split(packageFile, "_")[0]
+				log.Warn("R CMD check ", packageFile, " cancelled.")
+				messages <- PackageCheckInfo{PackagePath: packageFile, PackageName: packageName,
+					MostSevereCheckItem: cancelledConst}
+				continue
+			}
+			go checkSinglePackage(ctx, messages, guard, packageFile, additionalOptions)
 		}
 		<-checkWaiter
 	}
 	systemMetricsWaiter <- struct{}{}
 	// Wait until the metrics routine finishes saving the output files.
 	<-systemMetricsWaiter
+	if len(reusableCheckInfo) > 0 {
+		var allPackagesCheckInfo []PackageCheckInfo
+		if _, err = os.Stat(outputFile); err == nil {
+			readJSON(outputFile, &allPackagesCheckInfo)
+		}
+		allPackagesCheckInfo = append(allPackagesCheckInfo, reusableCheckInfo...)
+		writeJSON(outputFile, allPackagesCheckInfo)
+	}
 	log.Info("Finished checking all packages.")
 }
@@ -125,3 +125,15 @@ func Test_getCheckedPackages(t *testing.T) {
 			"teal_0.0.2.tar.gz",
 		})
 }
+
+func Test_filterReusedCheckResults(t *testing.T) {
+	reusableCheckInfo := []PackageCheckInfo{
+		{PackageName: "teal", MostSevereCheckItem: "OK"},
+		{PackageName: "tern", MostSevereCheckItem: "NOTE"},
+	}
+	assert.Equal(t,
+		filterReusedCheckResults([]string{"teal_0.0.2.tar.gz", "teal.slice_0.0.3.tar.gz", "tern_0.0.1.tar.gz"},
+			reusableCheckInfo),
+		[]string{"teal.slice_0.0.3.tar.gz"},
+	)
+}
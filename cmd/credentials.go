@@ -0,0 +1,253 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// RepositoryCredentials are credentials used to access a private package repository,
+// either with basic authentication (Username and Password) or with bearer Token.
+type RepositoryCredentials struct {
+	// Name or URL of the repository as defined in the renv.lock header.
+	Repository string `mapstructure:"repository"`
+	Username   string `mapstructure:"username"`
+	Password   string `mapstructure:"password"`
+	Token      string `mapstructure:"token"`
+}
+
+// Map from repository URL to credentials used for requests to URLs starting with the repository URL.
+// Initialized in loadRenvLock.
+var repositoryCredentials map[string]RepositoryCredentials
+
+// Prefix of environment variables with repository credentials, e.g. SCRIBE_REPOSITORY_INTERNAL_TOKEN.
+const repositoryCredentialsEnvPrefix = "SCRIBE_REPOSITORY_"
+
+var nonAlphanumericRegex = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// getRepositoryEnvName returns the part of environment variable names with credentials
+// corresponding to the repository name, e.g. "INTERNAL_CRAN" for "internal-cran".
+func getRepositoryEnvName(repositoryName string) string {
+	return nonAlphanumericRegex.ReplaceAllString(strings.ToUpper(repositoryName), "_")
+}
+
+// hasCredentials checks whether any credentials have been provided.
+func (c RepositoryCredentials) hasCredentials() bool {
+	return c.Token != "" || c.Username != ""
+}
+
+// parseNetrc parses the contents of .netrc file. Returns map from machine name to credentials,
+// where credentials of the "default" entry are stored under empty machine name.
+func parseNetrc(contents string) map[string]RepositoryCredentials {
+	netrcCredentials := make(map[string]RepositoryCredentials)
+	var machine string
+	var inMachine bool
+	var inMacro bool
+	var credentials RepositoryCredentials
+	saveMachine := func() {
+		if inMachine {
+			netrcCredentials[machine] = credentials
+		}
+		inMachine = false
+		credentials = RepositoryCredentials{}
+	}
+	for _, line := range strings.Split(contents, "\n") {
+		if inMacro {
+			// Macro definition ends with an empty line.
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			switch fields[i] {
+			case "machine":
+				saveMachine()
+				if i+1 < len(fields) {
+					i++
+					machine = fields[i]
+					inMachine = true
+				}
+			case "default":
+				saveMachine()
+				machine = ""
+				inMachine = true
+			case "login":
+				if i+1 < len(fields) {
+					i++
+					credentials.Username = fields[i]
+				}
+			case "password":
+				if i+1 < len(fields) {
+					i++
+					credentials.Password = fields[i]
+				}
+			case "account":
+				i++
+			case "macdef":
+				saveMachine()
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	saveMachine()
+	return netrcCredentials
+}
+
+// getNetrcPath returns path to the .netrc file: the value of NETRC environment variable, or .netrc
+// (_netrc on Windows) in the home directory.
+func getNetrcPath() string {
+	if netrcPath := os.Getenv("NETRC"); netrcPath != "" {
+		return netrcPath
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	if _, err := os.Stat(filepath.Join(home, ".netrc")); err != nil && os.Getenv("OS") == "Windows_NT" {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// readNetrc returns credentials from .netrc file, or empty map if the file doesn't exist.
+func readNetrc(netrcPath string) map[string]RepositoryCredentials {
+	contents, err := os.ReadFile(netrcPath)
+	if err != nil {
+		return make(map[string]RepositoryCredentials)
+	}
+	log.Debug("Reading credentials from ", netrcPath)
+	return parseNetrc(string(contents))
+}
+
+// getRepositoryCredentials returns map from repository URL to credentials used to access the repository.
+// For each of the repositories, credentials are taken from the first of the following sources:
+// * configCredentials entry with Repository equal to the repository name or URL,
+// * SCRIBE_REPOSITORY_<NAME>_TOKEN or SCRIBE_REPOSITORY_<NAME>_USERNAME and SCRIBE_REPOSITORY_<NAME>_PASSWORD
+// environment variables, where <NAME> is the upper-cased repository name,
+// * netrcCredentials entry for the repository host.
+// Additionally, configCredentials entries with Repository being a URL are used for that URL
+// (e.g. URL of a repository mirror). Credentials of repositories not using HTTPS are skipped.
+func getRepositoryCredentials(repositories []Rrepository, configCredentials []RepositoryCredentials,
+	netrcCredentials map[string]RepositoryCredentials) map[string]RepositoryCredentials {
+	credentialsMap := make(map[string]RepositoryCredentials)
+	for _, c := range configCredentials {
+		switch {
+		case !strings.Contains(c.Repository, "://") || !c.hasCredentials():
+		case !strings.HasPrefix(c.Repository, "https://"):
+			log.Warn("Not using credentials from configuration file for ", c.Repository,
+				", because they would be sent unencrypted.")
+		default:
+			credentialsMap[strings.TrimSuffix(c.Repository, "/")] = c
+		}
+	}
+	for _, repository := range repositories {
+		repositoryURL := strings.TrimSuffix(repository.URL, "/")
+		var credentials RepositoryCredentials
+		var source string
+		for _, c := range configCredentials {
+			if c.Repository == repository.Name || strings.TrimSuffix(c.Repository, "/") == repositoryURL {
+				credentials = c
+				source = "configuration file"
+				break
+			}
+		}
+		if !credentials.hasCredentials() {
+			envName := repositoryCredentialsEnvPrefix + getRepositoryEnvName(repository.Name)
+			credentials = RepositoryCredentials{
+				Username: os.Getenv(envName + "_USERNAME"),
+				Password: os.Getenv(envName + "_PASSWORD"),
+				Token:    os.Getenv(envName + "_TOKEN"),
+			}
+			source = "environment variables"
+		}
+		if !credentials.hasCredentials() {
+			parsedURL, err := url.Parse(repositoryURL)
+			if err == nil {
+				var ok bool
+				credentials, ok = netrcCredentials[parsedURL.Hostname()]
+				if !ok {
+					credentials = netrcCredentials[""]
+				}
+				source = ".netrc"
+			}
+		}
+		if credentials.hasCredentials() && !strings.HasPrefix(repositoryURL, "https://") {
+			log.Warn("Not using credentials from ", source, " for repository ", repository.Name,
+				", because they would be sent unencrypted to ", repositoryURL, ".")
+			continue
+		}
+		if credentials.hasCredentials() {
+			log.Info("Using credentials from ", source, " for repository ", repository.Name, ".")
+			credentials.Repository = repository.Name
+			credentialsMap[repositoryURL] = credentials
+		}
+	}
+	return credentialsMap
+}
+
+// initializeRepositoryCredentials resolves credentials of repositories from the renv.lock header.
+func initializeRepositoryCredentials(repositories []Rrepository) {
+	var configCredentials []RepositoryCredentials
+	err := viper.UnmarshalKey("repositoryCredentials", &configCredentials)
+	if err != nil {
+		log.Error("Couldn't read repositoryCredentials from the configuration file: ", err)
+	}
+	repositoryCredentials = getRepositoryCredentials(repositories, configCredentials, readNetrc(getNetrcPath()))
+}
+
+// getURLCredentials returns credentials of the repository which the URL belongs to.
+// If URL belongs to multiple repositories, the one with the longest URL is chosen.
+func getURLCredentials(requestURL string) (RepositoryCredentials, bool) {
+	var credentials RepositoryCredentials
+	var longestRepositoryURL string
+	for repositoryURL, c := range repositoryCredentials {
+		if (requestURL == repositoryURL || strings.HasPrefix(requestURL, repositoryURL+"/")) &&
+			len(repositoryURL) > len(longestRepositoryURL) {
+			credentials = c
+			longestRepositoryURL = repositoryURL
+		}
+	}
+	return credentials, longestRepositoryURL != ""
+}
+
+// credentialsTransport adds credentials to requests to private package repositories.
+// Credentials are only sent over HTTPS.
+type credentialsTransport struct {
+	base http.RoundTripper
+}
+
+func (t *credentialsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") == "" && req.URL.Scheme == "https" {
+		if credentials, ok := getURLCredentials(req.URL.String()); ok {
+			// RoundTripper must not modify the request.
+			req = req.Clone(req.Context())
+			if credentials.Token != "" {
+				req.Header.Set("Authorization", "Bearer "+credentials.Token)
+			} else {
+				req.SetBasicAuth(credentials.Username, credentials.Password)
+			}
+		}
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseNetrc(t *testing.T) {
+	netrcCredentials := parseNetrc(`machine packages.example.com
+  login user1
+  password pass1
+
+macdef init
+machine not-a-machine login x password y
+
+machine cran.example.com login user2 account acc password pass2
+default login anonymous password guest
+`)
+	assert.Equal(t, netrcCredentials, map[string]RepositoryCredentials{
+		"packages.example.com": {Username: "user1", Password: "pass1"},
+		"cran.example.com":     {Username: "user2", Password: "pass2"},
+		"":                     {Username: "anonymous", Password: "guest"},
+	})
+}
+
+func Test_getRepositoryEnvName(t *testing.T) {
+	assert.Equal(t, getRepositoryEnvName("internal-cran"), "INTERNAL_CRAN")
+	assert.Equal(t, getRepositoryEnvName("CRAN"), "CRAN")
+	assert.Equal(t, getRepositoryEnvName("ppm.snapshot"), "PPM_SNAPSHOT")
+}
+
+func Test_getRepositoryCredentials(t *testing.T) {
+	t.Setenv("SCRIBE_REPOSITORY_INTERNAL_CRAN_TOKEN", "env-token")
+	t.Setenv("SCRIBE_REPOSITORY_PPM_USERNAME", "env-user")
+	t.Setenv("SCRIBE_REPOSITORY_PPM_PASSWORD", "env-password")
+	repositories := []Rrepository{
+		{"CRAN", "https://cloud.r-project.org"},
+		{"internal-cran", "https://packages.example.com/internal/"},
+		{"PPM", "https://ppm.example.com/cran/latest"},
+		{"artifactory", "https://artifactory.example.com/api/cran/r"},
+		{"plain-http", "http://plain.example.com/cran"},
+	}
+	configCredentials := []RepositoryCredentials{
+		{Repository: "https://packages.example.com/internal", Token: "config-token"},
+		{Repository: "https://mirror.example.com/cran/", Username: "mirror-user", Password: "mirror-password"},
+		// Credentials are not sent over unencrypted connections.
+		{Repository: "plain-http", Token: "plain-token"},
+		{Repository: "http://plain-mirror.example.com/cran", Token: "plain-token"},
+	}
+	netrcCredentials := map[string]RepositoryCredentials{
+		"ppm.example.com":            {Username: "netrc-user", Password: "netrc-password"},
+		"artifactory.example.com":    {Username: "netrc-user2", Password: "netrc-password2"},
+		"unrelated-host.example.com": {Username: "netrc-user3", Password: "netrc-password3"},
+	}
+	assert.Equal(t, getRepositoryCredentials(repositories, configCredentials, netrcCredentials),
+		map[string]RepositoryCredentials{
+			"https://packages.example.com/internal": {
+				Repository: "internal-cran", Token: "config-token",
+			},
+			"https://ppm.example.com/cran/latest": {
+				Repository: "PPM", Username: "env-user", Password: "env-password",
+			},
+			"https://artifactory.example.com/api/cran/r": {
+				Repository: "artifactory", Username: "netrc-user2", Password: "netrc-password2",
+			},
+			"https://mirror.example.com/cran": {
+				Repository: "https://mirror.example.com/cran/", Username: "mirror-user", Password: "mirror-password",
+			},
+		})
+}
+
+func Test_credentialsTransport(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	})
+	server := httptest.NewTLSServer(handler)
+	defer server.Close()
+	plainServer := httptest.NewServer(handler)
+	defer plainServer.Close()
+	previousHTTPClient := httpClient
+	previousRepositoryCredentials := repositoryCredentials
+	defer func() {
+		httpClient = previousHTTPClient
+		repositoryCredentials = previousRepositoryCredentials
+	}()
+	repositoryCredentials = map[string]RepositoryCredentials{
+		server.URL + "/private":       {Username: "user", Password: "password"},
+		server.URL + "/private/token": {Token: "token"},
+		plainServer.URL + "/private":  {Token: "token"},
+	}
+	var err error
+	httpClient, _, err = newHTTPClient(writeServerCertificate(t, server), false)
+	assert.NoError(t, err)
+
+	_, body, err := downloadTextFile(server.URL+"/private/src/contrib/PACKAGES", map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, body, "Basic dXNlcjpwYXNzd29yZA==")
+	_, body, err = downloadTextFile(server.URL+"/private/token/src/contrib/PACKAGES", map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, body, "Bearer token")
+	_, body, err = downloadTextFile(server.URL+"/private-other/src/contrib/PACKAGES", map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, body, "")
+	// Explicitly provided Authorization header is not overridden.
+	_, body, err = downloadTextFile(server.URL+"/private/src/contrib/PACKAGES",
+		map[string]string{"Authorization": "Bearer explicit"})
+	assert.NoError(t, err)
+	assert.Equal(t, body, "Bearer explicit")
+	// Credentials are not sent over HTTP.
+	_, body, err = downloadTextFile(plainServer.URL+"/private/src/contrib/PACKAGES", map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, body, "")
+}
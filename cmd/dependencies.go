@@ -18,57 +18,120 @@ package cmd
 
 import (
 	"os"
+	"sort"
+	"strings"
 
 	locksmith "github.com/insightsengineering/locksmith/cmd"
 	yaml "gopkg.in/yaml.v3"
 )
 
+// getMissingSuggests returns names of Suggested packages which are not base R packages and
+// are not in renv.lock, i.e. their download hasn't been attempted. Such packages are not installed,
+// so they're reported as unsatisfied optional dependencies which may be required by R CMD check.
+func getMissingSuggests(dependencies []locksmith.Dependency, downloadedPackages map[string]DownloadedPackage) []string {
+	var missingSuggests []string
+	for _, dependency := range dependencies {
+		if dependency.DependencyType != "Suggests" || locksmith.CheckIfBasePackage(dependency.DependencyName) {
+			continue
+		}
+		if _, ok := downloadedPackages[dependency.DependencyName]; !ok &&
+			!stringInSlice(dependency.DependencyName, missingSuggests) {
+			missingSuggests = append(missingSuggests, dependency.DependencyName)
+		}
+	}
+	sort.Strings(missingSuggests)
+	return missingSuggests
+}
+
+// isDependencyRequired checks whether the dependency should be installed before the package depending on it.
+// This is the case if the dependency has been successfully downloaded, or if its download failed and
+// it's not a Suggested package, in which case the depending package can't be installed at all.
+// Dependencies which are not in renv.lock are not required.
+func isDependencyRequired(dependency locksmith.Dependency, downloadedPackages map[string]DownloadedPackage) bool {
+	downloadedDependency, ok := downloadedPackages[dependency.DependencyName]
+	if !ok {
+		return false
+	}
+	return downloadedDependency.Location != "" || dependency.DependencyType != "Suggests"
+}
+
+// isSoftDependency checks whether the dependency is an install-order edge which is dropped in case
+// it closes a dependency cycle. Suggested packages are not required to install the package.
+// Depends, Imports and LinkingTo dependencies are hard (headers of packages from LinkingTo have to be
+// installed before the package is compiled) - a cycle consisting of them can't be broken.
+func isSoftDependency(dependency locksmith.Dependency) bool {
+	return dependency.DependencyType == "Suggests"
+}
+
+// getSoftDependencies returns the names from filteredDependencies which the package requires
+// only as soft dependencies (see isSoftDependency).
+func getSoftDependencies(dependencies []locksmith.Dependency, filteredDependencies []string) []string {
+	var hardDependencies []string
+	for _, dependency := range dependencies {
+		if !isSoftDependency(dependency) {
+			hardDependencies = append(hardDependencies, dependency.DependencyName)
+		}
+	}
+	var softDependencies []string
+	for _, d := range filteredDependencies {
+		if !stringInSlice(d, hardDependencies) {
+			softDependencies = append(softDependencies, d)
+		}
+	}
+	return softDependencies
+}
+
 // getPackageDepsFromPackagesFile retrieves the list of relevant dependencies
 // of a given package from PACKAGES file structure of the repository from which
-// the package has been downloaded.
+// the package has been downloaded. Additionally, returns the list of soft dependencies
+// (subset of the relevant dependencies), and the list of Suggested packages missing from renv.lock.
 func getPackageDepsFromPackagesFile(
 	packageName string,
 	packagesFile locksmith.PackagesFile,
 	downloadedPackages map[string]DownloadedPackage,
-) []string {
+) ([]string, []string, []string) {
 	var packageDependencies []string
+	var softDependencies []string
+	var missingSuggests []string
 	// Find the packageName in the PACKAGES file.
 	for _, packagesEntry := range packagesFile.Packages {
 		if packagesEntry.Package == packageName {
 			// Read its dependencies.
 			for _, dependency := range packagesEntry.Dependencies {
-				// Check if the dependency has been successfully downloaded.
-				downloadedDependency, ok := downloadedPackages[dependency.DependencyName]
-				var dependencyLocation string
-				if ok {
-					dependencyLocation = downloadedDependency.Location
-				}
 				// Only add the dependency to the list of package dependencies,
-				// if it's not a base R package, and it has been successfully downloaded,
+				// if it's not a base R package, and its download has been attempted,
 				// and it hasn't been added to the list yet.
+				// Dependencies which failed to download are kept, so that the package
+				// is skipped during installation instead of failing with a missing dependency.
 				// Dependencies are retrieved from PACKAGES file only for packages not downloaded
-				// from git repositories, and for such packages Suggested packages are not treated
-				// as dependencies.
+				// from git repositories, and for such packages Suggested packages are treated
+				// as dependencies only if includeSuggests is set.
 				if !locksmith.CheckIfBasePackage(dependency.DependencyName) &&
-					dependencyLocation != "" &&
+					isDependencyRequired(dependency, downloadedPackages) &&
 					!stringInSlice(dependency.DependencyName, packageDependencies) &&
-					dependency.DependencyType != "Suggests" {
+					(dependency.DependencyType != "Suggests" || includeSuggests) {
 					packageDependencies = append(packageDependencies, dependency.DependencyName)
 				}
 			}
+			softDependencies = getSoftDependencies(packagesEntry.Dependencies, packageDependencies)
+			missingSuggests = getMissingSuggests(packagesEntry.Dependencies, downloadedPackages)
 			break
 		}
 	}
-	return packageDependencies
+	return packageDependencies, softDependencies, missingSuggests
 }
 
 // getDepsFromPackagesFiles downloads PACKAGES files from each of rRepositories.
-// It saves map entries (to packageDependencies) from package name to the list of package dependencies.
+// It saves map entries (to packageDependencies) from package name to the list of package dependencies,
+// (to softDependencies) from package name to the list of its soft dependencies,
+// and (to missingSuggests) from package name to the list of Suggested packages missing from renv.lock.
 func getDepsFromPackagesFiles(
 	rPackages map[string]Rpackage,
 	rRepositories []Rrepository,
 	downloadedPackages map[string]DownloadedPackage,
 	packageDependencies map[string][]string,
+	softDependencies map[string][]string,
+	missingSuggests map[string][]string,
 	downloadFileFunction func(string, map[string]string) (int64, string, error),
 	erroneousRepositoryNames []string,
 ) {
@@ -92,13 +155,15 @@ func getDepsFromPackagesFiles(
 			// Retrieve information about package dependencies from the PACKAGES file.
 			// The PACKAGES file is downloaded from the same repository as the package
 			// (according to the renv.lock).
-			// In particular, dependencies for GitHub/GitLab packages will NOT be read
-			// from PACKAGES file, since the packageRepository == "GitHub"/"GitLab" for them.
+			// In particular, dependencies for packages from git repositories will NOT be read
+			// from PACKAGES file, since the packageRepository == "GitHub"/"GitLab"/"Bitbucket"/"Gitea"/"git" for them.
 			if packageRepository == repository.Name {
-				packageDeps := getPackageDepsFromPackagesFile(
+				packageDeps, packageSoftDeps, packageMissingSuggests := getPackageDepsFromPackagesFile(
 					packageName, packagesFile, downloadedPackages)
 				log.Debug(packageName, " → ", packageDeps)
 				packageDependencies[packageName] = packageDeps
+				softDependencies[packageName] = packageSoftDeps
+				missingSuggests[packageName] = packageMissingSuggests
 			}
 		}
 	}
@@ -125,22 +190,28 @@ func getDepsFromPackagesFiles(
 			continue
 		}
 		if stringInSlice(packageRepository, erroneousRepositoryNames) {
-			packageDeps := getPackageDepsFromPackagesFile(
+			packageDeps, packageSoftDeps, packageMissingSuggests := getPackageDepsFromPackagesFile(
 				packageName, cranPackagesFile, downloadedPackages,
 			)
 			log.Debug(packageName, " → ", packageDeps)
 			packageDependencies[packageName] = packageDeps
+			softDependencies[packageName] = packageSoftDeps
+			missingSuggests[packageName] = packageMissingSuggests
 		}
 	}
 }
 
 // getDepsFromDescriptionFiles for each package downloaded as git repository, reads its dependencies
 // from the DESCRIPTION file. It saves map entries (to packageDependencies) from package name to
-// the list of package dependencies.
+// the list of package dependencies, (to softDependencies) from package name to the list of its
+// soft dependencies, and (to missingSuggests) from package name to the list of Suggested packages
+// missing from renv.lock.
 func getDepsFromDescriptionFiles(
 	rPackages map[string]Rpackage,
 	downloadedPackages map[string]DownloadedPackage,
 	packageDependencies map[string][]string,
+	softDependencies map[string][]string,
+	missingSuggests map[string][]string,
 ) {
 	// Iterate through packages from renv.lock.
 	for packageName := range rPackages {
@@ -152,7 +223,8 @@ func getDepsFromDescriptionFiles(
 			packageRepository = downloadedPackage.PackageRepository
 			packageLocation = downloadedPackage.Location
 		}
-		if packageRepository == GitLab || packageRepository == GitHub {
+		// Packages from r-universe may be cloned from git repositories too.
+		if isGitSource(packageRepository) || downloadedPackage.PackageType == gitConst {
 			if packageLocation == "" {
 				log.Warn("Skipping installation of ", packageName, " as it hasn't been downloaded properly.")
 				continue
@@ -171,24 +243,22 @@ func getDepsFromDescriptionFiles(
 			// Filter only relevant dependencies.
 			var filteredDependencies []string
 			for _, dependency := range packageDeps {
-				// Check if the dependency has been successfully downloaded.
-				downloadedDependency, ok := downloadedPackages[dependency.DependencyName]
-				var dependencyLocation string
-				if ok {
-					dependencyLocation = downloadedDependency.Location
-				}
 				// Only add the dependency to the list of package dependencies,
-				// if it's not a base R package, and it has been successfully downloaded,
+				// if it's not a base R package, and its download has been attempted,
 				// and it hasn't been added to the list yet. For packages downloaded
-				// from git, the Suggested packages are treated as ordinary dependencies.
+				// from git, the Suggested packages are treated as ordinary dependencies
+				// regardless of includeSuggests (unless they failed to download),
+				// because they may be required by R CMD build, e.g. to build vignettes.
 				if !locksmith.CheckIfBasePackage(dependency.DependencyName) &&
-					dependencyLocation != "" &&
+					isDependencyRequired(dependency, downloadedPackages) &&
 					!stringInSlice(dependency.DependencyName, filteredDependencies) {
 					filteredDependencies = append(filteredDependencies, dependency.DependencyName)
 				}
 			}
 			log.Debug(packageName, " → ", filteredDependencies)
 			packageDependencies[packageName] = filteredDependencies
+			softDependencies[packageName] = getSoftDependencies(packageDeps, filteredDependencies)
+			missingSuggests[packageName] = getMissingSuggests(packageDeps, downloadedPackages)
 		}
 	}
 }
@@ -198,20 +268,152 @@ func getPackageDeps(
 	rRepositories []Rrepository,
 	downloadedPackages map[string]DownloadedPackage,
 	erroneousRepositoryNames []string,
-) map[string][]string {
+) (map[string][]string, map[string][]string, map[string][]string) {
 	// A map with keys being renv.lock package names, and values being lists of dependencies
 	// (packages that should be installed in the system before the package corresponding
 	// to map key can be installed).
 	packageDependencies := make(map[string][]string)
+	// A map with keys being renv.lock package names, and values being lists of soft dependencies
+	// (subsets of the lists of dependencies), which are dropped if they close a dependency cycle.
+	softDependencies := make(map[string][]string)
+	// A map with keys being renv.lock package names, and values being lists of Suggested
+	// packages which are not present in renv.lock.
+	missingSuggests := make(map[string][]string)
 
 	// If package is stored in tar.gz, get its dependencies from a corresponding
 	// entry in PACKAGES file in the repository pointed by renv.lock.
-	getDepsFromPackagesFiles(rPackages, rRepositories, downloadedPackages, packageDependencies,
-		locksmith.DownloadTextFile, erroneousRepositoryNames)
+	getDepsFromPackagesFiles(rPackages, rRepositories, downloadedPackages, packageDependencies, softDependencies,
+		missingSuggests, withPackagesTextSnapshots(downloadTextFile), erroneousRepositoryNames)
 
 	// If the package is stored in a cloned git repository, get its dependencies
 	// from its DESCRIPTION file.
-	getDepsFromDescriptionFiles(rPackages, downloadedPackages, packageDependencies)
+	getDepsFromDescriptionFiles(rPackages, downloadedPackages, packageDependencies, softDependencies, missingSuggests)
+
+	return packageDependencies, softDependencies, missingSuggests
+}
+
+// getStronglyConnectedComponents returns the strongly connected components of the dependency graph
+// which contain a cycle, i.e. components consisting of more than one package, or of a single package
+// depending on itself. Packages in each component, as well as the components, are sorted by name.
+func getStronglyConnectedComponents(dependencies map[string][]string) [][]string {
+	var packageNames []string
+	for packageName := range dependencies {
+		packageNames = append(packageNames, packageName)
+	}
+	sort.Strings(packageNames)
+
+	// Tarjan's algorithm.
+	index := make(map[string]int)
+	lowLink := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var components [][]string
+	var strongConnect func(string)
+	strongConnect = func(packageName string) {
+		index[packageName] = len(index)
+		lowLink[packageName] = index[packageName]
+		stack = append(stack, packageName)
+		onStack[packageName] = true
+		for _, d := range dependencies[packageName] {
+			if _, visited := index[d]; !visited {
+				strongConnect(d)
+				lowLink[packageName] = min(lowLink[packageName], lowLink[d])
+			} else if onStack[d] {
+				lowLink[packageName] = min(lowLink[packageName], index[d])
+			}
+		}
+		if lowLink[packageName] != index[packageName] {
+			return
+		}
+		var component []string
+		for {
+			p := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[p] = false
+			component = append(component, p)
+			if p == packageName {
+				break
+			}
+		}
+		if len(component) > 1 || stringInSlice(packageName, dependencies[packageName]) {
+			sort.Strings(component)
+			components = append(components, component)
+		}
+	}
+	for _, packageName := range packageNames {
+		if _, visited := index[packageName]; !visited {
+			strongConnect(packageName)
+		}
+	}
+	sort.Slice(components, func(i, j int) bool { return components[i][0] < components[j][0] })
+	return components
+}
+
+// getDependencyCycle returns the shortest cycle of dependencies within the component, starting
+// and ending with packageName, e.g. [package1, package2, package1].
+func getDependencyCycle(packageName string, component []string, dependencies map[string][]string) []string {
+	previous := make(map[string]string)
+	queue := []string{packageName}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		for _, d := range dependencies[p] {
+			if !stringInSlice(d, component) {
+				continue
+			}
+			if d == packageName {
+				cycle := []string{packageName}
+				for ; p != packageName; p = previous[p] {
+					cycle = append([]string{p}, cycle...)
+				}
+				return append([]string{packageName}, cycle...)
+			}
+			if _, visited := previous[d]; !visited {
+				previous[d] = p
+				queue = append(queue, d)
+			}
+		}
+	}
+	return nil
+}
 
-	return packageDependencies
+// breakDependencyCycles returns the dependency graph without the soft dependencies which close
+// a dependency cycle. All dependencies between packages from the same strongly connected component
+// are part of a cycle, so soft dependencies within such components are dropped. Any remaining
+// cycles consist of Depends and Imports dependencies only.
+func breakDependencyCycles(dependencies map[string][]string,
+	softDependencies map[string][]string) map[string][]string {
+	brokenDependencies := make(map[string][]string)
+	for packageName, packageDependencies := range dependencies {
+		brokenDependencies[packageName] = packageDependencies
+	}
+	for _, component := range getStronglyConnectedComponents(dependencies) {
+		for _, packageName := range component {
+			var packageDependencies []string
+			for _, d := range dependencies[packageName] {
+				if stringInSlice(d, component) && stringInSlice(d, softDependencies[packageName]) {
+					log.Warn("Ignoring soft dependency of ", packageName, " on ", d,
+						" because it closes a dependency cycle.")
+					continue
+				}
+				packageDependencies = append(packageDependencies, d)
+			}
+			brokenDependencies[packageName] = packageDependencies
+		}
+	}
+	return brokenDependencies
+}
+
+// getDependencyCycles checks whether the dependency graph contains any cycles. Returns the map from names
+// of packages which are part of a cycle to the shortest cycle containing the package.
+func getDependencyCycles(dependencies map[string][]string) map[string][]string {
+	dependencyCycles := make(map[string][]string)
+	for _, component := range getStronglyConnectedComponents(dependencies) {
+		log.Error("Dependency cycle detected between packages: ", strings.Join(component, ", "), ".")
+		for _, packageName := range component {
+			dependencyCycles[packageName] = getDependencyCycle(packageName, component, dependencies)
+			log.Error("  ", strings.Join(dependencyCycles[packageName], " → "))
+		}
+	}
+	return dependencyCycles
 }
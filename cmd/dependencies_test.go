@@ -18,6 +18,7 @@ package cmd
 import (
 	"testing"
 
+	locksmith "github.com/insightsengineering/locksmith/cmd"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -27,7 +28,7 @@ func mockedDownloadTextFile(url string, _ map[string]string) (int64, string, err
 		return 0, `Package: package1
 Version: 1.0.0
 Imports: package2, package3 (>= 1.0.2)
-Suggests: package5
+Suggests: package5, package6, tools
 
 Package: package2
 Version: 1.0.0
@@ -55,11 +56,12 @@ func Test_getDepsFromPackagesFiles(t *testing.T) {
 	rPackages := make(map[string]Rpackage)
 	downloadedPackages := make(map[string]DownloadedPackage)
 	packageDependencies := make(map[string][]string)
-	rPackages["package1"] = Rpackage{"package1", "", "", "Repository1", "", "", []string{}, "", "", "", "", "", ""}
-	rPackages["package2"] = Rpackage{"package2", "", "", "Repository1", "", "", []string{}, "", "", "", "", "", ""}
-	rPackages["package3"] = Rpackage{"package3", "", "", "Repository2", "", "", []string{}, "", "", "", "", "", ""}
-	rPackages["package4"] = Rpackage{"package4", "", "", "Repository2", "", "", []string{}, "", "", "", "", "", ""}
-	rPackages["package5"] = Rpackage{"package5", "", "", "UndefinedRepository", "", "", []string{}, "", "", "", "", "", ""}
+	softDependencies := make(map[string][]string)
+	rPackages["package1"] = Rpackage{"package1", "", "", "Repository1", "", "", []string{}, "", "", "", "", "", "", ""}
+	rPackages["package2"] = Rpackage{"package2", "", "", "Repository1", "", "", []string{}, "", "", "", "", "", "", ""}
+	rPackages["package3"] = Rpackage{"package3", "", "", "Repository2", "", "", []string{}, "", "", "", "", "", "", ""}
+	rPackages["package4"] = Rpackage{"package4", "", "", "Repository2", "", "", []string{}, "", "", "", "", "", "", ""}
+	rPackages["package5"] = Rpackage{"package5", "", "", "UndefinedRepository", "", "", []string{}, "", "", "", "", "", "", ""}
 	downloadedPackages["package1"] = DownloadedPackage{"", "", "Repository1", "/tmp/scribe/downloaded_packages/package_archives/package1_1.0.0.tar.gz"}
 	downloadedPackages["package2"] = DownloadedPackage{"", "", "Repository1", "/tmp/scribe/downloaded_packages/package_archives/package2_1.0.0.tar.gz"}
 	downloadedPackages["package3"] = DownloadedPackage{"", "", "Repository2", "/tmp/scribe/downloaded_packages/package_archives/package3_1.0.0.tar.gz"}
@@ -68,30 +70,152 @@ func Test_getDepsFromPackagesFiles(t *testing.T) {
 		{"Repository1", "https://repository1.example.com"},
 		{"Repository2", "https://repository2.example.com"},
 	}
-	getDepsFromPackagesFiles(rPackages, rRepositories, downloadedPackages, packageDependencies,
-		mockedDownloadTextFile, []string{"UndefinedRepository"})
+	missingSuggests := make(map[string][]string)
+	includeSuggests = false
+	getDepsFromPackagesFiles(rPackages, rRepositories, downloadedPackages, packageDependencies, softDependencies,
+		missingSuggests, mockedDownloadTextFile, []string{"UndefinedRepository"})
 	assert.Equal(t, packageDependencies["package1"], []string{"package2", "package3"})
 	assert.Equal(t, packageDependencies["package2"], []string{"package3"})
 	assert.Equal(t, len(packageDependencies["package3"]), 0)
 	assert.Equal(t, len(packageDependencies["package4"]), 0)
 	assert.Equal(t, packageDependencies["package5"], []string{"package1"})
+	assert.Equal(t, missingSuggests["package1"], []string{"package6"})
+	assert.Equal(t, len(missingSuggests["package2"]), 0)
+
+	includeSuggests = true
+	defer func() { includeSuggests = false }()
+	getDepsFromPackagesFiles(rPackages, rRepositories, downloadedPackages, packageDependencies, softDependencies,
+		missingSuggests, mockedDownloadTextFile, []string{"UndefinedRepository"})
+	assert.Equal(t, packageDependencies["package1"], []string{"package2", "package3", "package5"})
+	assert.Equal(t, packageDependencies["package2"], []string{"package3"})
+	assert.Equal(t, softDependencies["package1"], []string{"package5"})
+	assert.Equal(t, len(softDependencies["package5"]), 0)
+	assert.Equal(t, missingSuggests["package1"], []string{"package6"})
+}
+
+func Test_getMissingSuggests(t *testing.T) {
+	downloadedPackages := make(map[string]DownloadedPackage)
+	downloadedPackages["package1"] = DownloadedPackage{"", "", "Repository1", ""}
+	dependencies := []locksmith.Dependency{
+		{DependencyType: "Imports", DependencyName: "package2"},
+		{DependencyType: "Suggests", DependencyName: "package1"},
+		{DependencyType: "Suggests", DependencyName: "package4"},
+		{DependencyType: "Suggests", DependencyName: "package3"},
+		{DependencyType: "Suggests", DependencyName: "utils"},
+		{DependencyType: "Suggests", DependencyName: "package3"},
+	}
+	assert.Equal(t, getMissingSuggests(dependencies, downloadedPackages), []string{"package3", "package4"})
+	assert.Equal(t, len(getMissingSuggests([]locksmith.Dependency{}, downloadedPackages)), 0)
 }
 
 func Test_getDepsFromDescriptionFiles(t *testing.T) {
 	rPackages := make(map[string]Rpackage)
 	downloadedPackages := make(map[string]DownloadedPackage)
 	packageDependencies := make(map[string][]string)
-	rPackages["package1"] = Rpackage{"package1", "", "", "", "", "", []string{}, "", "", "", "", "", ""}
-	rPackages["package2"] = Rpackage{"package2", "", "", "", "", "", []string{}, "", "", "", "", "", ""}
-	rPackages["package3"] = Rpackage{"package3", "", "", "", "", "", []string{}, "", "", "", "", "", ""}
-	rPackages["package4"] = Rpackage{"package4", "", "", "", "", "", []string{}, "", "", "", "", "", ""}
+	softDependencies := make(map[string][]string)
+	rPackages["package1"] = Rpackage{"package1", "", "", "", "", "", []string{}, "", "", "", "", "", "", ""}
+	rPackages["package2"] = Rpackage{"package2", "", "", "", "", "", []string{}, "", "", "", "", "", "", ""}
+	rPackages["package3"] = Rpackage{"package3", "", "", "", "", "", []string{}, "", "", "", "", "", "", ""}
+	rPackages["package4"] = Rpackage{"package4", "", "", "", "", "", []string{}, "", "", "", "", "", "", ""}
+	rPackages["package5"] = Rpackage{"package5", "", "", "", "", "", []string{}, "", "", "", "", "", "", ""}
+	rPackages["package6"] = Rpackage{"package6", "", "", "", "", "", []string{}, "", "", "", "", "", "", ""}
 	downloadedPackages["package1"] = DownloadedPackage{"", "", "GitHub", "testdata/package1"}
 	downloadedPackages["package2"] = DownloadedPackage{"", "", "GitLab", "testdata/package2"}
 	downloadedPackages["package3"] = DownloadedPackage{"", "", "GitHub", "testdata/package3"}
 	downloadedPackages["package4"] = DownloadedPackage{"", "", "GitLab", ""}
-	getDepsFromDescriptionFiles(rPackages, downloadedPackages, packageDependencies)
+	// Package from r-universe cloned from git repository.
+	downloadedPackages["package5"] = DownloadedPackage{"git", "", "insightsengineering", "testdata/package3"}
+	downloadedPackages["package6"] = DownloadedPackage{"", "", "Gitea", "testdata/package3"}
+	missingSuggests := make(map[string][]string)
+	getDepsFromDescriptionFiles(rPackages, downloadedPackages, packageDependencies, softDependencies,
+		missingSuggests)
 	assert.Equal(t, packageDependencies["package1"], []string{"package2", "package3"})
 	assert.Equal(t, packageDependencies["package2"], []string{"package3"})
 	assert.Equal(t, len(packageDependencies["package3"]), 0)
 	assert.Equal(t, len(packageDependencies["package4"]), 0)
+	assert.Equal(t, missingSuggests["package3"], []string{"knitr"})
+	assert.Equal(t, missingSuggests["package5"], []string{"knitr"})
+	assert.Equal(t, len(packageDependencies["package6"]), 0)
+	assert.Equal(t, missingSuggests["package6"], []string{"knitr"})
+
+	// Suggested packages downloaded from renv.lock become dependencies of packages from git
+	// regardless of includeSuggests, because they may be needed by R CMD build.
+	downloadedPackages["knitr"] = DownloadedPackage{"", "", "CRAN", "/tmp/scribe/knitr_1.0.0.tar.gz"}
+	getDepsFromDescriptionFiles(rPackages, downloadedPackages, packageDependencies, softDependencies,
+		missingSuggests)
+	assert.Equal(t, packageDependencies["package3"], []string{"knitr"})
+	assert.Equal(t, softDependencies["package3"], []string{"knitr"})
+	assert.Equal(t, packageDependencies["package6"], []string{"knitr"})
+	assert.Equal(t, len(softDependencies["package1"]), 0)
+}
+
+func Test_getStronglyConnectedComponents(t *testing.T) {
+	dependencies := make(map[string][]string)
+	dependencies["package1"] = []string{"package2"}
+	dependencies["package2"] = []string{"package3", "package5"}
+	dependencies["package3"] = []string{"package1", "package4"}
+	dependencies["package4"] = []string{}
+	dependencies["package5"] = []string{"package5"}
+	dependencies["package6"] = []string{"package1"}
+	dependencies["package7"] = []string{"package8"}
+	dependencies["package8"] = []string{"package7"}
+	assert.Equal(t, getStronglyConnectedComponents(dependencies), [][]string{
+		{"package1", "package2", "package3"},
+		{"package5"},
+		{"package7", "package8"},
+	})
+	dependencies["package3"] = []string{"package4"}
+	dependencies["package5"] = []string{}
+	dependencies["package8"] = []string{}
+	assert.Equal(t, len(getStronglyConnectedComponents(dependencies)), 0)
+}
+
+func Test_getSoftDependencies(t *testing.T) {
+	dependencies := []locksmith.Dependency{
+		{DependencyType: "Imports", DependencyName: "package1"},
+		{DependencyType: "LinkingTo", DependencyName: "package1"},
+		{DependencyType: "LinkingTo", DependencyName: "package2"},
+		{DependencyType: "Suggests", DependencyName: "package3"},
+		{DependencyType: "Depends", DependencyName: "package4"},
+	}
+	assert.Equal(t, getSoftDependencies(dependencies, []string{"package1", "package2", "package3", "package4"}),
+		[]string{"package3"})
+}
+
+func Test_breakDependencyCycles(t *testing.T) {
+	dependencies := make(map[string][]string)
+	dependencies["package1"] = []string{"package2", "package5"}
+	dependencies["package2"] = []string{"package1"}
+	dependencies["package3"] = []string{"package4"}
+	dependencies["package4"] = []string{"package3"}
+	dependencies["package5"] = []string{}
+	softDependencies := make(map[string][]string)
+	// Suggests closing a cycle is dropped, Suggests not closing a cycle is kept.
+	softDependencies["package1"] = []string{"package2", "package5"}
+	brokenDependencies := breakDependencyCycles(dependencies, softDependencies)
+	assert.Equal(t, len(brokenDependencies), 5)
+	assert.Equal(t, brokenDependencies["package1"], []string{"package5"})
+	assert.Equal(t, brokenDependencies["package2"], []string{"package1"})
+	// Cycle of hard dependencies is not broken.
+	assert.Equal(t, brokenDependencies["package3"], []string{"package4"})
+	assert.Equal(t, brokenDependencies["package4"], []string{"package3"})
+	assert.Equal(t, getDependencyCycles(brokenDependencies), map[string][]string{
+		"package3": {"package3", "package4", "package3"},
+		"package4": {"package4", "package3", "package4"},
+	})
+}
+
+func Test_getDependencyCycles(t *testing.T) {
+	dependencies := make(map[string][]string)
+	dependencies["package1"] = []string{"package2", "package4"}
+	dependencies["package2"] = []string{"package3"}
+	dependencies["package3"] = []string{"package1", "package2"}
+	dependencies["package4"] = []string{}
+	dependencies["package5"] = []string{"package5"}
+	assert.Equal(t, getDependencyCycles(dependencies), map[string][]string{
+		"package1": {"package1", "package2", "package3", "package1"},
+		"package2": {"package2", "package3", "package2"},
+		"package3": {"package3", "package2", "package3"},
+		"package5": {"package5", "package5"},
+	})
 }
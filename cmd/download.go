@@ -17,24 +17,21 @@ package cmd
 
 import (
 	"bufio"
+	"compress/gzip"
+	"context"
 	"crypto/md5" // #nosec
-	"crypto/tls"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
 	"time"
 
-	git "github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/config"
-	"github.com/go-git/go-git/v5/plumbing"
-	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	locksmith "github.com/insightsengineering/locksmith/cmd"
 )
 
@@ -42,10 +39,18 @@ const defaultCranMirrorURL = "https://cloud.r-project.org"
 const bioConductorURL = "https://www.bioconductor.org/packages"
 const GitHub = "GitHub"
 const GitLab = "GitLab"
+const Bitbucket = "Bitbucket"
+const Gitea = "Gitea"
+
+// Source of packages cloned from any git remote given by RemoteUrl renv.lock field.
+const Git = "git"
 const cache = "cache"
 const download = "download"
 const github = "github"
 const gitlab = "gitlab"
+const bitbucket = "bitbucket"
+const gitea = "gitea"
+const gitRemote = "git"
 const windows = "windows"
 const targzExtensionFile = "tar.gz"
 const tarGzExtension = ".tar.gz"
@@ -54,6 +59,20 @@ const tgzExtension = ".tgz"
 const archivesSubdirectory = "/package_archives/"
 const srcContrib = "/src/contrib/"
 const biocPackagesPrefix = "/package_files/BIOC_PACKAGES_"
+const repositoryPackagesPrefix = "/package_files/REPOSITORY_PACKAGES_"
+
+// Status of packages which couldn't be cloned from Bitbucket, Gitea or other git repositories.
+const downloadStatusGitCloneError = -8
+
+// Map from package source to the action with which the package is retrieved.
+// Repositories are cloned to the subdirectory of localOutputDirectory with the same name as the action.
+var gitSourceActions = map[string]string{
+	GitHub:    github,
+	GitLab:    gitlab,
+	Bitbucket: bitbucket,
+	Gitea:     gitea,
+	Git:       gitRemote,
+}
 
 var bioconductorCategories = [4]string{"bioc", "data/experiment", "data/annotation", "workflows"}
 
@@ -67,8 +86,13 @@ type DownloadInfo struct {
 	// message field contains error message
 	// statusCode == -3 means that there was an error during cloning of GitLab repository
 	// message field contains error message
+	// statusCode == -8 means that there was an error during cloning of Bitbucket, Gitea or other git repository
+	// message field contains error message
 	// statusCode == -4 means that a network error occurred during HTTP download
 	// message field contains URL of the package
+	// statusCode == -7 means that the downloaded package archive doesn't match the MD5 checksum
+	// from PACKAGES file, or that the commit checked out in git repository doesn't match RemoteSha
+	// message field contains error message
 	StatusCode    int    `json:"statusCode"`
 	Message       string `json:"message"`
 	ContentLength int64  `json:"contentLength"`
@@ -80,17 +104,26 @@ type DownloadInfo struct {
 	// possible values: tar.gz, git, bioconductor,
 	// tgz in case of binary macOS packages,
 	// zip in case of binary Windows packages,
+	// linux-binary in case of binary Linux packages (e.g. from Posit Package Manager __linux__ repositories),
 	// or empty value in case of error
 	DownloadedPackageType string `json:"downloadedPackageType"`
 	PackageName           string `json:"packageName"`
 	PackageVersion        string `json:"packageVersion"`
 	// Contains git SHA of cloned package, or exceptionally git tag or branch, if SHA was not provided in renv.lock.
+	// For packages from r-universe, contains the SHA of the commit from which the package has been built.
 	GitPackageShaOrRef string `json:"gitPackageShaOrRef"`
 	// Name of R package repository ("Repository" renv.lock field, e.g. CRAN, RSPM) in case package
 	// source ("Source" renv.lock field) is "Repository".
-	// Otherwise, "GitHub" or "GitLab" depending on "Source" renv.lock field.
+	// Otherwise, "GitHub", "GitLab", "Bitbucket", "Gitea" or "git" depending on "Source" renv.lock field.
+	// Packages from r-universe have the name of the universe, even if they have been cloned from git repository.
 	// Empty in case of errors.
 	PackageRepository string `json:"packageRepository"`
+	// Number of download or clone attempts, including retries and attempts to download from mirrors.
+	// 0 in case the package has been retrieved from cache.
+	Attempts int `json:"attempts,omitempty"`
+	// URL from which the package has been successfully downloaded or cloned,
+	// e.g. URL of repository mirror. Empty in case of errors.
+	SuccessfulURL string `json:"successfulUrl,omitempty"`
 }
 
 // Struct used to store data about tar.gz packages saved in local cache.
@@ -102,6 +135,25 @@ type CacheInfo struct {
 type PackageInfo struct {
 	Version  string
 	Checksum string
+	// Commit from which the package has been built (provided by r-universe repositories).
+	RemoteSha string
+}
+
+// isGitSource checks whether packages from the source ("Source" renv.lock field) are cloned from git repositories.
+func isGitSource(packageSource string) bool {
+	_, ok := gitSourceActions[packageSource]
+	return ok
+}
+
+// getGitCloneErrorStatus returns the download status for packages which couldn't be cloned with the action.
+func getGitCloneErrorStatus(action string) int {
+	switch action {
+	case github:
+		return -2
+	case gitlab:
+		return -3
+	}
+	return downloadStatusGitCloneError
 }
 
 func getRepositoryURL(v Rpackage, repositories []Rrepository) string {
@@ -113,14 +165,12 @@ func getRepositoryURL(v Rpackage, repositories []Rrepository) string {
 		repoURL = "https://github.com/" + v.RemoteUsername + "/" + v.RemoteRepo
 	case GitLab:
 		// The behavior of renv.lock is not standardized in terms of whether GitLab host address
-		// starts with 'https://' or not.
-		var remoteHost string
-		if strings.HasPrefix(v.RemoteHost, "https://") {
-			remoteHost = v.RemoteHost
-		} else {
-			remoteHost = "https://" + v.RemoteHost
-		}
-		repoURL = remoteHost + "/" + v.RemoteUsername + "/" + v.RemoteRepo
+		// starts with 'https://' or not. Host in the form 'git@host' means the repository should be cloned with SSH.
+		repoURL = getGitRemoteURL(v.RemoteHost, v.RemoteUsername, v.RemoteRepo)
+	case Bitbucket, Gitea:
+		repoURL = getGitRemoteURL(getGitWebHost(v.RemoteHost), v.RemoteUsername, v.RemoteRepo)
+	case Git:
+		repoURL = v.RemoteURL
 	default:
 		repoURL = getRenvRepositoryURL(repositories, v.Repository)
 	}
@@ -129,10 +179,19 @@ func getRepositoryURL(v Rpackage, repositories []Rrepository) string {
 
 // downloadFile saves the file at url to outputFile and returns the HTTP status code
 // for downloaded file and number of bytes in downloaded content.
-func downloadFile(url string, outputFile string) (int, int64) { // #nosec G402
-	tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-	client := &http.Client{Transport: tr}
-	resp, err := client.Get(url)
+func downloadFile(url string, outputFile string) (int, int64) {
+	return downloadFileContext(context.Background(), url, outputFile)
+}
+
+// downloadFileContext works like downloadFile, but the download is aborted when ctx is cancelled.
+func downloadFileContext(ctx context.Context, url string, outputFile string) (int, int64) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	checkError(err)
+	if err != nil {
+		return -4, 0
+	}
+	setLinuxBinaryUserAgent(req)
+	resp, err := httpClient.Do(req)
 	checkError(err)
 
 	if err == nil {
@@ -143,6 +202,12 @@ func downloadFile(url string, outputFile string) (int, int64) { // #nosec G402
 			defer out.Close()
 			_, err = io.Copy(out, resp.Body)
 			checkError(err)
+			if err != nil {
+				// Don't leave partially downloaded files.
+				out.Close()
+				os.Remove(outputFile)
+				return -4, 0
+			}
 		}
 
 		return resp.StatusCode, resp.ContentLength
@@ -150,128 +215,46 @@ func downloadFile(url string, outputFile string) (int, int64) { // #nosec G402
 	return -4, 0
 }
 
-// cloneGitRepo clones git repository and returns string with error value (empty if cloning was
-// successful), approximate number of downloaded bytes, and cloned version of the package (tag, branch or commit SHA).
+// cloneGitRepo checks out the package from git repository to gitDirectory and returns string with error value
+// (empty if cloning was successful), number of bytes transferred from the remote, number of bytes which didn't
+// have to be transferred because they were already in the git cache, and SHA of the checked out commit.
 // If commitSha or branchOrTagName is specified, the respective commit, branch or tag are checked out.
-// If environmentCredentialsType is "gitlab", this function expects the token to be set in
-// GITLAB_TOKEN environment variables.
-// If environmentCredentialsType is "github", this function expects the token to be set in
-// GITHUB_TOKEN environment variable.
-// This implementation assumes that RemoteSha renv.lock field contains commit SHA,
-// and that RemoteRef renv.lock field contains branch name or tag name.
-// It is assumed that RemoteRef matching regex `v\d+(\.\d+)*` (where \d is a digit) is a tag name.
-// Otherwise, that it is a branch name.
-// If any of these assumptions are not correct for some package, the default branch will be checked out.
+// Otherwise, the default branch is checked out.
+// branchOrTagName is resolved against the references advertised by the remote: tags, branches,
+// and full reference names such as refs/pull/123/head.
+// The repository is fetched to a bare repository in the git cache which is preserved between runs,
+// so that only the missing commits have to be transferred. Only the commit to be checked out is fetched
+// (without history). If the server doesn't support such fetch, the whole repository is fetched instead.
+// Credentials are resolved by getGitAuth based on the repository host. If no credentials are configured
+// for the host, and environmentCredentialsType is "gitlab" or "github", the token is read from
+// GITLAB_TOKEN or GITHUB_TOKEN environment variable respectively.
 func cloneGitRepo(gitDirectory string, repoURL string, environmentCredentialsType string,
-	commitSha string, branchOrTagName string) (string, int64, string) {
+	commitSha string, branchOrTagName string) (string, int64, int64, string) {
+	return cloneGitRepoContext(context.Background(), gitDirectory, repoURL, environmentCredentialsType,
+		commitSha, branchOrTagName)
+}
+
+// cloneGitRepoContext works like cloneGitRepo, but cloning is aborted when ctx is cancelled.
+func cloneGitRepoContext(ctx context.Context, gitDirectory string, repoURL string, environmentCredentialsType string,
+	commitSha string, branchOrTagName string) (string, int64, int64, string) {
 	err := os.MkdirAll(gitDirectory, os.ModePerm)
 	checkError(err)
-	var gitCloneOptions *git.CloneOptions
-	switch {
-	case environmentCredentialsType == gitlab:
-		gitCloneOptions = &git.CloneOptions{
-			URL: repoURL,
-			Auth: &githttp.BasicAuth{
-				Username: "This can be any string.",
-				Password: os.Getenv("GITLAB_TOKEN"),
-			},
-		}
-	case environmentCredentialsType == github:
-		gitCloneOptions = &git.CloneOptions{
-			URL: repoURL,
-			Auth: &githttp.BasicAuth{
-				Username: "This can be any string.",
-				Password: os.Getenv("GITHUB_TOKEN"),
-			},
-		}
-	default:
-		gitCloneOptions = &git.CloneOptions{
-			URL: repoURL,
+	var auth transport.AuthMethod
+	// No credentials are needed to check out the package from the git cache in offline mode.
+	if !offline {
+		auth, err = getGitAuth(repoURL, environmentCredentialsType)
+		if err != nil {
+			return "Error while cloning repo " + repoURL + ": " + err.Error(), 0, 0, ""
 		}
 	}
-	repository, err := git.PlainClone(gitDirectory, false, gitCloneOptions)
-	if err == nil {
-		var gitPackageShaOrRef string
-		w, er := repository.Worktree()
-		checkError(er)
-		switch {
-		case commitSha != "":
-			// Checkout the commit.
-			log.Info("Checking out commit ", commitSha, " in ", gitDirectory)
-			err = w.Checkout(&git.CheckoutOptions{
-				Hash: plumbing.NewHash(commitSha),
-			})
-			if err != git.NoErrAlreadyUpToDate {
-				checkError(err)
-			}
-			gitPackageShaOrRef = commitSha
-		case branchOrTagName != "" && branchOrTagName != "HEAD":
-			// Checkout the branch or tag.
-			match, err2 := regexp.MatchString(`v\d+(\.\d+)*`, branchOrTagName)
-			checkError(err2)
-			var refName string
-			var checkoutRefName string
-			if match {
-				log.Trace(branchOrTagName + " matches tag name regexp.")
-				checkoutRefName = fmt.Sprintf("refs/tags/%s", branchOrTagName)
-				refName = fmt.Sprintf("%s:%s", checkoutRefName, checkoutRefName)
-			} else {
-				log.Trace(branchOrTagName, " doesn't match tag name regexp.")
-				checkoutRefName = fmt.Sprintf("refs/heads/%s", branchOrTagName)
-				refName = fmt.Sprintf("%s:%s", checkoutRefName, checkoutRefName)
-			}
-			log.Info("Checking out branch or tag ", checkoutRefName, " in ", gitDirectory)
-			refSpec := config.RefSpec(refName)
-			var fetchOptions *git.FetchOptions
-			switch {
-			case environmentCredentialsType == gitlab:
-				fetchOptions = &git.FetchOptions{
-					RefSpecs: []config.RefSpec{refSpec},
-					Auth: &githttp.BasicAuth{
-						Username: "This can be any string.",
-						Password: os.Getenv("GITLAB_TOKEN"),
-					},
-				}
-			case environmentCredentialsType == github:
-				fetchOptions = &git.FetchOptions{
-					RefSpecs: []config.RefSpec{refSpec},
-					Auth: &githttp.BasicAuth{
-						Username: "This can be any string.",
-						Password: os.Getenv("GITHUB_TOKEN"),
-					},
-				}
-			default:
-				fetchOptions = &git.FetchOptions{
-					RefSpecs: []config.RefSpec{refSpec},
-				}
-			}
-			err = repository.Fetch(fetchOptions)
-			if err != git.NoErrAlreadyUpToDate {
-				checkError(err)
-			}
-			err = w.Checkout(&git.CheckoutOptions{
-				Branch: plumbing.ReferenceName(checkoutRefName),
-			})
-			if err != git.NoErrAlreadyUpToDate {
-				checkError(err)
-			}
-			gitPackageShaOrRef = branchOrTagName
-		default:
-			// Leave HEAD checked out and return its SHA.
-			// This case is used during package update phase,
-			// where we check the newest package version in git repository.
-			ref, err2 := repository.Head()
-			checkError(err2)
-			gitPackageShaOrRef = ref.Hash().String()
-		}
-		// The number of bytes downloaded is approximated by the size of repository directory.
-		var gitRepoSize int64
-		gitRepoSize, err = dirSize(gitDirectory)
-		checkError(err)
-		log.Debug("Repository size of ", repoURL, " = ", gitRepoSize/1024, " KiB")
-		return "", gitRepoSize, gitPackageShaOrRef
+	transferredBytes, savedBytes, hash, err := checkoutFromGitCache(ctx, gitDirectory, repoURL, auth,
+		commitSha, branchOrTagName)
+	if err != nil {
+		return "Error while cloning repo " + repoURL + ": " + err.Error(), transferredBytes, 0, ""
 	}
-	return "Error while cloning repo " + repoURL + ": " + err.Error(), 0, ""
+	log.Debug("Transferred ", transferredBytes/1024, " KiB from ", repoURL, ", ",
+		savedBytes/1024, " KiB checked out from git cache")
+	return "", transferredBytes, savedBytes, hash.String()
 }
 
 func getCranPackageDetails(packageName string, packageVersion string, repoURL string,
@@ -293,43 +276,58 @@ func getCranPackageDetails(packageName string, packageVersion string, repoURL st
 		return download, "tgz", packageURL, "", outputLocation, "", 0
 	}
 	// Download source or binary packages for Linux (depending on exact repository URL).
+	return getRepositoryPackageDetails(packageName, packageVersion, repoURL, currentCranPackageInfo,
+		localArchiveChecksums)
+}
+
+// getRepositoryPackageDetails returns the same information as getPackageDetails for packages
+// from CRAN-like repository, based on the current package versions and their checksums
+// in the PACKAGES file of the repository (packageInfo).
+func getRepositoryPackageDetails(packageName string, packageVersion string, repoURL string,
+	packageInfo map[string]*PackageInfo, localArchiveChecksums map[string]*CacheInfo,
+) (string, string, string, string, string, string, int64) {
 	var packageURL string
 	outputLocation := localOutputDirectory + archivesSubdirectory + packageName +
 		"_" + packageVersion + tarGzExtension
-	// Check if package is in current CRAN repository.
-	var versionInCran string
-	packageInfo, ok := currentCranPackageInfo[packageName]
+	// Check if package is in the current repository.
+	var versionInRepository string
+	currentPackageInfo, ok := packageInfo[packageName]
 	if ok {
-		versionInCran = packageInfo.Version
-	}
-	if ok {
-		log.Debug("CRAN current has package ", packageName, " version ", versionInCran, ".")
+		versionInRepository = currentPackageInfo.Version
+		log.Debug(repoURL, " current has package ", packageName, " version ", versionInRepository, ".")
 	} else {
-		log.Debug("CRAN current doesn't have ", packageName, " in any version.")
+		log.Debug(repoURL, " current doesn't have ", packageName, " in any version.")
 	}
-	if ok && versionInCran == packageVersion {
+	if ok && versionInRepository == packageVersion {
 		// Check if the package is cached locally.
-		localCachedFile, ok := localArchiveChecksums[packageInfo.Checksum]
+		localCachedFile, ok := localArchiveChecksums[currentPackageInfo.Checksum]
 		packageURL = repoURL + srcContrib + packageName + "_" + packageVersion + tarGzExtension
 		if ok {
 			return cache, targzExtensionFile, packageURL, "", localCachedFile.Path, "", localCachedFile.Length
 		}
 		// Package not cached locally.
-		log.Debug("Retrieving package ", packageName, " from CRAN current.")
+		log.Debug("Retrieving package ", packageName, " from ", repoURL, " current.")
 		return download, targzExtensionFile, packageURL, "", outputLocation, "", 0
 	}
-	// If CRAN current doesn't have the package version, look for the package in Archive.
+	// If the current repository doesn't have the package version, look for the package in Archive.
 	log.Debug(
 		"Attempting to retrieve ", packageName, " version ", packageVersion,
-		" from CRAN Archive.",
+		" from ", repoURL, " Archive.",
 	)
 	packageURL = repoURL + "/src/contrib/Archive/" + packageName +
 		"/" + packageName + "_" + packageVersion + tarGzExtension
-	// In case the requested package version cannot be found neither in current CRAN or CRAN archive,
-	// we'll try to download the version from current CRAN as fallback.
-	fallbackPackageURL := repoURL + srcContrib + packageName + "_" + versionInCran + tarGzExtension
+	// In case the requested package version cannot be found neither in the current repository nor in
+	// the Archive, we'll try to download the newest version from the current repository as fallback.
+	// If the package is not in the current repository at all (e.g. because the repository doesn't have
+	// the Archive, and the PACKAGES file is not up to date), the requested version is downloaded
+	// from the current repository as fallback.
+	fallbackVersion := versionInRepository
+	if !ok {
+		fallbackVersion = packageVersion
+	}
+	fallbackPackageURL := repoURL + srcContrib + packageName + "_" + fallbackVersion + tarGzExtension
 	fallbackOutputLocation := localOutputDirectory + archivesSubdirectory + packageName +
-		"_" + versionInCran + tarGzExtension
+		"_" + fallbackVersion + tarGzExtension
 	return download, targzExtensionFile, packageURL, fallbackPackageURL, outputLocation, fallbackOutputLocation, 0
 }
 
@@ -390,6 +388,14 @@ func getBioconductorPackageDetails(packageName string, packageVersion string, re
 		// Package not cached locally.
 		return download, "bioconductor", packageURL, outputLocation, 0
 	}
+	// In offline mode, PACKAGES snapshots of some Bioconductor categories may be missing, in which case
+	// the package is looked up among the packages downloaded in the previous runs (see getOfflinePackageDetails).
+	if offline && isBiocPackagesSnapshotMissing(biocPackageInfo) {
+		log.Debug("Looking up ", packageName, " in package archives, because PACKAGES of Bioconductor ",
+			"is not in offline cache.")
+		return download, "bioconductor", biocUrls[bioconductorCategories[0]] + "/" + packageName +
+			"_" + packageVersion + tarGzExtension, outputLocation, 0
+	}
 	// Package not found in any Bioconductor category.
 	return "notfound_bioc", "", "", "", 0
 }
@@ -405,15 +411,19 @@ func getBioconductorPackageDetails(packageName string, packageVersion string, re
 //
 //   - "gitlab" means the package should be cloned as a GitLab repository
 //
+//   - "bitbucket", "gitea" and "git" mean the package should be cloned as a Bitbucket, Gitea
+//     or any other git repository, respectively
+//
 //   - "notfound_bioc" means the package couldn't be found in Bioconductor
 //
 // * package type: "bioconductor" for BioConductor Linux packages, "tar.gz" for other Linux packages,
-// zip for Windows binary packages, tgz for macOS binary packages,
+// zip for Windows binary packages, tgz for macOS binary packages, linux-binary for Linux binary packages,
 // in other cases this field is empty
 //
 // * URL from which the package should be downloaded or cloned (or has originally been downloaded from, if it's available in cache)
 //
-// * fallback URL - in case specific package version can't be found in CRAN, it is downloaded in the newest available CRAN version
+// * fallback URL - in case specific package version can't be found in CRAN, it is downloaded in the newest available CRAN version,
+// in case binary package can't be found in Linux binary repository, source package is downloaded
 //
 // * location where the package will be downloaded (filepath to the tar.gz file or git repo directory)
 //
@@ -421,7 +431,7 @@ func getBioconductorPackageDetails(packageName string, packageVersion string, re
 //
 // * number of bytes saved due to retrieving file from cache (size of the tar.gz file in cache), if not found in cache: 0
 func getPackageDetails(packageName string, packageVersion string, repoURL string,
-	packageSource string, currentCranPackageInfo map[string]*PackageInfo,
+	packageSource string, repositoryPackageInfo map[string]map[string]*PackageInfo,
 	biocPackageInfo map[string]map[string]*PackageInfo, biocUrls map[string]string,
 	localArchiveChecksums map[string]*CacheInfo) (string, string, string, string, string, string, int64) {
 	switch {
@@ -435,7 +445,8 @@ func getPackageDetails(packageName string, packageVersion string, repoURL string
 		//   https://cloud.r-project.org/bin/windows/contrib/4.2 or https://cloud.r-project.org/bin/macosx/contrib/4.2
 		//   then the package in a given version exists in that repository, and scribe will not verify that.
 		action, packageType, packageURL, fallbackPackageURL, outputLocation, fallbackOutputLocation, savedBandwidth :=
-			getCranPackageDetails(packageName, packageVersion, repoURL, currentCranPackageInfo, localArchiveChecksums)
+			getCranPackageDetails(packageName, packageVersion, repoURL, repositoryPackageInfo[defaultCranMirrorURL],
+				localArchiveChecksums)
 		return action, packageType, packageURL, fallbackPackageURL, outputLocation, fallbackOutputLocation, savedBandwidth
 
 	case repoURL == bioConductorURL || (strings.Contains(repoURL, "https://www.bioconductor.org/packages") &&
@@ -459,20 +470,30 @@ func getPackageDetails(packageName string, packageVersion string, repoURL string
 		log.Debug("Cloning ", repoURL, " to ", gitDirectory)
 		return github, "", repoURL, "", gitDirectory, "", 0
 
-	case packageSource == GitLab:
+	case packageSource == GitLab || packageSource == Bitbucket || packageSource == Gitea || packageSource == Git:
 		// Expected repoURL format: https://example.com/remote-user/some/remote/repo/path
-		remoteHost := strings.Join(strings.Split(repoURL, "/")[:3], "/")
-		remoteUser := strings.Split(repoURL, "/")[3]
-		remoteRepo := strings.Join(strings.Split(repoURL, "/")[4:], "/")
+		// or git@example.com:remote-user/some/remote/repo/path
+		remoteHost, _, remoteRepoPath := parseGitRemoteURL(repoURL)
+		remoteRepoPath = strings.TrimSuffix(remoteRepoPath, ".git")
+		action := gitSourceActions[packageSource]
 
-		gitDirectory := localOutputDirectory + "/gitlab/" + strings.Split(repoURL, "/")[2] +
-			"/" + remoteUser + "/" + remoteRepo
-		log.Debug("Cloning repo ", remoteUser, "/", remoteRepo, " from host ",
+		gitDirectory := localOutputDirectory + "/" + action + "/" + remoteHost + "/" + remoteRepoPath
+		log.Debug("Cloning repo ", remoteRepoPath, " from host ",
 			remoteHost, " to directory ", gitDirectory)
-		return gitlab, "", repoURL, "", gitDirectory, "", 0
+		return action, "", repoURL, "", gitDirectory, "", 0
+
+	case getLinuxBinaryDistribution(repoURL) != "":
+		// Linux binary repository, such as https://packagemanager.posit.co/cran/__linux__/jammy/latest.
+		return getLinuxBinaryPackageDetails(packageName, packageVersion, repoURL, getLinuxBinaryDistribution(repoURL),
+			repositoryPackageInfo[repoURL], localArchiveChecksums)
+
+	case repositoryPackageInfo[repoURL] != nil:
+		// Repositories other than CRAN or BioConductor, for which the PACKAGES file has been retrieved.
+		return getRepositoryPackageDetails(packageName, packageVersion, repoURL, repositoryPackageInfo[repoURL],
+			localArchiveChecksums)
 
 	default:
-		// Repositories other than CRAN or BioConductor.
+		// Repositories for which the PACKAGES file couldn't be retrieved.
 		// It is assumed that the requested package version is the newest available.
 		// Archive is not checked.
 		packageURL := repoURL + srcContrib + packageName + "_" + packageVersion + tarGzExtension
@@ -491,128 +512,177 @@ func getPackageOutputLocation(outputLocation, packageSubdir string) string {
 
 // downloadSinglePackage executes in parallel goroutines and determines in what way
 // to retrieve the package, and then retrieves the package accordingly.
-func downloadSinglePackage(packageName string, packageVersion string,
+// Downloaded archives are verified against renvHash, unless it's empty (see getVerifiableRenvHash).
+func downloadSinglePackage(ctx context.Context, packageName string, packageVersion string,
 	repoURL string, gitCommitSha string, gitBranch string,
-	packageSource string, packageRepository string, packageSubdir string,
-	currentCranPackageInfo map[string]*PackageInfo,
+	packageSource string, packageRepository string, packageSubdir string, renvHash string,
+	repositoryPackageInfo map[string]map[string]*PackageInfo,
 	biocPackageInfo map[string]map[string]*PackageInfo, biocUrls map[string]string,
 	localArchiveChecksums map[string]*CacheInfo,
 	downloadFileFunction func(string, string) (int, int64),
-	gitCloneFunction func(string, string, string, string, string) (string, int64, string),
+	gitCloneFunction func(string, string, string, string, string) (string, int64, int64, string),
 	messages chan DownloadInfo, guard chan struct{}) {
 
 	// Determine whether to download the package as tar.gz file, or from git repository.
 	action, packageType, packageURL, fallbackPackageURL, outputLocation, fallbackOutputLocation, savedBandwidth := getPackageDetails(
-		packageName, packageVersion, repoURL, packageSource, currentCranPackageInfo,
+		packageName, packageVersion, repoURL, packageSource, repositoryPackageInfo,
 		biocPackageInfo, biocUrls, localArchiveChecksums,
 	)
 
+	if offline && action == download {
+		action, packageURL, outputLocation, savedBandwidth = getOfflinePackageDetails(packageURL,
+			fallbackPackageURL, outputLocation, fallbackOutputLocation)
+	}
+
+	// Mirrors of Bioconductor are defined for "Bioconductor" repository name,
+	// since such packages don't have repository name in renv.lock.
+	mirrorsRepositoryName := packageRepository
+	if packageSource == "Bioconductor" {
+		mirrorsRepositoryName = packageSource
+	}
+
 	switch action {
 	case cache:
 		log.Debug("Package ", packageName, " version ", packageVersion,
 			" found in cache: ", outputLocation)
+		packageType = getDownloadedPackageType(packageType, outputLocation, packageName)
 		messages <- DownloadInfo{200, "[cached] " + packageURL, 0, outputLocation, savedBandwidth,
-			packageType, packageName, packageVersion, "", packageRepository}
+			packageType, packageName, packageVersion,
+			getRUniversePackageSha(repoURL, packageName, repositoryPackageInfo[repoURL]),
+			packageRepository, 0, ""}
 	case download:
-		statusCode, contentLength := downloadFileFunction(packageURL, outputLocation)
+		statusCode, contentLength, downloadURL, attempts := downloadFileWithRetries(ctx,
+			getMirrorURLs(packageURL, repoURL, mirrorsRepositoryName), outputLocation, downloadFileFunction)
+		// Whether the downloaded archive is the package version requested by renv.lock.
+		requestedVersion := true
 		if statusCode != http.StatusOK {
 			// Download may fail in case the requested package version cannot be found
 			// neither in current CRAN nor in CRAN archive. In that case, we try
 			// to download the newest package version from CRAN current.
-			if fallbackPackageURL != "" && fallbackOutputLocation != "" {
-				statusCode, contentLength = downloadFileFunction(fallbackPackageURL, fallbackOutputLocation)
+			if fallbackPackageURL != "" && fallbackOutputLocation != "" && !isCancelled(ctx) {
+				var fallbackAttempts int
+				statusCode, contentLength, downloadURL, fallbackAttempts = downloadFileWithRetries(ctx,
+					getMirrorURLs(fallbackPackageURL, repoURL, mirrorsRepositoryName), fallbackOutputLocation,
+					downloadFileFunction)
+				attempts += fallbackAttempts
 				packageURL = fallbackPackageURL
-				if statusCode == http.StatusOK {
+				switch {
+				case statusCode == http.StatusOK && packageType == linuxBinaryPackageType:
 					outputLocation = fallbackOutputLocation
-					log.Warn("Package ", packageName, " downloaded from ", fallbackPackageURL,
+					log.Warn("Source package ", packageName, " downloaded from ", downloadURL,
+						" because binary package version ", packageVersion, " is not available.")
+				case statusCode == http.StatusOK:
+					outputLocation = fallbackOutputLocation
+					requestedVersion = false
+					log.Warn("Package ", packageName, " downloaded from ", downloadURL,
 						" because requested version ", packageVersion, " is not available.")
-				} else {
+				default:
 					outputLocation = ""
 				}
 			} else {
 				outputLocation = ""
 			}
 		}
+		if statusCode != http.StatusOK {
+			downloadURL = ""
+		} else {
+			integrityError := verifyDownloadedArchive(outputLocation, packageName,
+				getExpectedChecksum(packageName, packageURL, repoURL, repositoryPackageInfo, biocPackageInfo, biocUrls),
+				renvHash, requestedVersion)
+			if integrityError != "" {
+				// Don't leave corrupted files in the cache.
+				err := os.Remove(outputLocation)
+				checkError(err)
+				messages <- DownloadInfo{downloadStatusIntegrityError, integrityError, contentLength, "", 0,
+					packageType, packageName, packageVersion, "", packageRepository, attempts, downloadURL}
+				break
+			}
+			packageType = getDownloadedPackageType(packageType, outputLocation, packageName)
+		}
 		messages <- DownloadInfo{statusCode, packageURL, contentLength, outputLocation, 0, packageType,
-			packageName, packageVersion, "", packageRepository}
+			packageName, packageVersion,
+			getRUniversePackageSha(repoURL, packageName, repositoryPackageInfo[repoURL]),
+			packageRepository, attempts, downloadURL}
+	case notInOfflineCacheAction:
+		messages <- DownloadInfo{downloadStatusNotInOfflineCache, packageName + " version " + packageVersion +
+			" is " + notInOfflineCache + ".", 0, "", 0, "", packageName, packageVersion, "", packageRepository, 0, ""}
 	case "notfound_bioc":
 		messages <- DownloadInfo{-1, "Couldn't find " + packageName + " version " +
-			packageVersion + " in BioConductor.", 0, "", 0, "", packageName, "", "", packageRepository}
-	case github:
-		message, gitRepoSize, gitPackageShaOrRef := gitCloneFunction(outputLocation, packageURL, github,
-			gitCommitSha, gitBranch)
-		if message == "" {
-			messages <- DownloadInfo{200, repoURL, gitRepoSize,
-				getPackageOutputLocation(outputLocation, packageSubdir), 0,
-				"git", packageName, packageVersion, gitPackageShaOrRef, packageSource}
-		} else {
-			messages <- DownloadInfo{-2, message, 0, "", 0, "", packageName, "", "", packageSource}
-		}
-	case gitlab:
-		message, gitRepoSize, gitPackageShaOrRef := gitCloneFunction(outputLocation, packageURL, gitlab,
-			gitCommitSha, gitBranch)
-		if message == "" {
+			packageVersion + " in BioConductor.", 0, "", 0, "", packageName, "", "", packageRepository, 0, ""}
+	case github, gitlab, bitbucket, gitea, gitRemote:
+		message, gitRepoSize, gitSavedBandwidth, gitPackageShaOrRef, attempts := cloneGitRepoWithRetries(ctx,
+			outputLocation, packageURL, action, gitCommitSha, gitBranch, gitCloneFunction)
+		integrityError := verifyGitSha(repoURL, gitCommitSha, gitPackageShaOrRef)
+		switch {
+		case offline && strings.Contains(message, notInOfflineCache):
+			messages <- DownloadInfo{downloadStatusNotInOfflineCache, message, 0, "", 0, "", packageName,
+				packageVersion, "", packageSource, attempts, ""}
+		case message != "":
+			messages <- DownloadInfo{getGitCloneErrorStatus(action), message, 0, "", 0, "", packageName, "", "",
+				packageSource, attempts, ""}
+		case integrityError != "":
+			messages <- DownloadInfo{downloadStatusIntegrityError, integrityError, gitRepoSize, "", 0, "",
+				packageName, packageVersion, gitPackageShaOrRef, packageSource, attempts, repoURL}
+		default:
 			messages <- DownloadInfo{200, repoURL, gitRepoSize,
-				getPackageOutputLocation(outputLocation, packageSubdir), 0,
-				"git", packageName, packageVersion, gitPackageShaOrRef, packageSource}
-		} else {
-			messages <- DownloadInfo{-3, message, 0, "", 0, "", packageName, "", "", packageSource}
+				getPackageOutputLocation(outputLocation, packageSubdir), gitSavedBandwidth,
+				"git", packageName, packageVersion, gitPackageShaOrRef, packageSource, attempts, repoURL}
 		}
 	default:
-		messages <- DownloadInfo{-5, "Internal error: unknown action " + action, 0, "", 0, "", "", "", "", ""}
+		messages <- DownloadInfo{-5, "Internal error: unknown action " + action, 0, "", 0, "", "", "", "", "", 0, ""}
 	}
 	<-guard
 }
 
 // parsePackagesFile reads PACKAGES file and saves:
 // * map from package names to their versions as stored in the PACKAGES file.
-// * map from package names to their MD5 checksums as stored in the PACKAGES file.
+// * map from package names to their MD5 checksums as stored in the PACKAGES file
+// (empty if the repository doesn't provide checksums).
+// Each entry of the PACKAGES file is parsed as a set of fields, regardless of their order.
 func parsePackagesFile(filePath string, packageInfo map[string]*PackageInfo) {
 	packages, err := os.Open(filePath)
 	checkError(err)
 	defer packages.Close()
 
 	scanner := bufio.NewScanner(packages)
-	var currentlyProcessedPackageName string
-	var currentlyProcessedPackageVersion string
-	var packageName string
-	// Iterate through lines of PACKAGES file.
+	// Fields of the entry which is currently processed.
+	entryFields := make(map[string]string)
+	// Iterate through lines of PACKAGES file. Entries are separated by empty lines.
 	for scanner.Scan() {
 		newLine := scanner.Text()
-		if strings.HasPrefix(newLine, "Package:") {
-			packageFields := strings.Fields(newLine)
-			packageName = packageFields[1]
-			currentlyProcessedPackageName = packageName
-
-			// Read next line after 'Package:' to get 'Version:'.
-			scanner.Scan()
-			nextLine := scanner.Text()
-			versionFields := strings.Fields(nextLine)
-			currentlyProcessedPackageVersion = versionFields[1]
+		if strings.TrimSpace(newLine) == "" {
+			savePackagesFileEntry(entryFields, packageInfo)
+			entryFields = make(map[string]string)
+			continue
 		}
-		// Save the cheksum from PACKAGES file to compare it with locally cached
-		// tar.gz checksums.
-		if strings.HasPrefix(newLine, "MD5sum:") {
-			checksumFields := strings.Fields(newLine)
-			checksum := checksumFields[1]
-			previouslyAddedPackage, ok := packageInfo[currentlyProcessedPackageName]
-			overwriteVersion := false
-			if ok {
-				// Package has already been added to packageInfo map.
-				previouslyAddedVersion := previouslyAddedPackage.Version
-				if locksmith.CheckIfVersionSufficient(currentlyProcessedPackageVersion, ">", previouslyAddedVersion) {
-					overwriteVersion = true
-				}
-			}
-			if !ok || overwriteVersion {
-				// We're adding the package to packageInfo for the first time, or the new package
-				// entry contains a newer package version than previously encountered in PACKAGES,
-				// so we treat the new one as truly latest package version in the repository.
-				packageInfo[currentlyProcessedPackageName] = &PackageInfo{
-					currentlyProcessedPackageVersion, checksum}
-			}
+		// Continuation lines of multi-line fields (such as Imports) are not needed.
+		if strings.HasPrefix(newLine, " ") || strings.HasPrefix(newLine, "\t") {
+			continue
+		}
+		fieldName, fieldValue, found := strings.Cut(newLine, ":")
+		if found {
+			entryFields[fieldName] = strings.TrimSpace(fieldValue)
 		}
 	}
+	savePackagesFileEntry(entryFields, packageInfo)
+}
+
+// savePackagesFileEntry saves the version and the MD5 checksum from the fields of PACKAGES file entry
+// to packageInfo. Entries without package name or version are ignored.
+func savePackagesFileEntry(entryFields map[string]string, packageInfo map[string]*PackageInfo) {
+	packageName := entryFields["Package"]
+	packageVersion := entryFields["Version"]
+	if packageName == "" || packageVersion == "" {
+		return
+	}
+	previouslyAddedPackage, ok := packageInfo[packageName]
+	// We're adding the package to packageInfo for the first time, or the new package
+	// entry contains a newer package version than previously encountered in PACKAGES,
+	// so we treat the new one as truly latest package version in the repository.
+	// The checksum is saved to compare it with locally cached tar.gz checksums.
+	if !ok || locksmith.CheckIfVersionSufficient(packageVersion, ">", previouslyAddedPackage.Version) {
+		packageInfo[packageName] = &PackageInfo{packageVersion, entryFields["MD5sum"], entryFields["RemoteSha"]}
+	}
 }
 
 func getBiocUrls(biocVersion string, biocUrls map[string]string) {
@@ -622,18 +692,32 @@ func getBiocUrls(biocVersion string, biocUrls map[string]string) {
 	}
 }
 
+// isBiocPackagesSnapshotMissing checks whether PACKAGES file of any Bioconductor category
+// couldn't be retrieved (see getBioConductorPackages).
+func isBiocPackagesSnapshotMissing(biocPackageInfo map[string]map[string]*PackageInfo) bool {
+	for _, biocCategory := range bioconductorCategories {
+		if _, ok := biocPackageInfo[biocCategory]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
 // getBioConductorPackages retrieves lists of package versions from predefined BioConductor categories.
-func getBioConductorPackages(biocVersion string, biocPackageInfo map[string]map[string]*PackageInfo,
-	biocUrls map[string]string, downloadFileFunction func(string, string) (int, int64)) {
+// Categories for which the PACKAGES file couldn't be retrieved are not added to biocPackageInfo.
+func getBioConductorPackages(ctx context.Context, biocVersion string,
+	biocPackageInfo map[string]map[string]*PackageInfo, biocUrls map[string]string,
+	downloadFileFunction func(string, string) (int, int64)) {
 	log.Info("Retrieving PACKAGES from BioConductor version ", biocVersion, ".")
 	for _, biocCategory := range bioconductorCategories {
-		biocPackageInfo[biocCategory] = make(map[string]*PackageInfo)
-		status, _ := downloadFileFunction(
-			biocUrls[biocCategory]+"/PACKAGES", localOutputDirectory+biocPackagesPrefix+
-				strings.ToUpper(strings.ReplaceAll(biocCategory, "/", "_")),
+		status, _, _, _ := downloadFileWithRetries(ctx,
+			getMirrorURLs(biocUrls[biocCategory]+"/PACKAGES", bioConductorURL, "Bioconductor"),
+			localOutputDirectory+biocPackagesPrefix+strings.ToUpper(strings.ReplaceAll(biocCategory, "/", "_")),
+			downloadFileFunction,
 		)
 		if status == http.StatusOK {
 			// Get BioConductor package versions and their checksums.
+			biocPackageInfo[biocCategory] = make(map[string]*PackageInfo)
 			parsePackagesFile(
 				localOutputDirectory+biocPackagesPrefix+
 					strings.ToUpper(strings.ReplaceAll(biocCategory, "/", "_")),
@@ -643,6 +727,57 @@ func getBioConductorPackages(biocVersion string, biocPackageInfo map[string]map[
 	}
 }
 
+// decompressGzipFile decompresses the gzip file to outputFile.
+func decompressGzipFile(inputFile string, outputFile string) error {
+	in, err := os.Open(inputFile)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	gzipReader, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+	out, err := os.Create(outputFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, gzipReader) // #nosec
+	return err
+}
+
+// getRepositoryPackages retrieves lists of package versions from PACKAGES files of the repositories
+// defined in renv.lock (including Linux binary repositories), except for repositoryPackageInfo which
+// have already been retrieved. PACKAGES.gz is downloaded if the repository provides it, and PACKAGES otherwise.
+func getRepositoryPackages(ctx context.Context, repositories []Rrepository,
+	repositoryPackageInfo map[string]map[string]*PackageInfo, downloadFileFunction func(string, string) (int, int64)) {
+	for _, repository := range repositories {
+		if _, ok := repositoryPackageInfo[repository.URL]; ok {
+			continue
+		}
+		log.Info("Retrieving PACKAGES from repository ", repository.Name, ".")
+		localPackagesPath := localOutputDirectory + repositoryPackagesPrefix +
+			strings.ToUpper(strings.ReplaceAll(repository.Name, "/", "_"))
+		status, _, _, _ := downloadFileWithRetries(ctx,
+			getMirrorURLs(repository.URL+srcContrib+"PACKAGES.gz", repository.URL, repository.Name),
+			localPackagesPath+".gz", downloadFileFunction)
+		if status != http.StatusOK || decompressGzipFile(localPackagesPath+".gz", localPackagesPath) != nil {
+			status, _, _, _ = downloadFileWithRetries(ctx,
+				getMirrorURLs(repository.URL+srcContrib+"PACKAGES", repository.URL, repository.Name),
+				localPackagesPath, downloadFileFunction)
+		}
+		if status != http.StatusOK {
+			log.Warn("Couldn't retrieve PACKAGES from repository ", repository.Name, ", so the newest ",
+				"versions of packages will be downloaded from ", repository.URL, " without checking Archive.")
+			continue
+		}
+		repositoryPackageInfo[repository.URL] = make(map[string]*PackageInfo)
+		parsePackagesFile(localPackagesPath, repositoryPackageInfo[repository.URL])
+	}
+}
+
 // computeChecksums iterates through files in directoryName and save the checksums of .tar.gz files found there.
 func computeChecksums(directoryPath string, localArchiveChecksums map[string]*CacheInfo) {
 	err := filepath.Walk(directoryPath, func(_ string, info os.FileInfo, _ error) error {
@@ -697,12 +832,7 @@ func downloadResultReceiver(messages chan DownloadInfo, successfulDownloads *int
 				*downloadErrors += msg.Message + ", status = " + strconv.Itoa(msg.StatusCode) + "\n"
 			}
 
-			*allDownloadInfo = append(
-				*allDownloadInfo,
-				DownloadInfo{msg.StatusCode, msg.Message, msg.ContentLength, msg.OutputLocation,
-					msg.SavedBandwidth, msg.DownloadedPackageType, msg.PackageName,
-					msg.PackageVersion, msg.GitPackageShaOrRef, msg.PackageRepository},
-			)
+			*allDownloadInfo = append(*allDownloadInfo, msg)
 
 			if *successfulDownloads+*failedDownloads == totalPackages {
 				// As soon as we got statuses for all packages we want to return to main routine.
@@ -721,15 +851,17 @@ func downloadResultReceiver(messages chan DownloadInfo, successfulDownloads *int
 }
 
 // downloadPackages downloads packages from renv.lock file and saves download result structs to allDownloadInfo.
-func downloadPackages(renvLock Renvlock, allDownloadInfo *[]DownloadInfo,
+// If ctx is cancelled, no new downloads are started and the remaining packages are marked as cancelled.
+func downloadPackages(ctx context.Context, renvLock Renvlock, allDownloadInfo *[]DownloadInfo,
 	downloadFileFunction func(string, string) (int, int64),
-	gitCloneFunction func(string, string, string, string, string) (string, int64, string)) {
+	gitCloneFunction func(string, string, string, string, string) (string, int64, int64, string)) {
 
-	// Clean up any previous downloaded data, except tar.gz packages.
+	// Clean up any previous downloaded data, except tar.gz packages and the git cache.
 	// We'll later calculate checksums for tar.gz files and compare them with checksums in
 	// in PACKAGES files, so tar.gz files don't have to be downloaded again.
+	// Packages from git repositories are checked out again from the git cache.
 	// Then, recreate these directories.
-	for _, directory := range []string{"/github", "/gitlab", "/package_files"} {
+	for _, directory := range []string{"/github", "/gitlab", "/bitbucket", "/gitea", "/git", "/package_files"} {
 		err := os.RemoveAll(localOutputDirectory + directory)
 		checkError(err)
 		err = os.MkdirAll(localOutputDirectory+directory, os.ModePerm)
@@ -745,15 +877,17 @@ func downloadPackages(renvLock Renvlock, allDownloadInfo *[]DownloadInfo,
 
 	if renvLock.Bioconductor.Version != "" {
 		getBiocUrls(renvLock.Bioconductor.Version, biocUrls)
-		getBioConductorPackages(
+		getBioConductorPackages(ctx,
 			renvLock.Bioconductor.Version, biocPackageInfo, biocUrls,
-			downloadFileFunction,
+			withPackagesSnapshots(downloadFileFunction),
 		)
 	}
 
 	localCranPackagesPath := localOutputDirectory + "/package_files/CRAN_PACKAGES"
 
+	repositoryPackageInfo := make(map[string]map[string]*PackageInfo)
 	currentCranPackageInfo := make(map[string]*PackageInfo)
+	repositoryPackageInfo[defaultCranMirrorURL] = currentCranPackageInfo
 	// Prepare a map from package name to the current versions of the
 	// packages and their checksums as read from PACKAGES file.
 	// This way, we'll know whether we should try to download the package from current CRAN repository
@@ -762,13 +896,18 @@ func downloadPackages(renvLock Renvlock, allDownloadInfo *[]DownloadInfo,
 	// This file is always downloaded because even if CRAN is not specified in renv.lock,
 	// it will be used as a fallback for packages that should be downloaded from a repository not
 	// defined in the Repositories section of renv.lock.
-	status, _ := downloadFileFunction(defaultCranMirrorURL+"/src/contrib/PACKAGES",
-		localCranPackagesPath)
+	status, _, _, _ := downloadFileWithRetries(ctx,
+		getMirrorURLs(defaultCranMirrorURL+"/src/contrib/PACKAGES", defaultCranMirrorURL, "CRAN"),
+		localCranPackagesPath, withPackagesSnapshots(downloadFileFunction))
 	if status == http.StatusOK {
 		parsePackagesFile(
 			localCranPackagesPath, currentCranPackageInfo,
 		)
 	}
+	// The same information is retrieved for other repositories defined in renv.lock.
+	repositories := getRenvRepositories(renvLock)
+	getRepositoryPackages(ctx, repositories, repositoryPackageInfo,
+		withPackagesSnapshots(downloadFileFunction))
 
 	// Before downloading any packages, check which packages have already been downloaded to the cache
 	// and calculate their checksums. Later on, if we see a package to be downloaded that will have a matching
@@ -802,13 +941,35 @@ func downloadPackages(renvLock Renvlock, allDownloadInfo *[]DownloadInfo,
 
 	log.Info("There are ", len(renvLock.Packages), " packages to be downloaded.")
 	var repoURL string
+	// Map from names of packages from r-universe, which are cloned from git repositories,
+	// to the names of the universe repositories.
+	rUniverseGitPackages := make(map[string]string)
 	for _, v := range renvLock.Packages {
 		if v.Package != "" && v.Version != "" {
-			repoURL = getRepositoryURL(v, renvLock.R.Repositories)
-			guard <- struct{}{}
+			repoURL = getRepositoryURL(v, repositories)
+			packageSource := v.Source
+			if gitSource, gitRepoURL := getRUniverseGitSource(v, repoURL,
+				repositoryPackageInfo[repoURL]); gitSource != "" {
+				packageSource, repoURL = gitSource, gitRepoURL
+				rUniverseGitPackages[v.Package] = v.Repository
+			}
+			select {
+			case <-ctx.Done():
+			case guard <- struct{}{}:
+			}
+			if isCancelled(ctx) {
+				// Sent from a goroutine, so that the results for all remaining packages are received at once.
+				go func(v Rpackage) {
+					messages <- DownloadInfo{downloadStatusCancelled, "Download of " + v.Package + " cancelled.",
+						0, "", 0, "", v.Package, v.Version, "", v.Repository, 0, ""}
+				}(v)
+				numberOfDownloads++
+				continue
+			}
 			log.Trace("Downloading package ", v.Package)
-			go downloadSinglePackage(v.Package, v.Version, repoURL, v.RemoteSha, v.RemoteRef,
-				v.Source, v.Repository, v.RemoteSubdir, currentCranPackageInfo, biocPackageInfo, biocUrls,
+			go downloadSinglePackage(ctx, v.Package, v.Version, repoURL, v.RemoteSha, v.RemoteRef,
+				packageSource, v.Repository, v.RemoteSubdir, getVerifiableRenvHash(v), repositoryPackageInfo,
+				biocPackageInfo, biocUrls,
 				localArchiveChecksums, downloadFileFunction, gitCloneFunction, messages, guard)
 			numberOfDownloads++
 		}
@@ -817,6 +978,24 @@ func downloadPackages(renvLock Renvlock, allDownloadInfo *[]DownloadInfo,
 	// Wait for downloadResultReceiver until all download statuses have been retrieved.
 	<-downloadWaiter
 
+	// Packages from r-universe are shown as coming from the universe, even if they have been cloned.
+	for i, p := range *allDownloadInfo {
+		if repository, ok := rUniverseGitPackages[p.PackageName]; ok {
+			(*allDownloadInfo)[i].PackageRepository = repository
+		}
+	}
+
+	if isCancelled(ctx) {
+		// Downloads interrupted by the cancellation fail with network or git errors.
+		for i, p := range *allDownloadInfo {
+			if p.StatusCode == -2 || p.StatusCode == -3 || p.StatusCode == -4 ||
+				p.StatusCode == downloadStatusGitCloneError {
+				(*allDownloadInfo)[i].StatusCode = downloadStatusCancelled
+			}
+		}
+		log.Warn("Download cancelled.")
+	}
+
 	if downloadErrors != "" {
 		// Not using log because we want to always see this information.
 		fmt.Println("\n\nThe following errors were encountered during download:")
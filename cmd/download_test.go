@@ -16,6 +16,10 @@ limitations under the License.
 package cmd
 
 import (
+	"compress/gzip"
+	"context"
+	"net/http"
+	"os"
 	"sort"
 	"testing"
 
@@ -38,6 +42,12 @@ func Test_getRepositoryURL(t *testing.T) {
 	assert.Equal(t, repoURL, "https://gitlab.com/RemoteUsername/RemoteRepo")
 	repoURL = getRepositoryURL(renvLock.Packages["GitlabPackage1"], renvLock.R.Repositories)
 	assert.Equal(t, repoURL, "https://gitlab.com/RemoteUsername1/RemoteRepo1")
+	repoURL = getRepositoryURL(renvLock.Packages["BitbucketPackage1"], renvLock.R.Repositories)
+	assert.Equal(t, repoURL, "https://bitbucket.org/RemoteUsername2/RemoteRepo2")
+	repoURL = getRepositoryURL(renvLock.Packages["GiteaPackage1"], renvLock.R.Repositories)
+	assert.Equal(t, repoURL, "https://gitea.example.com/RemoteUsername3/RemoteRepo3")
+	repoURL = getRepositoryURL(renvLock.Packages["GitPackage1"], renvLock.R.Repositories)
+	assert.Equal(t, repoURL, "git@git.example.com:group/GitPackage1.git")
 }
 
 func Test_parsePackagesFile(t *testing.T) {
@@ -53,8 +63,24 @@ func Test_parsePackagesFile(t *testing.T) {
 	assert.Equal(t, packages["somePackage4"].Checksum, "aaabbbcccdddeeefff")
 }
 
+func Test_parsePackagesFileFieldOrder(t *testing.T) {
+	packagesFile := t.TempDir() + "/PACKAGES"
+	err := os.WriteFile(packagesFile, []byte("Package: package1\nMD5sum: aaabbbccc\nDepends: R (>= 3.5),\n"+
+		"    package2\nVersion: 1.0.0\n\nPackage: package2\n\nVersion: 2.0.0\n\n"+
+		"Package: package3\nVersion:\n\nPackage: package4\nVersion: 0.1\n\nVersion: 0.2\nPackage: package4\n"),
+		0600)
+	assert.NoError(t, err)
+	packages := make(map[string]*PackageInfo)
+	parsePackagesFile(packagesFile, packages)
+	assert.Equal(t, packages, map[string]*PackageInfo{
+		"package1": {"1.0.0", "aaabbbccc", ""},
+		"package4": {"0.2", "", ""},
+	})
+}
+
 func Test_getPackageDetails(t *testing.T) {
 	packageInfo := make(map[string]*PackageInfo)
+	repositoryPackageInfo := map[string]map[string]*PackageInfo{defaultCranMirrorURL: packageInfo}
 	biocPackageInfo := make(map[string]map[string]*PackageInfo)
 	for _, biocCategory := range bioconductorCategories {
 		biocPackageInfo[biocCategory] = make(map[string]*PackageInfo)
@@ -62,25 +88,25 @@ func Test_getPackageDetails(t *testing.T) {
 	biocUrls := make(map[string]string)
 	localArchiveChecksums := make(map[string]*CacheInfo)
 	getBiocUrls("3.13", biocUrls)
-	localOutputDirectory = defaultDownloadDirectory
+	setWorkDirectoryPaths("/tmp/scribe", "", "")
 
 	// package1 is downloaded neither from CRAN nor from BioConductor - therefore isn't not added to any structure
 	// somePackage1 is cached
-	packageInfo["somePackage1"] = &PackageInfo{"1.0.0", "aaabbbccc"}
+	packageInfo["somePackage1"] = &PackageInfo{"1.0.0", "aaabbbccc", ""}
 	localArchiveChecksums["aaabbbccc"] = &CacheInfo{"/tmp/scribe/somePackage1_1.0.0.tar.gz", 1000}
 	// somePackage2 should be downloaded from CRAN current (we're not adding it to cache)
-	packageInfo["somePackage2"] = &PackageInfo{"2.0.0", "abcdef012"}
+	packageInfo["somePackage2"] = &PackageInfo{"2.0.0", "abcdef012", ""}
 	// somePackage3 should be downloaded from CRAN Archive - therefore it's not added to packageInfo
 	// someBiocPackage1 is cached
 	localArchiveChecksums["bcdef0123"] = &CacheInfo{"/tmp/scribe/someBiocPackage_1.0.1.tar.gz", 2000}
-	biocPackageInfo["data/experiment"]["someBiocPackage1"] = &PackageInfo{"1.0.1", "bcdef0123"}
+	biocPackageInfo["data/experiment"]["someBiocPackage1"] = &PackageInfo{"1.0.1", "bcdef0123", ""}
 	// someBiocPackage2 should be downloaded from BioConductor (we're not adding it to cache)
-	biocPackageInfo["workflows"]["someBiocPackage2"] = &PackageInfo{"2.0.1", "bbbcccddd"}
+	biocPackageInfo["workflows"]["someBiocPackage2"] = &PackageInfo{"2.0.1", "bbbcccddd", ""}
 	// someBiocPackage3 doesn't exist in any BioConductor category - therefore not added to packageInfo
 
 	action, packageType, packageURL, _, outputLocation, _, savedBandwidth := getPackageDetails(
 		"package1", "5.0.1", "https://cran.r-project.org", "SomeOtherCRAN",
-		packageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
+		repositoryPackageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
 	)
 	assert.Equal(t, action, "download")
 	assert.Equal(t, packageType, "")
@@ -90,7 +116,7 @@ func Test_getPackageDetails(t *testing.T) {
 	assert.Equal(t, savedBandwidth, int64(0))
 	action, packageType, packageURL, _, outputLocation, _, savedBandwidth = getPackageDetails(
 		"somePackage1", "1.0.0", "https://cloud.r-project.org", "CRAN",
-		packageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
+		repositoryPackageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
 	)
 	assert.Equal(t, action, "cache")
 	assert.Equal(t, packageType, "tar.gz")
@@ -104,7 +130,7 @@ func Test_getPackageDetails(t *testing.T) {
 	var fallbackOutputLocation string
 	action, packageType, packageURL, fallbackPackageURL, outputLocation, fallbackOutputLocation, savedBandwidth = getPackageDetails(
 		"somePackage2", "1.9.0", "https://cloud.r-project.org", "CRAN",
-		packageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
+		repositoryPackageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
 	)
 	assert.Equal(t, action, "download")
 	assert.Equal(t, packageType, "tar.gz")
@@ -118,7 +144,7 @@ func Test_getPackageDetails(t *testing.T) {
 
 	action, packageType, packageURL, _, outputLocation, _, savedBandwidth = getPackageDetails(
 		"somePackage2", "2.0.0", "https://cloud.r-project.org", "CRAN",
-		packageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
+		repositoryPackageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
 	)
 	assert.Equal(t, action, "download")
 	assert.Equal(t, packageType, "tar.gz")
@@ -128,7 +154,7 @@ func Test_getPackageDetails(t *testing.T) {
 	assert.Equal(t, savedBandwidth, int64(0))
 	action, packageType, packageURL, _, outputLocation, _, savedBandwidth = getPackageDetails(
 		"somePackage3", "3.0.0", "https://cloud.r-project.org", "CRAN",
-		packageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
+		repositoryPackageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
 	)
 	assert.Equal(t, action, "download")
 	assert.Equal(t, packageType, "tar.gz")
@@ -139,7 +165,7 @@ func Test_getPackageDetails(t *testing.T) {
 	assert.Equal(t, savedBandwidth, int64(0))
 	action, packageType, packageURL, _, outputLocation, _, savedBandwidth = getPackageDetails(
 		"someBiocPackage1", "1.0.1", "https://www.bioconductor.org/packages", "Bioconductor",
-		packageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
+		repositoryPackageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
 	)
 	assert.Equal(t, action, "cache")
 	assert.Equal(t, packageType, "bioconductor")
@@ -149,7 +175,7 @@ func Test_getPackageDetails(t *testing.T) {
 	assert.Equal(t, savedBandwidth, int64(2000))
 	action, packageType, packageURL, _, outputLocation, _, savedBandwidth = getPackageDetails(
 		"someBiocPackage2", "2.0.1", "https://www.bioconductor.org/packages", "Bioconductor",
-		packageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
+		repositoryPackageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
 	)
 	assert.Equal(t, action, "download")
 	assert.Equal(t, packageType, "bioconductor")
@@ -163,7 +189,7 @@ func Test_getPackageDetails(t *testing.T) {
 	// it should be attempted to download it from Bioconductor Archive.
 	action, packageType, packageURL, _, outputLocation, _, savedBandwidth = getPackageDetails(
 		"someBiocPackage2", "1.9.1", "https://www.bioconductor.org/packages", "Bioconductor",
-		packageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
+		repositoryPackageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
 	)
 	assert.Equal(t, action, "download")
 	assert.Equal(t, packageType, "bioconductor")
@@ -175,7 +201,7 @@ func Test_getPackageDetails(t *testing.T) {
 
 	action, packageType, packageURL, _, outputLocation, _, savedBandwidth = getPackageDetails(
 		"someBiocPackage3", "3.0.1", "https://www.bioconductor.org/packages", "Bioconductor",
-		packageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
+		repositoryPackageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
 	)
 	assert.Equal(t, action, "notfound_bioc")
 	assert.Equal(t, packageType, "")
@@ -186,7 +212,7 @@ func Test_getPackageDetails(t *testing.T) {
 	// git packages
 	action, packageType, packageURL, _, outputLocation, _, savedBandwidth = getPackageDetails(
 		"gitHubPackage", "0.0.5", "https://github.com/insightsengineering/gitHubPackage", "GitHub",
-		packageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
+		repositoryPackageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
 	)
 	assert.Equal(t, action, "github")
 	assert.Equal(t, packageType, "")
@@ -196,7 +222,7 @@ func Test_getPackageDetails(t *testing.T) {
 	assert.Equal(t, savedBandwidth, int64(0))
 	action, packageType, packageURL, _, outputLocation, _, savedBandwidth = getPackageDetails(
 		"gitLabPackage", "0.0.6", "https://gitlab.com/example/gitLabPackage", "GitLab",
-		packageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
+		repositoryPackageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
 	)
 	assert.Equal(t, action, "gitlab")
 	assert.Equal(t, packageType, "")
@@ -204,14 +230,112 @@ func Test_getPackageDetails(t *testing.T) {
 	assert.Equal(t, outputLocation,
 		"/tmp/scribe/downloaded_packages/gitlab/gitlab.com/example/gitLabPackage")
 	assert.Equal(t, savedBandwidth, int64(0))
+	action, _, packageURL, _, outputLocation, _, _ = getPackageDetails(
+		"gitLabPackage", "0.0.6", "git@gitlab.example.com:example/group/gitLabPackage", "GitLab",
+		repositoryPackageInfo, biocPackageInfo, biocUrls, localArchiveChecksums,
+	)
+	assert.Equal(t, action, "gitlab")
+	assert.Equal(t, packageURL, "git@gitlab.example.com:example/group/gitLabPackage")
+	assert.Equal(t, outputLocation,
+		"/tmp/scribe/downloaded_packages/gitlab/gitlab.example.com/example/group/gitLabPackage")
+}
+
+func Test_getPackageDetailsRepositoryPackages(t *testing.T) {
+	setWorkDirectoryPaths("/tmp/scribe", "", "")
+	repositoryPackageInfo := map[string]map[string]*PackageInfo{
+		"https://ppm.example.com/cran/2024-01-15": {"package1": {"1.0.0", "aaabbbccc", ""}, "package2": {"2.0.0", "", ""}},
+	}
+	localArchiveChecksums := map[string]*CacheInfo{
+		"aaabbbccc": {"/tmp/scribe/downloaded_packages/package_archives/package1_1.0.0.tar.gz", 1000},
+	}
+
+	action, _, packageURL, _, outputLocation, _, savedBandwidth := getPackageDetails(
+		"package1", "1.0.0", "https://ppm.example.com/cran/2024-01-15", "Repository",
+		repositoryPackageInfo, nil, nil, localArchiveChecksums,
+	)
+	assert.Equal(t, action, "cache")
+	assert.Equal(t, packageURL, "https://ppm.example.com/cran/2024-01-15/src/contrib/package1_1.0.0.tar.gz")
+	assert.Equal(t, outputLocation, "/tmp/scribe/downloaded_packages/package_archives/package1_1.0.0.tar.gz")
+	assert.Equal(t, savedBandwidth, int64(1000))
+
+	action, _, packageURL, fallbackPackageURL, _, _, _ := getPackageDetails(
+		"package2", "1.9.0", "https://ppm.example.com/cran/2024-01-15", "Repository",
+		repositoryPackageInfo, nil, nil, localArchiveChecksums,
+	)
+	assert.Equal(t, action, "download")
+	assert.Equal(t, packageURL,
+		"https://ppm.example.com/cran/2024-01-15/src/contrib/Archive/package2/package2_1.9.0.tar.gz")
+	assert.Equal(t, fallbackPackageURL, "https://ppm.example.com/cran/2024-01-15/src/contrib/package2_2.0.0.tar.gz")
+
+	// Package not listed in PACKAGES file.
+	action, _, packageURL, fallbackPackageURL, _, _, _ = getPackageDetails(
+		"package3", "3.0.0", "https://ppm.example.com/cran/2024-01-15", "Repository",
+		repositoryPackageInfo, nil, nil, localArchiveChecksums,
+	)
+	assert.Equal(t, action, "download")
+	assert.Equal(t, packageURL,
+		"https://ppm.example.com/cran/2024-01-15/src/contrib/Archive/package3/package3_3.0.0.tar.gz")
+	assert.Equal(t, fallbackPackageURL, "https://ppm.example.com/cran/2024-01-15/src/contrib/package3_3.0.0.tar.gz")
+}
+
+func Test_getRepositoryPackages(t *testing.T) {
+	previousOutputDirectory := localOutputDirectory
+	localOutputDirectory = t.TempDir()
+	defer func() { localOutputDirectory = previousOutputDirectory }()
+	err := os.MkdirAll(localOutputDirectory+"/package_files", os.ModePerm)
+	assert.NoError(t, err)
+	packagesContents := "Package: package1\nVersion: 1.0.0\nMD5sum: aaabbbccc\n\n" +
+		"Package: package2\nVersion: 2.0.0\n"
+	// repository1 provides PACKAGES.gz, repository2 only PACKAGES, repository3 neither of them.
+	downloadFileFunction := func(url string, outputFile string) (int, int64) {
+		switch url {
+		case "https://repository1.example.com/src/contrib/PACKAGES.gz":
+			file, err := os.Create(outputFile)
+			assert.NoError(t, err)
+			defer file.Close()
+			gzipWriter := gzip.NewWriter(file)
+			_, err = gzipWriter.Write([]byte(packagesContents))
+			assert.NoError(t, err)
+			assert.NoError(t, gzipWriter.Close())
+			return http.StatusOK, 1
+		case "https://repository2.example.com/src/contrib/PACKAGES":
+			err := os.WriteFile(outputFile, []byte(packagesContents), 0600)
+			assert.NoError(t, err)
+			return http.StatusOK, 1
+		case "https://repository4.example.com/src/contrib/PACKAGES.gz":
+			t.Error("PACKAGES downloaded again.")
+		case "https://packagemanager.posit.co/cran/__linux__/jammy/latest/src/contrib/PACKAGES":
+			err := os.WriteFile(outputFile, []byte(packagesContents), 0600)
+			assert.NoError(t, err)
+			return http.StatusOK, 1
+		}
+		return http.StatusNotFound, 0
+	}
+	repositoryPackageInfo := map[string]map[string]*PackageInfo{"https://repository4.example.com": {}}
+	getRepositoryPackages(context.Background(), []Rrepository{
+		{"Repository1", "https://repository1.example.com"},
+		{"Repository2", "https://repository2.example.com"},
+		{"Repository3", "https://repository3.example.com"},
+		{"Repository4", "https://repository4.example.com"},
+		{"PPM", "https://packagemanager.posit.co/cran/__linux__/jammy/latest"},
+	}, repositoryPackageInfo, downloadFileFunction)
+	assert.Equal(t, len(repositoryPackageInfo), 4)
+	for _, repoURL := range []string{"https://repository1.example.com", "https://repository2.example.com",
+		"https://packagemanager.posit.co/cran/__linux__/jammy/latest"} {
+		assert.Equal(t, repositoryPackageInfo[repoURL]["package1"], &PackageInfo{"1.0.0", "aaabbbccc", ""}, repoURL)
+		assert.Equal(t, repositoryPackageInfo[repoURL]["package2"], &PackageInfo{"2.0.0", "", ""}, repoURL)
+	}
 }
 
 func mockedDownloadFile(_ string, _ string) (int, int64) {
 	return 200, 1
 }
 
-func mockedCloneGitRepo(_ string, _ string, _ string, _ string, _ string) (string, int64, string) {
-	return "", 1, "v0.0.1"
+func mockedCloneGitRepo(_ string, _ string, _ string, commitSha string, _ string) (string, int64, int64, string) {
+	if commitSha != "" {
+		return "", 1, 0, commitSha
+	}
+	return "", 1, 0, "v0.0.1"
 }
 
 func Test_downloadPackages(t *testing.T) {
@@ -219,7 +343,7 @@ func Test_downloadPackages(t *testing.T) {
 	maxDownloadRoutines = 10
 	getRenvLock("testdata/renv.lock.empty.json", &renvLock)
 	var allDownloadInfo []DownloadInfo
-	downloadPackages(renvLock, &allDownloadInfo, mockedDownloadFile, mockedCloneGitRepo)
+	downloadPackages(context.Background(), renvLock, &allDownloadInfo, mockedDownloadFile, mockedCloneGitRepo)
 	var localFiles []string
 	var messages []string
 	for _, v := range allDownloadInfo {
@@ -229,6 +353,9 @@ func Test_downloadPackages(t *testing.T) {
 	sort.Strings(localFiles)
 	sort.Strings(messages)
 	assert.Equal(t, localFiles, []string{"",
+		"/tmp/scribe/downloaded_packages/bitbucket/bitbucket.org/RemoteUsername2/RemoteRepo2",
+		"/tmp/scribe/downloaded_packages/git/git.example.com/group/GitPackage1",
+		"/tmp/scribe/downloaded_packages/gitea/gitea.example.com/RemoteUsername3/RemoteRepo3",
 		"/tmp/scribe/downloaded_packages/github/RemoteUsername/RemoteRepo",
 		"/tmp/scribe/downloaded_packages/github/RemoteUsername/RemoteRepo",
 		"/tmp/scribe/downloaded_packages/gitlab/gitlab.com/RemoteUsername/RemoteRepo",
@@ -239,13 +366,57 @@ func Test_downloadPackages(t *testing.T) {
 		"/tmp/scribe/downloaded_packages/package_archives/SomePackage_1.0.0.tar.gz"},
 	)
 	assert.Equal(t, messages, []string{"Couldn't find SomeBiocPackage version 1.0.1 in BioConductor.",
+		"git@git.example.com:group/GitPackage1.git",
+		"https://bitbucket.org/RemoteUsername2/RemoteRepo2",
 		"https://cloud.r-project.org/src/contrib/Archive/SomeOtherPackage3/SomeOtherPackage3_1.0.0.tar.gz",
 		"https://cloud.r-project.org/src/contrib/Archive/SomeOtherPackage4/SomeOtherPackage4_1.0.0.tar.gz",
 		"https://cloud.r-project.org/src/contrib/Archive/SomeOtherPackage5/SomeOtherPackage5_1.0.0.tar.gz",
 		"https://cloud.r-project.org/src/contrib/Archive/SomePackage/SomePackage_1.0.0.tar.gz",
+		"https://gitea.example.com/RemoteUsername3/RemoteRepo3",
 		"https://github.com/RemoteUsername/RemoteRepo",
 		"https://github.com/RemoteUsername/RemoteRepo",
 		"https://gitlab.com/RemoteUsername/RemoteRepo",
 		"https://gitlab.com/RemoteUsername1/RemoteRepo1"},
 	)
 }
+
+func Test_downloadPackagesCranMirror(t *testing.T) {
+	var renvLock Renvlock
+	maxDownloadRoutines = 10
+	repositoryMirrors = map[string][]string{"CRAN": {"https://cran-mirror.example.com"}}
+	defer func() { repositoryMirrors = nil }()
+	getRenvLock("testdata/renv.lock.empty.json", &renvLock)
+	var allDownloadInfo []DownloadInfo
+	downloadFileFunction := func(url string, outputFile string) (int, int64) {
+		switch url {
+		case defaultCranMirrorURL + "/src/contrib/PACKAGES":
+			return http.StatusNotFound, 0
+		case "https://cran-mirror.example.com/src/contrib/PACKAGES":
+			err := os.WriteFile(outputFile, []byte("Package: SomePackage\nVersion: 1.0.0\n"), 0600)
+			checkError(err)
+		}
+		return http.StatusOK, 1
+	}
+	downloadPackages(context.Background(), renvLock, &allDownloadInfo, downloadFileFunction, mockedCloneGitRepo)
+	for _, v := range allDownloadInfo {
+		if v.PackageName == "SomePackage" {
+			// The current version of the package is found in the PACKAGES file downloaded from the mirror.
+			assert.Equal(t, v.Message, defaultCranMirrorURL+"/src/contrib/SomePackage_1.0.0.tar.gz")
+		}
+	}
+}
+
+func Test_downloadPackagesCancelled(t *testing.T) {
+	var renvLock Renvlock
+	maxDownloadRoutines = 10
+	getRenvLock("testdata/renv.lock.empty.json", &renvLock)
+	var allDownloadInfo []DownloadInfo
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	downloadPackages(ctx, renvLock, &allDownloadInfo, mockedDownloadFile, mockedCloneGitRepo)
+	assert.Equal(t, len(allDownloadInfo), len(renvLock.Packages))
+	for _, v := range allDownloadInfo {
+		assert.Equal(t, v.StatusCode, downloadStatusCancelled)
+		assert.Equal(t, v.OutputLocation, "")
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const upstreamFingerprintKey = "upstreamFingerprint"
+
+// StageFingerprint describes the inputs which have been used to compute results of a pipeline stage.
+// It is stored next to the stage results, so that the cached results are only reused
+// if the inputs haven't changed since.
+type StageFingerprint struct {
+	Inputs map[string]string `json:"inputs"`
+	Hash   string            `json:"hash"`
+}
+
+// newStageFingerprint returns a fingerprint with hash computed from all inputs.
+func newStageFingerprint(inputs map[string]string) StageFingerprint {
+	var keys []string
+	for k := range inputs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k + "=" + inputs[k] + "\n"))
+	}
+	return StageFingerprint{inputs, hex.EncodeToString(h.Sum(nil))}
+}
+
+// getFileHash returns SHA256 checksum of the file contents, or empty string
+// if the file can't be read.
+func getFileHash(fileName string) string {
+	byteValue, err := os.ReadFile(fileName)
+	checkError(err)
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256(byteValue)
+	return hex.EncodeToString(hash[:])
+}
+
+// getDownloadFingerprint returns fingerprint of inputs to the download stage.
+func getDownloadFingerprint(lockfileHash string, rVersion string) StageFingerprint {
+	return newStageFingerprint(map[string]string{
+		"renvLockHash": lockfileHash,
+		"rVersion":     rVersion,
+		"downloadDir":  localOutputDirectory,
+		// Mirrors may make it possible to download packages which previously failed to download.
+		"repositoryMirrors": repositoryMirrorsExpression,
+		// Packages missing from the offline cache may be downloaded when running online.
+		"offline": strconv.FormatBool(offline),
+	})
+}
+
+// getInstallFingerprint returns fingerprint of inputs to the installation stage.
+func getInstallFingerprint(downloadFingerprint StageFingerprint, rVersion string) StageFingerprint {
+	return newStageFingerprint(map[string]string{
+		upstreamFingerprintKey: downloadFingerprint.Hash,
+		"rVersion":             rVersion,
+		"buildOptions":         buildOptions,
+		"installOptions":       installOptions,
+		"includeSuggests":      strconv.FormatBool(includeSuggests),
+		"libraryPath":          temporaryLibPath,
+		"buildTimeout":         buildTimeout.String(),
+		"installTimeout":       installTimeout.String(),
+		"packageTimeouts":      packageTimeoutsExpression,
+	})
+}
+
+// getCheckFingerprint returns fingerprint of inputs to the R CMD check stage.
+func getCheckFingerprint(installFingerprint StageFingerprint, rVersion string) StageFingerprint {
+	return newStageFingerprint(map[string]string{
+		upstreamFingerprintKey: installFingerprint.Hash,
+		"rVersion":             rVersion,
+		"checkPackage":         checkPackageExpression,
+		"checkAllPackages":     strconv.FormatBool(checkAllPackages),
+		"checkOptions":         checkOptions,
+		"rCmdCheckFailRegex":   rCmdCheckFailRegex,
+		"checkTimeout":         checkTimeout.String(),
+		"packageTimeouts":      packageTimeoutsExpression,
+	})
+}
+
+// getFingerprintFileName returns the name of file where fingerprint of stage results
+// stored in stageResultsFileName is saved.
+func getFingerprintFileName(stageResultsFileName string) string {
+	return strings.TrimSuffix(stageResultsFileName, ".json") + ".fingerprint.json"
+}
+
+// readStageFingerprint reads fingerprint of stage results stored in stageResultsFileName.
+// Returns false if the fingerprint doesn't exist.
+func readStageFingerprint(stageResultsFileName string, fingerprint *StageFingerprint) bool {
+	fingerprintFileName := getFingerprintFileName(stageResultsFileName)
+	if _, err := os.Stat(fingerprintFileName); err != nil {
+		return false
+	}
+	readJSON(fingerprintFileName, fingerprint)
+	return true
+}
+
+// writeStageFingerprint saves fingerprint of stage results stored in stageResultsFileName.
+func writeStageFingerprint(stageResultsFileName string, fingerprint StageFingerprint) {
+	writeJSON(getFingerprintFileName(stageResultsFileName), fingerprint)
+}
+
+// removeStageFingerprint removes fingerprint of stage results stored in stageResultsFileName,
+// so that the results are not reused in subsequent runs.
+func removeStageFingerprint(stageResultsFileName string) {
+	err := os.RemoveAll(getFingerprintFileName(stageResultsFileName))
+	checkError(err)
+}
+
+// getFingerprintDifferences returns human-readable descriptions of inputs which differ
+// between the cached and the current fingerprint.
+func getFingerprintDifferences(cached StageFingerprint, current StageFingerprint) []string {
+	var differences []string
+	var keys []string
+	for k := range current.Inputs {
+		keys = append(keys, k)
+	}
+	for k := range cached.Inputs {
+		if _, ok := current.Inputs[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cachedValue, cachedOk := cached.Inputs[k]
+		currentValue, currentOk := current.Inputs[k]
+		switch {
+		case k == upstreamFingerprintKey && cachedValue != currentValue:
+			differences = append(differences, "results of the preceding stage changed")
+		case !cachedOk || !currentOk || cachedValue != currentValue:
+			differences = append(differences, k+` changed from "`+cachedValue+`" to "`+currentValue+`"`)
+		}
+	}
+	return differences
+}
+
+// isStageCacheValid checks whether the cached results stored in stageResultsFileName
+// have been computed with inputs described by the current fingerprint.
+// If not, the reason is logged.
+func isStageCacheValid(stageResultsFileName string, current StageFingerprint) bool {
+	var cached StageFingerprint
+	if !readStageFingerprint(stageResultsFileName, &cached) {
+		log.Info("Cached results in ", stageResultsFileName, " have no fingerprint and will be recomputed.")
+		return false
+	}
+	if cached.Hash == current.Hash {
+		return true
+	}
+	log.Info("Cached results in ", stageResultsFileName, " are outdated and will be recomputed:")
+	for _, d := range getFingerprintDifferences(cached, current) {
+		log.Info("  ", d)
+	}
+	return false
+}
+
+// isOnlyUpstreamChanged checks whether the cached results stored in stageResultsFileName have been computed
+// with the same inputs as described by the current fingerprint, except for the results of the preceding stage.
+// In that case, the results for packages not affected by the changes in preceding stage can be reused.
+func isOnlyUpstreamChanged(stageResultsFileName string, current StageFingerprint) bool {
+	var cached StageFingerprint
+	if !readStageFingerprint(stageResultsFileName, &cached) {
+		return false
+	}
+	if len(cached.Inputs) != len(current.Inputs) {
+		return false
+	}
+	for k, v := range current.Inputs {
+		cachedValue, ok := cached.Inputs[k]
+		if k != upstreamFingerprintKey && (!ok || cachedValue != v) {
+			return false
+		}
+	}
+	return true
+}
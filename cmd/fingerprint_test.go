@@ -0,0 +1,89 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_newStageFingerprint(t *testing.T) {
+	fingerprint1 := newStageFingerprint(map[string]string{"a": "1", "b": "2"})
+	fingerprint2 := newStageFingerprint(map[string]string{"b": "2", "a": "1"})
+	fingerprint3 := newStageFingerprint(map[string]string{"a": "1", "b": "3"})
+	assert.Equal(t, fingerprint1.Hash, fingerprint2.Hash)
+	assert.NotEqual(t, fingerprint1.Hash, fingerprint3.Hash)
+}
+
+func Test_getFingerprintDifferences(t *testing.T) {
+	cached := newStageFingerprint(map[string]string{
+		upstreamFingerprintKey: "aaa", "installOptions": "", "buildOptions": "--no-manual",
+	})
+	current := newStageFingerprint(map[string]string{
+		upstreamFingerprintKey: "bbb", "installOptions": "--no-docs", "buildOptions": "--no-manual",
+	})
+	assert.Equal(t, getFingerprintDifferences(cached, current), []string{
+		`installOptions changed from "" to "--no-docs"`,
+		"results of the preceding stage changed",
+	})
+	assert.Equal(t, len(getFingerprintDifferences(cached, cached)), 0)
+}
+
+func Test_getFingerprintChain(t *testing.T) {
+	buildOptions = ""
+	installOptions = ""
+	downloadFingerprint1 := getDownloadFingerprint("lockfileHash1", "R version 4.3.1")
+	downloadFingerprint2 := getDownloadFingerprint("lockfileHash2", "R version 4.3.1")
+	installFingerprint1 := getInstallFingerprint(downloadFingerprint1, "R version 4.3.1")
+	installFingerprint2 := getInstallFingerprint(downloadFingerprint2, "R version 4.3.1")
+	// Change of renv.lock invalidates results of subsequent stages.
+	assert.NotEqual(t, installFingerprint1.Hash, installFingerprint2.Hash)
+	assert.NotEqual(t,
+		getCheckFingerprint(installFingerprint1, "R version 4.3.1").Hash,
+		getCheckFingerprint(installFingerprint2, "R version 4.3.1").Hash,
+	)
+	installOptions = "--no-docs"
+	assert.NotEqual(t, installFingerprint1.Hash, getInstallFingerprint(downloadFingerprint1, "R version 4.3.1").Hash)
+	installOptions = ""
+}
+
+func Test_isStageCacheValid(t *testing.T) {
+	stageResultsFile := "testdata/stageResults.json"
+	writeJSON(stageResultsFile, []DownloadInfo{})
+	defer os.Remove(stageResultsFile)
+	fingerprint := newStageFingerprint(map[string]string{"renvLockHash": "aaa"})
+	assert.False(t, isStageCacheValid(stageResultsFile, fingerprint))
+	writeStageFingerprint(stageResultsFile, fingerprint)
+	defer os.Remove(getFingerprintFileName(stageResultsFile))
+	assert.True(t, isStageCacheValid(stageResultsFile, fingerprint))
+	assert.False(t, isStageCacheValid(stageResultsFile, newStageFingerprint(map[string]string{"renvLockHash": "bbb"})))
+}
+
+func Test_isOnlyUpstreamChanged(t *testing.T) {
+	stageResultsFile := "testdata/stageResultsUpstream.json"
+	writeStageFingerprint(stageResultsFile, newStageFingerprint(map[string]string{
+		upstreamFingerprintKey: "aaa", "installOptions": "",
+	}))
+	defer os.Remove(getFingerprintFileName(stageResultsFile))
+	assert.True(t, isOnlyUpstreamChanged(stageResultsFile, newStageFingerprint(map[string]string{
+		upstreamFingerprintKey: "bbb", "installOptions": "",
+	})))
+	assert.False(t, isOnlyUpstreamChanged(stageResultsFile, newStageFingerprint(map[string]string{
+		upstreamFingerprintKey: "bbb", "installOptions": "--no-docs",
+	})))
+}
@@ -0,0 +1,379 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// Subdirectory of localOutputDirectory with bare repositories mirroring git remotes.
+// Contrary to the directories with checked out packages, it's preserved between runs.
+const gitCacheSubdirectory = "/git_cache"
+
+// Prefix of local references under which the fetched commits are stored in the git cache.
+const gitCacheRefPrefix = "refs/scribe/"
+
+// Map from git cache directory to mutex, ensuring that packages from the same repository
+// (e.g. from different subdirectories) don't update the cached repository at the same time.
+var gitCacheLocks sync.Map
+
+// getGitFetchOptions returns options for fetching refSpecs from the repository at repoURL
+// according to TLS and proxy settings. The URL of the remote stored in the cached repository is
+// overridden, because the cache may have been created for the same repository with a different URL
+// (e.g. https:// instead of git@), which requires different authentication.
+func getGitFetchOptions(repoURL string, auth transport.AuthMethod, refSpecs ...config.RefSpec) *git.FetchOptions {
+	return &git.FetchOptions{
+		RemoteURL:       repoURL,
+		RefSpecs:        refSpecs,
+		Auth:            auth,
+		CABundle:        caBundleContents,
+		InsecureSkipTLS: insecureSkipTLSVerify,
+		ProxyOptions:    getGitProxyOptions(repoURL),
+	}
+}
+
+// Prefixes tried, in this order, when looking for the remote reference matching RemoteRef (like in git rev-parse).
+// Empty prefix matches full reference names, such as HEAD or refs/pull/123/head.
+var gitRefNamePrefixes = []string{"", "refs/", "refs/tags/", "refs/heads/", "refs/remotes/"}
+
+// RemoteRef which doesn't match any reference, but matches this regex, is treated as commit SHA.
+var gitShaRegex = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// getGitCacheDirectory returns the directory of the bare repository mirroring the remote at repoURL.
+func getGitCacheDirectory(repoURL string) string {
+	host, _, path := parseGitRemoteURL(repoURL)
+	return filepath.Join(localOutputDirectory+gitCacheSubdirectory, strings.ReplaceAll(host, ":", "_"),
+		filepath.FromSlash(strings.TrimSuffix(path, ".git")+".git"))
+}
+
+// lockGitCache locks the git cache directory, and returns the function unlocking it.
+func lockGitCache(gitCacheDirectory string) func() {
+	mutex, _ := gitCacheLocks.LoadOrStore(gitCacheDirectory, &sync.Mutex{})
+	mutex.(*sync.Mutex).Lock()
+	return mutex.(*sync.Mutex).Unlock
+}
+
+// getGitCacheSize returns the size of packfiles in the cached repository. Since the packfiles
+// are stored as received from the remote, it's also the number of bytes transferred to the cache.
+func getGitCacheSize(gitCacheDirectory string) int64 {
+	var size int64
+	entries, err := os.ReadDir(filepath.Join(gitCacheDirectory, "objects", "pack"))
+	if err != nil {
+		return 0
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".pack") {
+			continue
+		}
+		info, err := entry.Info()
+		checkError(err)
+		if err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// openGitCache opens the cached bare repository mirroring repoURL, or creates it if it doesn't exist.
+func openGitCache(gitCacheDirectory string, repoURL string) (*git.Repository, error) {
+	repository, err := git.PlainOpen(gitCacheDirectory)
+	if err == nil {
+		return repository, nil
+	}
+	if !errors.Is(err, git.ErrRepositoryNotExists) {
+		return nil, err
+	}
+	err = os.MkdirAll(gitCacheDirectory, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	repository, err = git.PlainInit(gitCacheDirectory, true)
+	if err != nil {
+		return nil, err
+	}
+	_, err = repository.CreateRemote(&config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{repoURL},
+	})
+	return repository, err
+}
+
+// isShallowGitCache checks whether the cached repository has been fetched without history.
+func isShallowGitCache(repository *git.Repository) bool {
+	shallowCommits, err := repository.Storer.Shallow()
+	checkError(err)
+	return len(shallowCommits) > 0
+}
+
+// resolveGitRemoteRef finds the reference advertised by the remote of the cached repository, which matches
+// branchOrTagName: tag name, branch name, or full reference name. Empty branchOrTagName means the default branch.
+// Returns the full reference name and the SHA it points to. If no reference matches branchOrTagName,
+// but it looks like a commit SHA, empty reference name is returned.
+func resolveGitRemoteRef(ctx context.Context, repository *git.Repository, repoURL string, auth transport.AuthMethod,
+	branchOrTagName string) (plumbing.ReferenceName, plumbing.Hash, error) {
+	if branchOrTagName == "" {
+		branchOrTagName = plumbing.HEAD.String()
+	}
+	// The references are listed from repoURL rather than from the URL stored in the cached repository
+	// (see getGitFetchOptions).
+	remote := git.NewRemote(repository.Storer, &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{repoURL},
+	})
+	remoteRefs, err := remote.ListContext(ctx, &git.ListOptions{
+		Auth:            auth,
+		CABundle:        caBundleContents,
+		InsecureSkipTLS: insecureSkipTLSVerify,
+		ProxyOptions:    getGitProxyOptions(repoURL),
+		PeelingOption:   git.IgnorePeeled,
+	})
+	if err != nil {
+		return "", plumbing.ZeroHash, err
+	}
+	refs := make(map[plumbing.ReferenceName]*plumbing.Reference)
+	for _, ref := range remoteRefs {
+		refs[ref.Name()] = ref
+	}
+	for _, prefix := range gitRefNamePrefixes {
+		ref, ok := refs[plumbing.ReferenceName(prefix+branchOrTagName)]
+		if !ok {
+			continue
+		}
+		// HEAD is advertised as symbolic reference to the default branch.
+		if ref.Type() == plumbing.SymbolicReference {
+			target, ok := refs[ref.Target()]
+			if !ok {
+				return "", plumbing.ZeroHash, fmt.Errorf("reference %s not found in remote", ref.Target())
+			}
+			return ref.Name(), target.Hash(), nil
+		}
+		return ref.Name(), ref.Hash(), nil
+	}
+	if gitShaRegex.MatchString(branchOrTagName) {
+		return "", plumbing.ZeroHash, nil
+	}
+	return "", plumbing.ZeroHash, fmt.Errorf("reference %s not found in remote", branchOrTagName)
+}
+
+// peelGitTag returns the SHA of the commit which the annotated tag points to.
+// If hash is not an annotated tag, it's returned unchanged.
+func peelGitTag(repository *git.Repository, hash plumbing.Hash) (plumbing.Hash, error) {
+	tag, err := repository.TagObject(hash)
+	if err == plumbing.ErrObjectNotFound {
+		return hash, nil
+	}
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	commit, err := tag.Commit()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return commit.Hash, nil
+}
+
+// fetchToGitCache fetches the commit to be checked out (commitSha, or the commit which branchOrTagName
+// or the default branch of the remote point to) from repoURL to the cached repository. If the cache already
+// has the commit, nothing is fetched. Returns the cached repository, the SHA of the commit, and the size
+// of the cache before fetching.
+// New caches are fetched without history. If the server doesn't support it, the whole repository is fetched.
+// In offline mode, the commit is looked up in the cache only.
+func fetchToGitCache(ctx context.Context, gitCacheDirectory string, repoURL string, auth transport.AuthMethod,
+	commitSha string, branchOrTagName string) (*git.Repository, plumbing.Hash, int64, error) {
+	if offline {
+		return resolveFromOfflineGitCache(gitCacheDirectory, repoURL, commitSha, branchOrTagName)
+	}
+	repository, err := openGitCache(gitCacheDirectory, repoURL)
+	if err != nil {
+		return nil, plumbing.ZeroHash, 0, err
+	}
+	cachedBytes := getGitCacheSize(gitCacheDirectory)
+	var refSpec config.RefSpec
+	var wantedHash plumbing.Hash
+	if commitSha == "" {
+		refName, hash, err2 := resolveGitRemoteRef(ctx, repository, repoURL, auth, branchOrTagName)
+		if err2 != nil {
+			return nil, plumbing.ZeroHash, cachedBytes, err2
+		}
+		if refName == "" {
+			log.Debug(branchOrTagName, " is not a reference in ", repoURL, ". Assuming it's a commit SHA.")
+			commitSha = branchOrTagName
+		} else {
+			log.Info(refName, " of ", repoURL, " points to ", hash.String())
+			wantedHash = hash
+			localRefName := refName
+			if refName == plumbing.HEAD {
+				localRefName = gitCacheRefPrefix + plumbing.HEAD
+			}
+			refSpec = config.RefSpec("+" + refName + ":" + localRefName)
+		}
+	}
+	if commitSha != "" {
+		hash, err2 := repository.ResolveRevision(plumbing.Revision(commitSha))
+		if err2 == nil {
+			log.Info("Commit ", commitSha, " of ", repoURL, " found in git cache.")
+			return repository, *hash, cachedBytes, nil
+		}
+		// Fetching a commit by its SHA requires full SHA, and the support of the server.
+		refSpec = config.RefSpec(commitSha + ":" + gitCacheRefPrefix + "commits/" + commitSha)
+	} else if repository.Storer.HasEncodedObject(wantedHash) == nil {
+		log.Info("Commit ", wantedHash.String(), " of ", repoURL, " found in git cache.")
+		hash, err2 := peelGitTag(repository, wantedHash)
+		return repository, hash, cachedBytes, err2
+	}
+	fetchOptions := getGitFetchOptions(repoURL, auth, refSpec)
+	fetchOptions.Tags = git.NoTags
+	// Fetch without history, unless the cache already contains the whole history.
+	shallow := cachedBytes == 0 || isShallowGitCache(repository)
+	if shallow {
+		if commitSha != "" && !plumbing.IsHash(commitSha) {
+			err = errors.New("only full commit SHA can be fetched without history")
+		} else {
+			fetchOptions.Depth = 1
+		}
+	}
+	if err == nil {
+		log.Info("Fetching ", refSpec.Src(), " from ", repoURL, " to ", gitCacheDirectory)
+		err = repository.FetchContext(ctx, fetchOptions)
+	}
+	// Errors such as missing credentials would occur when fetching the whole repository as well.
+	if err != nil && err != git.NoErrAlreadyUpToDate && !isPermanentCloneError(err.Error()) && !isCancelled(ctx) {
+		log.Warn("Fetching ", refSpec.Src(), " from ", repoURL, " failed: ", err, ". Fetching the whole repository.")
+		if shallow {
+			// Start from scratch, because history can't be fetched to a shallow repository.
+			err = os.RemoveAll(gitCacheDirectory)
+			checkError(err)
+			cachedBytes = 0
+			repository, err = openGitCache(gitCacheDirectory, repoURL)
+			if err != nil {
+				return nil, plumbing.ZeroHash, 0, err
+			}
+		}
+		refSpecs := []config.RefSpec{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"}
+		if commitSha == "" {
+			// The reference may be outside of branches and tags, e.g. refs/pull/123/head.
+			refSpecs = append(refSpecs, refSpec)
+		}
+		fetchOptions = getGitFetchOptions(repoURL, auth, refSpecs...)
+		fetchOptions.Tags = git.NoTags
+		err = repository.FetchContext(ctx, fetchOptions)
+	}
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return nil, plumbing.ZeroHash, cachedBytes, err
+	}
+	if commitSha != "" {
+		hash, err2 := repository.ResolveRevision(plumbing.Revision(commitSha))
+		if err2 != nil {
+			return nil, plumbing.ZeroHash, cachedBytes, fmt.Errorf("couldn't find commit %s: %w", commitSha, err2)
+		}
+		return repository, *hash, cachedBytes, nil
+	}
+	hash, err := peelGitTag(repository, wantedHash)
+	return repository, hash, cachedBytes, err
+}
+
+// checkoutGitWorktree writes the files of the commit from the cached repository to gitDirectory.
+// Returns the total size of the written files.
+func checkoutGitWorktree(repository *git.Repository, hash plumbing.Hash, gitDirectory string) (int64, error) {
+	commit, err := repository.CommitObject(hash)
+	if err != nil {
+		return 0, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return 0, err
+	}
+	rootDirectory := filepath.Clean(gitDirectory) + string(os.PathSeparator)
+	var checkedOutBytes int64
+	err = tree.Files().ForEach(func(f *object.File) error {
+		path := filepath.Join(gitDirectory, filepath.FromSlash(f.Name))
+		if !strings.HasPrefix(path, rootDirectory) {
+			return errors.New("invalid path in repository: " + f.Name)
+		}
+		err := os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if err != nil {
+			return err
+		}
+		checkedOutBytes += f.Size
+		if f.Mode == filemode.Symlink {
+			target, err := f.Contents()
+			if err != nil {
+				return err
+			}
+			if os.Symlink(target, path) == nil {
+				return nil
+			}
+			// Symbolic links may not be supported (e.g. on Windows), in which case
+			// the link target is written to a regular file, like git does.
+		}
+		osMode, err := f.Mode.ToOSFileMode()
+		if err != nil {
+			return err
+		}
+		reader, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, osMode.Perm())
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		_, err = io.Copy(out, reader)
+		return err
+	})
+	return checkedOutBytes, err
+}
+
+// checkoutFromGitCache fetches the commit to be checked out to the git cache (see fetchToGitCache)
+// and writes its files to gitDirectory. Returns the number of bytes transferred from the remote,
+// the number of bytes which didn't have to be transferred, and the SHA of the checked out commit.
+// Bandwidth is saved only if the commit has been found in the cache and nothing has been fetched,
+// in which case it's the size of the checked out files.
+func checkoutFromGitCache(ctx context.Context, gitDirectory string, repoURL string, auth transport.AuthMethod,
+	commitSha string, branchOrTagName string) (int64, int64, plumbing.Hash, error) {
+	gitCacheDirectory := getGitCacheDirectory(repoURL)
+	defer lockGitCache(gitCacheDirectory)()
+	repository, hash, cachedBytes, err := fetchToGitCache(ctx, gitCacheDirectory, repoURL, auth,
+		commitSha, branchOrTagName)
+	transferredBytes := getGitCacheSize(gitCacheDirectory) - cachedBytes
+	if err != nil {
+		return transferredBytes, 0, plumbing.ZeroHash, err
+	}
+	log.Info("Checking out ", hash.String(), " of ", repoURL, " to ", gitDirectory)
+	checkedOutBytes, err := checkoutGitWorktree(repository, hash, gitDirectory)
+	var savedBytes int64
+	if transferredBytes == 0 {
+		savedBytes = checkedOutBytes
+	}
+	return transferredBytes, savedBytes, hash, err
+}
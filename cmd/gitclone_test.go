@@ -0,0 +1,197 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+)
+
+// commitTestFile writes DESCRIPTION file with the given version to the repository and commits it.
+// Returns the commit SHA.
+func commitTestFile(t *testing.T, repository *git.Repository, directory string, version string) string {
+	err := os.WriteFile(filepath.Join(directory, "DESCRIPTION"),
+		[]byte("Package: gitPackage\nVersion: "+version+"\n"), 0600)
+	assert.NoError(t, err)
+	w, err := repository.Worktree()
+	assert.NoError(t, err)
+	_, err = w.Add("DESCRIPTION")
+	assert.NoError(t, err)
+	hash, err := w.Commit("Version "+version, &git.CommitOptions{
+		Author: &object.Signature{Name: "scribe", Email: "scribe@example.com", When: time.Now()},
+	})
+	assert.NoError(t, err)
+	return hash.String()
+}
+
+// createTestGitRepo creates a repository with two commits. The first one is tagged v1.0 and 1.2.0
+// (annotated tag), and is pointed to by release-2024 branch and refs/pull/123/head.
+// Returns the repository directory and SHAs of both commits.
+func createTestGitRepo(t *testing.T, allowSHA1InWant bool) (string, string, string) {
+	directory := t.TempDir()
+	repository, err := git.PlainInit(directory, false)
+	assert.NoError(t, err)
+	firstSha := commitTestFile(t, repository, directory, "1.0")
+	head, err := repository.Head()
+	assert.NoError(t, err)
+	_, err = repository.CreateTag("v1.0", head.Hash(), nil)
+	assert.NoError(t, err)
+	_, err = repository.CreateTag("1.2.0", head.Hash(), &git.CreateTagOptions{
+		Message: "Release 1.2.0",
+		Tagger:  &object.Signature{Name: "scribe", Email: "scribe@example.com", When: time.Now()},
+	})
+	assert.NoError(t, err)
+	for _, refName := range []string{"refs/heads/release-2024", "refs/pull/123/head"} {
+		err = repository.Storer.SetReference(plumbing.NewHashReference(plumbing.ReferenceName(refName), head.Hash()))
+		assert.NoError(t, err)
+	}
+	secondSha := commitTestFile(t, repository, directory, "1.1")
+	if allowSHA1InWant {
+		cfg, err := repository.Config()
+		assert.NoError(t, err)
+		cfg.Raw.Section("uploadpack").SetOption("allowReachableSHA1InWant", "true")
+		err = repository.SetConfig(cfg)
+		assert.NoError(t, err)
+	}
+	return directory, firstSha, secondSha
+}
+
+func readTestDescription(t *testing.T, gitDirectory string) string {
+	contents, err := os.ReadFile(filepath.Join(gitDirectory, "DESCRIPTION"))
+	assert.NoError(t, err)
+	return string(contents)
+}
+
+func hasShallowGitCache(repoURL string) bool {
+	_, err := os.Stat(filepath.Join(getGitCacheDirectory(repoURL), "shallow"))
+	return err == nil
+}
+
+func Test_cloneGitRepoShallow(t *testing.T) {
+	previousOutputDirectory := localOutputDirectory
+	localOutputDirectory = t.TempDir()
+	defer func() { localOutputDirectory = previousOutputDirectory }()
+	repoDirectory, firstSha, secondSha := createTestGitRepo(t, true)
+	repoURL := "file://" + repoDirectory
+
+	gitDirectory := filepath.Join(t.TempDir(), "default")
+	message, transferredBytes, savedBandwidth, gitPackageShaOrRef := cloneGitRepo(gitDirectory, repoURL, "", "", "")
+	assert.Equal(t, message, "")
+	assert.Greater(t, transferredBytes, int64(0))
+	assert.Equal(t, savedBandwidth, int64(0))
+	assert.Equal(t, gitPackageShaOrRef, secondSha)
+	assert.True(t, hasShallowGitCache(repoURL))
+	assert.Contains(t, readTestDescription(t, gitDirectory), "Version: 1.1")
+
+	gitDirectory = filepath.Join(t.TempDir(), "tag")
+	message, _, _, gitPackageShaOrRef = cloneGitRepo(gitDirectory, repoURL, "", "", "v1.0")
+	assert.Equal(t, message, "")
+	assert.Equal(t, gitPackageShaOrRef, firstSha)
+	assert.Contains(t, readTestDescription(t, gitDirectory), "Version: 1.0")
+
+	// The commit has already been fetched together with the tag.
+	gitDirectory = filepath.Join(t.TempDir(), "sha")
+	message, transferredBytes, savedBandwidth, gitPackageShaOrRef = cloneGitRepo(gitDirectory, repoURL, "",
+		firstSha, "")
+	assert.Equal(t, message, "")
+	assert.Equal(t, transferredBytes, int64(0))
+	// Only the size of the checked out files is counted, not the size of the whole cache.
+	assert.Equal(t, savedBandwidth, int64(len("Package: gitPackage\nVersion: 1.0\n")))
+	assert.Equal(t, gitPackageShaOrRef, firstSha)
+	assert.Contains(t, readTestDescription(t, gitDirectory), "Version: 1.0")
+}
+
+func Test_cloneGitRepoFallback(t *testing.T) {
+	previousOutputDirectory := localOutputDirectory
+	localOutputDirectory = t.TempDir()
+	defer func() { localOutputDirectory = previousOutputDirectory }()
+	// Server doesn't allow fetching commits by SHA, so the whole repository has to be fetched.
+	repoDirectory, firstSha, secondSha := createTestGitRepo(t, false)
+	repoURL := "file://" + repoDirectory
+	gitDirectory := filepath.Join(t.TempDir(), "first")
+	message, transferredBytes, _, gitPackageShaOrRef := cloneGitRepo(gitDirectory, repoURL, "", firstSha, "")
+	assert.Equal(t, message, "")
+	assert.Greater(t, transferredBytes, int64(0))
+	assert.Equal(t, gitPackageShaOrRef, firstSha)
+	assert.False(t, hasShallowGitCache(repoURL))
+	assert.Contains(t, readTestDescription(t, gitDirectory), "Version: 1.0")
+
+	// The whole history is in the cache, so nothing has to be transferred.
+	gitDirectory = filepath.Join(t.TempDir(), "second")
+	message, transferredBytes, savedBandwidth, gitPackageShaOrRef := cloneGitRepo(gitDirectory, repoURL, "",
+		secondSha, "")
+	assert.Equal(t, message, "")
+	assert.Equal(t, transferredBytes, int64(0))
+	assert.Greater(t, savedBandwidth, int64(0))
+	assert.Equal(t, gitPackageShaOrRef, secondSha)
+	assert.Contains(t, readTestDescription(t, gitDirectory), "Version: 1.1")
+}
+
+func Test_cloneGitRepoRefs(t *testing.T) {
+	previousOutputDirectory := localOutputDirectory
+	localOutputDirectory = t.TempDir()
+	defer func() { localOutputDirectory = previousOutputDirectory }()
+	repoDirectory, firstSha, secondSha := createTestGitRepo(t, true)
+	repoURL := "file://" + repoDirectory
+
+	for _, ref := range []string{"1.2.0", "release-2024", "refs/pull/123/head", "refs/tags/v1.0", firstSha[:10]} {
+		gitDirectory := filepath.Join(t.TempDir(), "package")
+		message, _, _, gitPackageShaOrRef := cloneGitRepo(gitDirectory, repoURL, "", "", ref)
+		assert.Equal(t, message, "", ref)
+		assert.Equal(t, gitPackageShaOrRef, firstSha, ref)
+		assert.Contains(t, readTestDescription(t, gitDirectory), "Version: 1.0", ref)
+	}
+	gitDirectory := filepath.Join(t.TempDir(), "package")
+	message, _, _, gitPackageShaOrRef := cloneGitRepo(gitDirectory, repoURL, "", "", "master")
+	assert.Equal(t, message, "")
+	assert.Equal(t, gitPackageShaOrRef, secondSha)
+
+	message, _, _, _ = cloneGitRepo(filepath.Join(t.TempDir(), "package"), repoURL, "", "", "nonexistent")
+	assert.Contains(t, message, "reference nonexistent not found in remote")
+	assert.False(t, isTransientCloneError(message))
+}
+
+func Test_cloneGitRepoChangedRemoteURL(t *testing.T) {
+	previousOutputDirectory := localOutputDirectory
+	localOutputDirectory = t.TempDir()
+	defer func() { localOutputDirectory = previousOutputDirectory }()
+	repoDirectory, firstSha, secondSha := createTestGitRepo(t, true)
+	repoURL := "file://" + repoDirectory
+	message, _, _, _ := cloneGitRepo(filepath.Join(t.TempDir(), "first"), repoURL, "", firstSha, "")
+	assert.Equal(t, message, "")
+
+	// The cache has been created with a different URL of the same repository.
+	cache, err := git.PlainOpen(getGitCacheDirectory(repoURL))
+	assert.NoError(t, err)
+	cfg, err := cache.Config()
+	assert.NoError(t, err)
+	cfg.Remotes[git.DefaultRemoteName].URLs = []string{"file://" + filepath.Join(t.TempDir(), "nonexistent")}
+	assert.NoError(t, cache.SetConfig(cfg))
+
+	gitDirectory := filepath.Join(t.TempDir(), "second")
+	message, transferredBytes, _, gitPackageShaOrRef := cloneGitRepo(gitDirectory, repoURL, "", "", "master")
+	assert.Equal(t, message, "")
+	assert.Greater(t, transferredBytes, int64(0))
+	assert.Equal(t, gitPackageShaOrRef, secondSha)
+	assert.Contains(t, readTestDescription(t, gitDirectory), "Version: 1.1")
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/spf13/viper"
+)
+
+// Username used for HTTP basic authentication with a token, if no username has been configured.
+// GitHub and GitLab accept any non-empty username together with a Personal Access Token.
+const defaultGitTokenUsername = "This can be any string."
+
+// Username used for SSH connections, if the remote URL doesn't specify one.
+const defaultGitSSHUsername = "git"
+
+// Prefix of environment variables with git tokens, e.g. SCRIBE_GIT_GITLAB_EXAMPLE_COM_TOKEN.
+const gitCredentialsEnvPrefix = "SCRIBE_GIT_"
+
+// GitCredentials are credentials used to clone git repositories from a given host.
+type GitCredentials struct {
+	// Host as in the RemoteHost renv.lock field, e.g. gitlab.example.com.
+	Host string `mapstructure:"host"`
+	// Username and Token are used for HTTPS remotes.
+	Username string `mapstructure:"username"`
+	Token    string `mapstructure:"token"`
+	// SSHKey is the path to the private key used for SSH remotes. If empty, ssh-agent is used.
+	SSHKey           string `mapstructure:"sshKey"`
+	SSHKeyPassphrase string `mapstructure:"sshKeyPassphrase"`
+}
+
+// Map from host to git credentials read from the configuration file. Initialized in initializeRun.
+var gitCredentials map[string]GitCredentials
+
+// normalizeGitHost strips the URL scheme, username and trailing slash from RemoteHost,
+// so that e.g. "https://gitlab.example.com/", "git@gitlab.example.com" and "gitlab.example.com"
+// all refer to the same host. GitHub API host used by renv is mapped to github.com.
+func normalizeGitHost(remoteHost string) string {
+	host := remoteHost
+	if _, after, found := strings.Cut(host, "://"); found {
+		host = after
+	}
+	if _, after, found := strings.Cut(host, "@"); found {
+		host = after
+	}
+	host = strings.TrimSuffix(host, "/")
+	if host == "api.github.com" {
+		host = "github.com"
+	}
+	return host
+}
+
+// Paths of API endpoints which renv stores in RemoteHost of Bitbucket and Gitea packages.
+var gitAPIPaths = []string{"/2.0", "/rest/api/1.0", "/api/v1"}
+
+// getGitWebHost returns the host serving git repositories, given RemoteHost pointing to the API endpoint,
+// e.g. api.bitbucket.org/2.0 is mapped to bitbucket.org and gitea.example.com/api/v1 to gitea.example.com.
+// Empty RemoteHost is mapped to bitbucket.org, which is the default host used by renv for Bitbucket.
+func getGitWebHost(remoteHost string) string {
+	remoteHost = strings.TrimSuffix(remoteHost, "/")
+	for _, apiPath := range gitAPIPaths {
+		remoteHost = strings.TrimSuffix(remoteHost, apiPath)
+	}
+	switch remoteHost {
+	case "", "api.bitbucket.org":
+		return "bitbucket.org"
+	}
+	return strings.Replace(remoteHost, "://api.bitbucket.org", "://bitbucket.org", 1)
+}
+
+// isSCPLikeGitURL checks whether the URL has the form user@host:path.
+func isSCPLikeGitURL(repoURL string) bool {
+	before, _, found := strings.Cut(repoURL, ":")
+	return found && !strings.Contains(repoURL, "://") && strings.Contains(before, "@")
+}
+
+// getGitRemoteURL returns URL of the repository at remoteHost. SSH remotes are supported
+// by specifying remoteHost as git@host or ssh://git@host. Otherwise, HTTPS URL is returned.
+func getGitRemoteURL(remoteHost string, remoteUsername string, remoteRepo string) string {
+	remoteHost = strings.TrimSuffix(remoteHost, "/")
+	switch {
+	case strings.Contains(remoteHost, "://"):
+		return remoteHost + "/" + remoteUsername + "/" + remoteRepo
+	case strings.Contains(remoteHost, "@"):
+		return remoteHost + ":" + remoteUsername + "/" + remoteRepo
+	}
+	return "https://" + remoteHost + "/" + remoteUsername + "/" + remoteRepo
+}
+
+// parseGitRemoteURL returns the host, the username (empty if not present in the URL) and the repository path
+// from either standard URL (e.g. https://host/group/repo or ssh://git@host/group/repo),
+// or SCP-like URL (e.g. git@host:group/repo).
+func parseGitRemoteURL(repoURL string) (string, string, string) {
+	if isSCPLikeGitURL(repoURL) {
+		userHost, path, _ := strings.Cut(repoURL, ":")
+		user, host, _ := strings.Cut(userHost, "@")
+		return host, user, strings.Trim(path, "/")
+	}
+	parsedURL, err := url.Parse(repoURL)
+	if err != nil {
+		return "", "", ""
+	}
+	return parsedURL.Host, parsedURL.User.Username(), strings.Trim(parsedURL.Path, "/")
+}
+
+// isSSHGitURL checks whether the repository should be cloned with SSH.
+func isSSHGitURL(repoURL string) bool {
+	return isSCPLikeGitURL(repoURL) || strings.HasPrefix(repoURL, "ssh://")
+}
+
+// readGitCredentials reads the list of git credentials from the configuration file.
+func readGitCredentials() map[string]GitCredentials {
+	var credentialsList []GitCredentials
+	err := viper.UnmarshalKey("gitCredentials", &credentialsList)
+	if err != nil {
+		log.Error("Couldn't read gitCredentials from the configuration file: ", err)
+	}
+	credentialsMap := make(map[string]GitCredentials)
+	for _, c := range credentialsList {
+		credentialsMap[normalizeGitHost(c.Host)] = c
+	}
+	return credentialsMap
+}
+
+// getGitToken returns the token for HTTPS remote at host, taken from the first of the following sources:
+// * gitCredentials entry for the host,
+// * SCRIBE_GIT_<HOST>_TOKEN environment variable, where <HOST> is the upper-cased host name,
+// * GITLAB_TOKEN or GITHUB_TOKEN environment variable, depending on environmentCredentialsType.
+func getGitToken(host string, environmentCredentialsType string) (string, string) {
+	credentials := gitCredentials[host]
+	username := credentials.Username
+	if username == "" {
+		username = defaultGitTokenUsername
+	}
+	if credentials.Token != "" {
+		return username, credentials.Token
+	}
+	if token := os.Getenv(gitCredentialsEnvPrefix + getRepositoryEnvName(host) + "_TOKEN"); token != "" {
+		return username, token
+	}
+	switch environmentCredentialsType {
+	case gitlab:
+		return username, os.Getenv("GITLAB_TOKEN")
+	case github:
+		return username, os.Getenv("GITHUB_TOKEN")
+	}
+	return username, ""
+}
+
+// getGitAuth returns authentication method used to clone or fetch the repository at repoURL.
+// For SSH remotes, the private key configured for the host is used, or ssh-agent if no key is configured.
+// For HTTPS remotes, the token is used with HTTP basic authentication (see getGitToken).
+// Returns nil if no credentials are available.
+func getGitAuth(repoURL string, environmentCredentialsType string) (transport.AuthMethod, error) {
+	host, user, _ := parseGitRemoteURL(repoURL)
+	host = normalizeGitHost(host)
+	if isSSHGitURL(repoURL) {
+		if user == "" {
+			user = defaultGitSSHUsername
+		}
+		credentials := gitCredentials[host]
+		if credentials.SSHKey != "" {
+			log.Debug("Using SSH key ", credentials.SSHKey, " for ", host)
+			return gitssh.NewPublicKeysFromFile(user, credentials.SSHKey, credentials.SSHKeyPassphrase)
+		}
+		log.Debug("Using ssh-agent for ", host)
+		return gitssh.NewSSHAgentAuth(user)
+	}
+	username, token := getGitToken(host, environmentCredentialsType)
+	if token == "" {
+		return nil, nil
+	}
+	return &githttp.BasicAuth{Username: username, Password: token}, nil
+}
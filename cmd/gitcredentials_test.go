@@ -0,0 +1,95 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"testing"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_normalizeGitHost(t *testing.T) {
+	assert.Equal(t, normalizeGitHost("https://gitlab.example.com/"), "gitlab.example.com")
+	assert.Equal(t, normalizeGitHost("git@gitlab.example.com"), "gitlab.example.com")
+	assert.Equal(t, normalizeGitHost("ssh://git@gitlab.example.com"), "gitlab.example.com")
+	assert.Equal(t, normalizeGitHost("api.github.com"), "github.com")
+}
+
+func Test_getGitWebHost(t *testing.T) {
+	assert.Equal(t, getGitWebHost("api.bitbucket.org/2.0"), "bitbucket.org")
+	assert.Equal(t, getGitWebHost("https://api.bitbucket.org/2.0/"), "https://bitbucket.org")
+	assert.Equal(t, getGitWebHost(""), "bitbucket.org")
+	assert.Equal(t, getGitWebHost("bitbucket.example.com/rest/api/1.0"), "bitbucket.example.com")
+	assert.Equal(t, getGitWebHost("gitea.example.com/api/v1"), "gitea.example.com")
+	assert.Equal(t, getGitWebHost("git@gitea.example.com"), "git@gitea.example.com")
+}
+
+func Test_getGitRemoteURL(t *testing.T) {
+	assert.Equal(t, getGitRemoteURL("gitlab.example.com", "group", "repo"),
+		"https://gitlab.example.com/group/repo")
+	assert.Equal(t, getGitRemoteURL("https://gitlab.example.com/", "group", "repo"),
+		"https://gitlab.example.com/group/repo")
+	assert.Equal(t, getGitRemoteURL("git@gitlab.example.com", "group", "sub/repo"),
+		"git@gitlab.example.com:group/sub/repo")
+	assert.Equal(t, getGitRemoteURL("ssh://git@gitlab.example.com:2222", "group", "repo"),
+		"ssh://git@gitlab.example.com:2222/group/repo")
+}
+
+func Test_parseGitRemoteURL(t *testing.T) {
+	host, user, path := parseGitRemoteURL("https://gitlab.example.com/group/sub/repo")
+	assert.Equal(t, []string{host, user, path}, []string{"gitlab.example.com", "", "group/sub/repo"})
+	host, user, path = parseGitRemoteURL("git@gitlab.example.com:group/repo")
+	assert.Equal(t, []string{host, user, path}, []string{"gitlab.example.com", "git", "group/repo"})
+	host, user, path = parseGitRemoteURL("ssh://deploy@gitlab.example.com:2222/group/repo")
+	assert.Equal(t, []string{host, user, path}, []string{"gitlab.example.com:2222", "deploy", "group/repo"})
+	assert.True(t, isSSHGitURL("git@gitlab.example.com:group/repo"))
+	assert.True(t, isSSHGitURL("ssh://git@gitlab.example.com/group/repo"))
+	assert.False(t, isSSHGitURL("https://gitlab.example.com/group/repo"))
+}
+
+func Test_getGitAuth(t *testing.T) {
+	t.Setenv("GITLAB_TOKEN", "gitlab-token")
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("SCRIBE_GIT_GITLAB2_EXAMPLE_COM_TOKEN", "env-token")
+	t.Setenv("SSH_AUTH_SOCK", "")
+	previousGitCredentials := gitCredentials
+	defer func() { gitCredentials = previousGitCredentials }()
+	gitCredentials = map[string]GitCredentials{
+		"gitlab1.example.com": {Host: "gitlab1.example.com", Username: "user", Token: "config-token"},
+		"ssh.example.com":     {Host: "ssh.example.com", SSHKey: "/nonexistent/id_ed25519"},
+	}
+
+	auth, err := getGitAuth("https://gitlab1.example.com/group/repo", gitlab)
+	assert.NoError(t, err)
+	assert.Equal(t, auth, &githttp.BasicAuth{Username: "user", Password: "config-token"})
+	auth, err = getGitAuth("https://gitlab2.example.com/group/repo", gitlab)
+	assert.NoError(t, err)
+	assert.Equal(t, auth, &githttp.BasicAuth{Username: defaultGitTokenUsername, Password: "env-token"})
+	auth, err = getGitAuth("https://gitlab3.example.com/group/repo", gitlab)
+	assert.NoError(t, err)
+	assert.Equal(t, auth, &githttp.BasicAuth{Username: defaultGitTokenUsername, Password: "gitlab-token"})
+	auth, err = getGitAuth("https://github.com/group/repo", github)
+	assert.NoError(t, err)
+	assert.Nil(t, auth)
+
+	// Configured SSH key doesn't exist.
+	_, err = getGitAuth("git@ssh.example.com:group/repo", gitlab)
+	assert.Error(t, err)
+	// No SSH key configured and ssh-agent not running.
+	_, err = getGitAuth("git@other.example.com:group/repo", gitlab)
+	assert.Error(t, err)
+}
@@ -17,15 +17,20 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
-const packageLogPath = "/tmp/scribe/installed_logs"
-const buildLogPath = "/tmp/scribe/build_logs"
+var packageLogPath string
+var buildLogPath string
+
 const gitConst = "git"
 const htmlExtension = ".html"
 
@@ -38,6 +43,23 @@ type InstallResultInfo struct {
 	LogFilePath      string `json:"logFilePath"`
 	BuildStatus      string `json:"buildStatus"`
 	BuildLogFilePath string `json:"buildLogFilePath"`
+	// Version, RemoteSha and Hash of the package as defined in renv.lock at the time of installation.
+	// Used to determine whether the package has to be reinstalled in subsequent runs.
+	LockfileVersion string `json:"lockfileVersion,omitempty"`
+	RemoteSha       string `json:"remoteSha,omitempty"`
+	Hash            string `json:"hash,omitempty"`
+	// For packages which are part of a dependency cycle: the cycle, starting and ending with the package.
+	DependencyCycle []string `json:"dependencyCycle,omitempty"`
+	// Time (in seconds) which building and installation of the package took.
+	InstallTime int `json:"installTime,omitempty"`
+	// Whether the installation result has been reused from the previous run.
+	Reused bool `json:"reused,omitempty"`
+	// For packages skipped because of a failed dependency: the chain of dependencies leading
+	// from the package to the dependency which failed to download or install (the last element).
+	FailedDependencyChain []string `json:"failedDependencyChain,omitempty"`
+	// Suggested packages which are not present in renv.lock, and therefore haven't been installed.
+	// They may be required by R CMD check.
+	MissingSuggests []string `json:"missingSuggests,omitempty"`
 }
 
 type BuildPackageChanInfo struct {
@@ -61,10 +83,15 @@ type DownloadedPackage struct {
 const InstallResultInfoStatusSucceeded = "SUCCEEDED"
 const InstallResultInfoStatusFailed = "FAILED"
 const InstallResultInfoStatusBuildFailed = "BUILD_FAILED"
+const InstallResultInfoStatusSkipped = "SKIPPED_DEPENDENCY_FAILED"
+const InstallResultInfoStatusDependencyCycle = "DEPENDENCY_CYCLE"
+const InstallResultInfoStatusTimeout = "TIMEOUT"
+const InstallResultInfoStatusCancelled = "CANCELLED"
 
 const buildStatusSucceeded = "SUCCEEDED"
 const buildStatusFailed = "FAILED"
 const buildStatusNotBuilt = "NOT_BUILT"
+const buildStatusTimeout = "TIMEOUT"
 
 const rLibsVarName = "R_LIBS="
 
@@ -98,7 +125,7 @@ func logError(outputLocation string, packageName string, e error, path string) {
 }
 
 // buildPackage runs R CMD build on packages downloaded from git repositories.
-func buildPackage(buildPackageChan chan BuildPackageChanInfo, packageName string,
+func buildPackage(ctx context.Context, buildPackageChan chan BuildPackageChanInfo, packageName string,
 	outputLocation string, buildLogFilePath string, additionalOptions string) {
 	log.Info("Package ", packageName, " located in ", outputLocation, " is a source package so it has to be built first.")
 	cmd := rExecutable + " CMD build " + additionalOptions + " " + outputLocation
@@ -117,12 +144,16 @@ func buildPackage(buildPackageChan chan BuildPackageChanInfo, packageName string
 		return
 	}
 	// Execute the command.
-	output, err := execCommand(cmd, false,
+	output, err := execCommandContext(ctx, cmd, getTimeout(packageName, buildStage), false,
 		[]string{rLibsVarName + rLibsPaths, "LANG=en_US.UTF-8"}, buildLogFile, false)
 	if err != nil {
 		log.Error("Error running ", cmd, "\nDetails: outputLocation: ", outputLocation, " packageName: ",
 			packageName, "\nerr: ", err, "\noutput: ", output)
-		buildPackageChan <- BuildPackageChanInfo{buildStatusFailed, outputLocation, err}
+		if errors.Is(err, errCommandTimeout) {
+			buildPackageChan <- BuildPackageChanInfo{buildStatusTimeout, outputLocation, err}
+		} else {
+			buildPackageChan <- BuildPackageChanInfo{buildStatusFailed, outputLocation, err}
+		}
 		return
 	}
 	// Close HTML tags.
@@ -144,15 +175,17 @@ func buildPackage(buildPackageChan chan BuildPackageChanInfo, packageName string
 }
 
 // executeRCmdInstall runs the R CMD INSTALL in a goroutine and sends back the result to executeInstallation.
-func executeRCmdInstall(execRCmdInstallChan chan ExecRCmdInstallChanInfo, cmd string, logFile *os.File) {
-	output, err := execCommand(cmd, false,
+// R CMD INSTALL is killed if it doesn't complete within the timeout.
+func executeRCmdInstall(ctx context.Context, execRCmdInstallChan chan ExecRCmdInstallChanInfo, cmd string,
+	logFile *os.File, timeout time.Duration) {
+	output, err := execCommandContext(ctx, cmd, timeout, false,
 		[]string{rLibsVarName + rLibsPaths, "LANG=en_US.UTF-8"}, logFile, false)
 	execRCmdInstallChan <- ExecRCmdInstallChanInfo{output, err}
 }
 
 // executeInstallation runs the R CMD build goroutine (for git packages), R CMD INSTALL goroutine
 // and returns the build status (succeeded, failed or package not built).
-func executeInstallation(outputLocation, packageName, logFilePath, buildLogFilePath, packageType string,
+func executeInstallation(ctx context.Context, outputLocation, packageName, logFilePath, buildLogFilePath, packageType string,
 	additionalBuildOptions string, additionalInstallOptions string) (string, error) {
 	log.Trace("Executing installation step on package ", packageName, " located in ", outputLocation)
 	logFile, logFileErr := os.OpenFile(logFilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
@@ -169,11 +202,13 @@ func executeInstallation(outputLocation, packageName, logFilePath, buildLogFileP
 		return buildStatus, createHTMLTagsErr
 	}
 
+	// Only packages from git repositories have to be built. Source tar.gz packages and binary packages
+	// (including Linux binary packages) are installed directly.
 	if packageType == gitConst {
 		// By default previous outputLocation will be returned, except if package is successfully built.
 		// In the latter case, tar.gz package name will be returned as outputLocation.
 		buildPackageChan := make(chan BuildPackageChanInfo)
-		go buildPackage(buildPackageChan, packageName, outputLocation, buildLogFilePath, additionalBuildOptions)
+		go buildPackage(ctx, buildPackageChan, packageName, outputLocation, buildLogFilePath, additionalBuildOptions)
 		var waitInterval = 1
 		var totalWaitTime = 0
 		// Wait until buildPackage() completes.
@@ -202,7 +237,7 @@ func executeInstallation(outputLocation, packageName, logFilePath, buildLogFileP
 	cmd := rExecutable + " CMD INSTALL --no-lock -l " + temporaryLibPath + " " + additionalInstallOptions + " " + outputLocation
 	log.Trace("Executing command: " + cmd)
 	execRCmdInstallChan := make(chan ExecRCmdInstallChanInfo)
-	go executeRCmdInstall(execRCmdInstallChan, cmd, logFile)
+	go executeRCmdInstall(ctx, execRCmdInstallChan, cmd, logFile, getTimeout(packageName, installStage))
 	var waitInterval = 1
 	var totalWaitTime = 0
 	var output string
@@ -236,11 +271,11 @@ r_cmd_install_loop:
 }
 
 // installSinglePackage triggers installation of a single R package and sends back the result to installPackages.
-func installSinglePackage(installResultChan chan InstallResultInfo, packageName string, packageType string,
-	inputLocation string, additionalBuildOptions string, additionalInstallOptions string) {
+func installSinglePackage(ctx context.Context, installResultChan chan InstallResultInfo, packageName string,
+	packageType string, inputLocation string, additionalBuildOptions string, additionalInstallOptions string) {
 	logFilePath := filepath.Join(packageLogPath, packageName+htmlExtension)
 	buildLogFilePath := filepath.Join(buildLogPath, packageName+htmlExtension)
-	buildStatus, err := executeInstallation(inputLocation, packageName,
+	buildStatus, err := executeInstallation(ctx, inputLocation, packageName,
 		logFilePath, buildLogFilePath, packageType, additionalBuildOptions, additionalInstallOptions)
 	packageVersion := ""
 	var status string
@@ -250,6 +285,10 @@ func installSinglePackage(installResultChan chan InstallResultInfo, packageName
 		installedDesc := parseDescriptionFile(descFilePath)
 		packageVersion = installedDesc["Version"]
 		status = InstallResultInfoStatusSucceeded
+	case errors.Is(err, errCommandCancelled):
+		status = InstallResultInfoStatusCancelled
+	case errors.Is(err, errCommandTimeout):
+		status = InstallResultInfoStatusTimeout
 	case buildStatus == buildStatusFailed:
 		status = InstallResultInfoStatusBuildFailed
 	default:
@@ -318,48 +357,297 @@ func mapTrueLength(m map[string]bool) int {
 	return trueLength
 }
 
-// getPackageToInstall gets the first available package from the ready-to-install queue.
+// getPackageToInstall gets the available package with the highest priority from the ready-to-install queue.
+// Packages with equal priority are chosen in alphabetical order. If priorities are nil,
+// the first available package is returned.
 func getPackageToInstall(
 	packagesBeingInstalled map[string]bool,
 	readyPackages map[string]bool,
+	priorities map[string]int,
 ) string {
+	var packageToInstall string
 	for k, v := range readyPackages {
-		if v {
-			packagesBeingInstalled[k] = true
-			readyPackages[k] = false
-			return k
+		if !v {
+			continue
+		}
+		if priorities == nil {
+			packageToInstall = k
+			break
+		}
+		if packageToInstall == "" || priorities[k] > priorities[packageToInstall] ||
+			(priorities[k] == priorities[packageToInstall] && k < packageToInstall) {
+			packageToInstall = k
 		}
 	}
-	return ""
+	if packageToInstall != "" {
+		packagesBeingInstalled[packageToInstall] = true
+		readyPackages[packageToInstall] = false
+	}
+	return packageToInstall
 }
 
 // getPackagesNotInstalled iterates through the map of all packages and their dependencies to check
 // if installation of them all was at least attempted. If not, this means that there is a set of packages for which
-// the dependency resolution wasn't successful and has to be investigated, e.g. a dependency cycle.
-func getPackagesNotInstalled(dependencies map[string][]string, installedPackages []string) {
-	packagesNotInstalled := false
+// the dependency resolution wasn't successful and has to be investigated. Dependency cycles are detected
+// before the installation starts, so this is not expected to happen.
+// Returns the map from names of such packages to the lists of their dependencies which haven't been installed.
+func getPackagesNotInstalled(dependencies map[string][]string, installedPackages []string) map[string][]string {
+	packagesNotInstalled := make(map[string][]string)
 	for packageName, packageDeps := range dependencies {
+		if stringInSlice(packageName, installedPackages) {
+			continue
+		}
 		for _, d := range packageDeps {
 			if !stringInSlice(d, installedPackages) {
 				log.Warn("Package ", packageName, " could not be installed because its dependency ", d, " could not be installed.")
-				packagesNotInstalled = true
+				packagesNotInstalled[packageName] = append(packagesNotInstalled[packageName], d)
+			}
+		}
+	}
+	if len(packagesNotInstalled) > 0 {
+		log.Error("Dependency resolution failed for the above set of packages.")
+	}
+	return packagesNotInstalled
+}
+
+// getNotInstalledDependencyChain returns the chain of dependencies leading from packageName to the package
+// which couldn't be installed for reasons other than its dependencies not being installed (or to the package
+// closing a dependency cycle). packagesNotInstalled is the map returned by getPackagesNotInstalled.
+func getNotInstalledDependencyChain(packageName string, packagesNotInstalled map[string][]string) []string {
+	var dependencyChain []string
+	visitedPackages := map[string]bool{packageName: true}
+	for currentPackage := packageName; len(packagesNotInstalled[currentPackage]) > 0; {
+		currentPackage = packagesNotInstalled[currentPackage][0]
+		dependencyChain = append(dependencyChain, currentPackage)
+		if visitedPackages[currentPackage] {
+			break
+		}
+		visitedPackages[currentPackage] = true
+	}
+	return dependencyChain
+}
+
+// getUninstallablePackages returns packages which can't be installed because they haven't been
+// downloaded successfully, or because other packages depend on them but they are not scheduled
+// for installation. Packages which have already been processed are omitted.
+func getUninstallablePackages(
+	dependencies map[string][]string,
+	downloadedPackages map[string]DownloadedPackage,
+	installedPackages []string,
+) []string {
+	var uninstallablePackages []string
+	isUninstallable := func(packageName string) bool {
+		if stringInSlice(packageName, installedPackages) || stringInSlice(packageName, uninstallablePackages) {
+			return false
+		}
+		if _, ok := dependencies[packageName]; !ok {
+			return true
+		}
+		downloadedPackage, ok := downloadedPackages[packageName]
+		return !ok || downloadedPackage.Location == ""
+	}
+	for packageName, packageDeps := range dependencies {
+		if isUninstallable(packageName) {
+			uninstallablePackages = append(uninstallablePackages, packageName)
+		}
+		for _, d := range packageDeps {
+			if isUninstallable(d) {
+				uninstallablePackages = append(uninstallablePackages, d)
+			}
+		}
+	}
+	sort.Strings(uninstallablePackages)
+	return uninstallablePackages
+}
+
+// skipPackagesWithFailedDependencies marks as skipped the packages which haven't been processed yet,
+// and which directly or transitively depend on any of the failedPackages. failedPackages maps the names
+// of packages which failed to install (or have been skipped) to the chain of dependencies leading
+// from them to the package which originally failed. Skipped packages are added to failedPackages
+// and installedPackages. Returns the installation results of skipped packages.
+func skipPackagesWithFailedDependencies(
+	dependencies map[string][]string,
+	downloadedPackages map[string]DownloadedPackage,
+	installedPackages *[]string,
+	packagesBeingInstalled map[string]bool,
+	failedPackages map[string][]string,
+) []InstallResultInfo {
+	var skippedPackages []InstallResultInfo
+	// Repeat until no more packages are skipped, so that transitive dependents are skipped as well.
+	for packageSkipped := true; packageSkipped; {
+		packageSkipped = false
+		for packageName, packageDeps := range dependencies {
+			if packagesBeingInstalled[packageName] || stringInSlice(packageName, *installedPackages) {
+				continue
+			}
+			for _, d := range packageDeps {
+				failedChain, failed := failedPackages[d]
+				if !failed {
+					continue
+				}
+				failedDependencyChain := append([]string{}, failedChain...)
+				log.Warn("Skipping installation of ", packageName, " because ",
+					failedDependencyChain[len(failedDependencyChain)-1], " failed (dependency chain: ",
+					strings.Join(append([]string{packageName}, failedDependencyChain...), " → "), ").")
+				failedPackages[packageName] = append([]string{packageName}, failedDependencyChain...)
+				*installedPackages = append(*installedPackages, packageName)
+				skippedPackages = append(skippedPackages, InstallResultInfo{
+					PackageName:           packageName,
+					InputLocation:         downloadedPackages[packageName].Location,
+					PackageType:           downloadedPackages[packageName].PackageType,
+					PackageVersion:        downloadedPackages[packageName].PackageVersion,
+					Status:                InstallResultInfoStatusSkipped,
+					BuildStatus:           buildStatusNotBuilt,
+					FailedDependencyChain: failedDependencyChain,
+				})
+				packageSkipped = true
+				break
 			}
 		}
 	}
-	if packagesNotInstalled {
-		log.Fatal("Dependency resolution failed for the above set of packages.")
+	return skippedPackages
+}
+
+// cancelPackagesWithCancelledDependencies marks as cancelled the packages which haven't been processed yet,
+// and which directly or transitively depend on any of the cancelledPackages (e.g. packages whose download
+// has been cancelled). Cancelled packages are added to cancelledPackages and installedPackages.
+// Returns the installation results of cancelled packages.
+func cancelPackagesWithCancelledDependencies(
+	dependencies map[string][]string,
+	downloadedPackages map[string]DownloadedPackage,
+	installedPackages *[]string,
+	cancelledPackages map[string]bool,
+) []InstallResultInfo {
+	var cancelledResults []InstallResultInfo
+	// Repeat until no more packages are cancelled, so that transitive dependents are cancelled as well.
+	for packageCancelled := true; packageCancelled; {
+		packageCancelled = false
+		for packageName, packageDeps := range dependencies {
+			if stringInSlice(packageName, *installedPackages) {
+				continue
+			}
+			for _, d := range packageDeps {
+				if !cancelledPackages[d] {
+					continue
+				}
+				log.Warn("Cancelling installation of ", packageName, " because processing of ", d,
+					" has been cancelled.")
+				cancelledPackages[packageName] = true
+				*installedPackages = append(*installedPackages, packageName)
+				cancelledResults = append(cancelledResults, InstallResultInfo{
+					PackageName:    packageName,
+					InputLocation:  downloadedPackages[packageName].Location,
+					PackageType:    downloadedPackages[packageName].PackageType,
+					PackageVersion: downloadedPackages[packageName].PackageVersion,
+					Status:         InstallResultInfoStatusCancelled,
+					BuildStatus:    buildStatusNotBuilt,
+				})
+				packageCancelled = true
+				break
+			}
+		}
 	}
+	return cancelledResults
+}
+
+// isPackageInstalled checks whether the package in a given version is present in the library.
+func isPackageInstalled(packageName string, packageVersion string, libPath string) bool {
+	descFilePath := filepath.Join(libPath, packageName, "DESCRIPTION")
+	if _, err := os.Stat(descFilePath); err != nil {
+		return false
+	}
+	return parseDescriptionFile(descFilePath)["Version"] == packageVersion
+}
+
+// getReverseDependencies returns the set of packages which transitively depend on any of the packages.
+// The packages themselves are included in the returned set.
+func getReverseDependencies(packages map[string]bool, dependencies map[string][]string) map[string]bool {
+	reverseDependencies := make(map[string][]string)
+	for packageName, packageDeps := range dependencies {
+		for _, d := range packageDeps {
+			reverseDependencies[d] = append(reverseDependencies[d], packageName)
+		}
+	}
+	result := make(map[string]bool)
+	var queue []string
+	for p := range packages {
+		result[p] = true
+		queue = append(queue, p)
+	}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		for _, r := range reverseDependencies[p] {
+			if !result[r] {
+				result[r] = true
+				queue = append(queue, r)
+			}
+		}
+	}
+	return result
+}
+
+// getReusableInstallResults returns the previous installation results of packages which don't have to be
+// reinstalled. These are packages which have been successfully installed in the previous run, are still
+// present in libPath, whose renv.lock entries haven't changed and none of whose dependencies
+// have to be reinstalled.
+func getReusableInstallResults(
+	renvLockPackages map[string]Rpackage,
+	previousInstallInfo []InstallResultInfo,
+	dependencies map[string][]string,
+	libPath string,
+) map[string]InstallResultInfo {
+	previousResults := make(map[string]InstallResultInfo)
+	for _, p := range previousInstallInfo {
+		previousResults[p.PackageName] = p
+	}
+	changedPackages := make(map[string]bool)
+	for packageName := range dependencies {
+		previous, ok := previousResults[packageName]
+		lockfileEntry := renvLockPackages[packageName]
+		switch {
+		case !ok:
+			log.Debug("Package ", packageName, " hasn't been installed in the previous run.")
+			changedPackages[packageName] = true
+		case previous.Status != InstallResultInfoStatusSucceeded:
+			log.Debug("Installation of ", packageName, " failed in the previous run.")
+			changedPackages[packageName] = true
+		case previous.LockfileVersion != lockfileEntry.Version || previous.RemoteSha != lockfileEntry.RemoteSha ||
+			previous.Hash != lockfileEntry.Hash:
+			log.Debug("renv.lock entry for package ", packageName, " changed since the previous run.")
+			changedPackages[packageName] = true
+		case !isPackageInstalled(packageName, previous.PackageVersion, libPath):
+			log.Debug("Package ", packageName, " is no longer installed in ", libPath, ".")
+			changedPackages[packageName] = true
+		}
+	}
+	packagesToReinstall := getReverseDependencies(changedPackages, dependencies)
+	reusableResults := make(map[string]InstallResultInfo)
+	for packageName := range dependencies {
+		if !packagesToReinstall[packageName] {
+			reusableResult := previousResults[packageName]
+			reusableResult.Reused = true
+			reusableResults[packageName] = reusableResult
+		}
+	}
+	return reusableResults
 }
 
 // installPackages concurrently builds and installs packages specified in the renv.lock.
 // The installation is executed in order resulting from the way packages depend on each other.
+// Results of packages from previousInstallInfo which don't have to be reinstalled are reused.
+// Out of packages ready to be installed, the packages are chosen according to schedulingStrategy,
+// based on installation times from the previous run given by previousInstallTimes.
 func installPackages(
+	ctx context.Context,
 	renvLock Renvlock,
 	allDownloadInfo *[]DownloadInfo,
 	allInstallInfo *[]InstallResultInfo,
 	additionalBuildOptions string,
 	additionalInstallOptions string,
 	erroneousRepositoryNames []string,
+	previousInstallInfo []InstallResultInfo,
+	previousInstallTimes map[string]int,
 ) {
 	err := os.MkdirAll(temporaryLibPath, os.ModePerm)
 	checkError(err)
@@ -367,26 +655,89 @@ func installPackages(
 	checkError(err)
 
 	downloadedPackages := make(map[string]DownloadedPackage)
+	// Packages whose download has been cancelled.
+	cancelledPackages := make(map[string]bool)
 	for _, v := range *allDownloadInfo {
 		downloadedPackages[v.PackageName] = DownloadedPackage{
 			v.DownloadedPackageType, v.PackageVersion, v.PackageRepository, v.OutputLocation,
 		}
+		if v.StatusCode == downloadStatusCancelled {
+			cancelledPackages[v.PackageName] = true
+		}
 	}
 
-	dependencies := getPackageDeps(renvLock.Packages, renvLock.R.Repositories,
-		downloadedPackages, erroneousRepositoryNames)
+	dependencies, softDependencies, missingSuggests := getPackageDeps(renvLock.Packages,
+		getRenvRepositories(renvLock), downloadedPackages, erroneousRepositoryNames)
+	dependencies = breakDependencyCycles(dependencies, softDependencies)
+
+	log.Info("Scheduling installations with ", schedulingStrategy, " strategy.")
+	priorities := getInstallationPriorities(schedulingStrategy, dependencies, previousInstallTimes)
 
 	var installedPackages []string
 	readyPackages := make(map[string]bool)
 	packagesBeingInstalled := make(map[string]bool)
+	installationStartTimes := make(map[string]time.Time)
 	installationResultChan := make(chan InstallResultInfo)
 
-	// Compute the initial list of ready packages (those having no dependencies at all).
-	getPackagesReadyToInstall(dependencies, installedPackages, packagesBeingInstalled, readyPackages)
-
 	packagesInstalledSuccessfully := 0
 	packagesInstalledUnsuccessfully := 0
 
+	if len(previousInstallInfo) > 0 {
+		reusableResults := getReusableInstallResults(renvLock.Packages, previousInstallInfo,
+			dependencies, temporaryLibPath)
+		for packageName, result := range reusableResults {
+			log.Debug("Reusing installation of ", packageName, " from the previous run.")
+			*allInstallInfo = append(*allInstallInfo, result)
+			installedPackages = append(installedPackages, packageName)
+			packagesInstalledSuccessfully++
+		}
+		log.Info("Reusing installation results of ", len(reusableResults), " packages from the previous run.")
+	}
+
+	// Packages which failed to download can't be installed, and neither can the packages depending on them.
+	// The dependency graph is checked before scheduling any installations. Packages depending on the packages
+	// whose download has been cancelled are reported as cancelled rather than skipped.
+	failedPackages := make(map[string][]string)
+	for _, packageName := range getUninstallablePackages(dependencies, downloadedPackages, installedPackages) {
+		installedPackages = append(installedPackages, packageName)
+		if cancelledPackages[packageName] {
+			log.Warn("Package ", packageName, " can't be installed because its download has been cancelled.")
+			continue
+		}
+		log.Warn("Package ", packageName, " can't be installed because it hasn't been downloaded properly.")
+		failedPackages[packageName] = []string{packageName}
+	}
+	*allInstallInfo = append(*allInstallInfo, cancelPackagesWithCancelledDependencies(dependencies,
+		downloadedPackages, &installedPackages, cancelledPackages)...)
+	// Packages which are part of a dependency cycle can't be installed either, because none of them
+	// can be installed before the others. Soft dependencies (Suggests) closing a cycle
+	// have already been dropped by breakDependencyCycles, so only the cycles consisting of Depends,
+	// Imports and LinkingTo dependencies remain - renv.lock or DESCRIPTION files have to be fixed in such case.
+	for packageName, dependencyCycle := range getDependencyCycles(dependencies) {
+		if stringInSlice(packageName, installedPackages) {
+			continue
+		}
+		*allInstallInfo = append(*allInstallInfo, InstallResultInfo{
+			PackageName:     packageName,
+			InputLocation:   downloadedPackages[packageName].Location,
+			PackageType:     downloadedPackages[packageName].PackageType,
+			PackageVersion:  downloadedPackages[packageName].PackageVersion,
+			Status:          InstallResultInfoStatusDependencyCycle,
+			BuildStatus:     buildStatusNotBuilt,
+			DependencyCycle: dependencyCycle,
+		})
+		packagesInstalledUnsuccessfully++
+		failedPackages[packageName] = []string{packageName}
+		installedPackages = append(installedPackages, packageName)
+	}
+	skippedPackages := skipPackagesWithFailedDependencies(dependencies, downloadedPackages,
+		&installedPackages, packagesBeingInstalled, failedPackages)
+	packagesInstalledUnsuccessfully += len(skippedPackages)
+	*allInstallInfo = append(*allInstallInfo, skippedPackages...)
+
+	// Compute the initial list of ready packages (those having no dependencies at all).
+	getPackagesReadyToInstall(dependencies, installedPackages, packagesBeingInstalled, readyPackages)
+
 package_installation_loop:
 	for {
 		select {
@@ -395,6 +746,11 @@ package_installation_loop:
 			receivedPackageName := msg.PackageName
 			receivedStatus := msg.Status
 			log.Info("Installation of ", receivedPackageName, " completed, status = ", receivedStatus, ".")
+			lockfileEntry := renvLock.Packages[receivedPackageName]
+			msg.LockfileVersion = lockfileEntry.Version
+			msg.RemoteSha = lockfileEntry.RemoteSha
+			msg.Hash = lockfileEntry.Hash
+			msg.InstallTime = int(time.Since(installationStartTimes[receivedPackageName]).Seconds())
 			*allInstallInfo = append(*allInstallInfo, msg)
 
 			if receivedStatus == InstallResultInfoStatusSucceeded {
@@ -407,6 +763,16 @@ package_installation_loop:
 			installedPackages = append(installedPackages, receivedPackageName)
 			packagesBeingInstalled[receivedPackageName] = false
 
+			// Packages depending on the failed one are not installed at all.
+			// If the installation has been cancelled, its dependents are marked as cancelled later on.
+			if receivedStatus != InstallResultInfoStatusSucceeded && receivedStatus != InstallResultInfoStatusCancelled {
+				failedPackages[receivedPackageName] = []string{receivedPackageName}
+				skippedPackages := skipPackagesWithFailedDependencies(dependencies, downloadedPackages,
+					&installedPackages, packagesBeingInstalled, failedPackages)
+				packagesInstalledUnsuccessfully += len(skippedPackages)
+				*allInstallInfo = append(*allInstallInfo, skippedPackages...)
+			}
+
 			// Recalculate the list of packages ready to be installed.
 			getPackagesReadyToInstall(dependencies, installedPackages, packagesBeingInstalled, readyPackages)
 
@@ -419,20 +785,54 @@ package_installation_loop:
 			)
 		// Try to run a new package installation.
 		default:
+			if isCancelled(ctx) {
+				// Wait until the ongoing installations are killed, and mark all remaining packages as cancelled.
+				if mapTrueLength(packagesBeingInstalled) > 0 {
+					time.Sleep(500 * time.Millisecond)
+					continue
+				}
+				for packageName := range dependencies {
+					if !stringInSlice(packageName, installedPackages) {
+						*allInstallInfo = append(*allInstallInfo, InstallResultInfo{
+							PackageName:    packageName,
+							InputLocation:  downloadedPackages[packageName].Location,
+							PackageType:    downloadedPackages[packageName].PackageType,
+							PackageVersion: downloadedPackages[packageName].PackageVersion,
+							Status:         InstallResultInfoStatusCancelled,
+							BuildStatus:    buildStatusNotBuilt,
+						})
+					}
+				}
+				log.Warn("Installation cancelled.")
+				break package_installation_loop
+			}
 			if mapTrueLength(readyPackages)+mapTrueLength(packagesBeingInstalled) == 0 {
 				// No ready packages and no ongoing installations - all packages (hopefully) installed or failed to install.
 				// Check whether indeed installation of all downloaded packages was at least attempted.
-				getPackagesNotInstalled(dependencies, installedPackages)
+				// Packages which weren't are reported as skipped, so that the remaining stages can proceed.
+				packagesNotInstalled := getPackagesNotInstalled(dependencies, installedPackages)
+				for packageName := range packagesNotInstalled {
+					*allInstallInfo = append(*allInstallInfo, InstallResultInfo{
+						PackageName:           packageName,
+						InputLocation:         downloadedPackages[packageName].Location,
+						PackageType:           downloadedPackages[packageName].PackageType,
+						PackageVersion:        downloadedPackages[packageName].PackageVersion,
+						Status:                InstallResultInfoStatusSkipped,
+						BuildStatus:           buildStatusNotBuilt,
+						FailedDependencyChain: getNotInstalledDependencyChain(packageName, packagesNotInstalled),
+					})
+				}
 				break package_installation_loop
 			}
 			if mapTrueLength(packagesBeingInstalled) < numberOfWorkers {
 				// The number of ongoing package installations less that maximum desired
 				// number of installation processes.
-				packageName := getPackageToInstall(packagesBeingInstalled, readyPackages)
+				packageName := getPackageToInstall(packagesBeingInstalled, readyPackages, priorities)
 				if packageName != "" {
 					// Run a new package installation.
 					log.Info("Installing ", packageName, "...")
-					go installSinglePackage(installationResultChan, packageName,
+					installationStartTimes[packageName] = time.Now()
+					go installSinglePackage(ctx, installationResultChan, packageName,
 						downloadedPackages[packageName].PackageType,
 						downloadedPackages[packageName].Location,
 						additionalBuildOptions, additionalInstallOptions)
@@ -447,7 +847,11 @@ package_installation_loop:
 		}
 	}
 
-	installResultFilePath := filepath.Join(tempCacheDirectory, "installResultInfo.json")
+	for i := range *allInstallInfo {
+		(*allInstallInfo)[i].MissingSuggests = missingSuggests[(*allInstallInfo)[i].PackageName]
+	}
+
+	installResultFilePath := filepath.Join(tempCacheDirectory, installInfoFileName)
 	writeJSON(installResultFilePath, *allInstallInfo)
 	log.Info("Installation of ", len(*allInstallInfo), " packages completed.")
 }
@@ -16,7 +16,10 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"os"
+	"path/filepath"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -24,17 +27,17 @@ import (
 
 func Test_executeInstallation(t *testing.T) {
 	t.Skip("skipping integration test")
-	_, err := executeInstallation("/testdata/BiocBaseUtils", "BiocBaseUtils", "test.out", "build-test.out", "tar.gz", "--no-manual", "--no-docs")
+	_, err := executeInstallation(context.Background(), "/testdata/BiocBaseUtils", "BiocBaseUtils", "test.out", "build-test.out", "tar.gz", "--no-manual", "--no-docs")
 	assert.NoError(t, err)
 }
 
 func Test_executeInstallation_with_wrong_logFilePath(t *testing.T) {
-	_, err := executeInstallation("/testdata/BiocBaseUtils", "BiocBaseUtils", "", "", "tar.gz", "--no-manual", "--no-docs")
+	_, err := executeInstallation(context.Background(), "/testdata/BiocBaseUtils", "BiocBaseUtils", "", "", "tar.gz", "--no-manual", "--no-docs")
 	assert.Error(t, err)
 }
 
 func Test_executeInstallation_with_wrong_path_to_package(t *testing.T) {
-	_, err := executeInstallation("", "BiocBaseUtils", "test.out", "build-test.out", "tar.gz", "--no-manual", "--no-docs")
+	_, err := executeInstallation(context.Background(), "", "BiocBaseUtils", "test.out", "build-test.out", "tar.gz", "--no-manual", "--no-docs")
 	assert.Error(t, err)
 }
 
@@ -42,9 +45,7 @@ func Test_executeInstallationFromTargz(t *testing.T) {
 	err := os.MkdirAll("testdata/targz", os.ModePerm)
 	checkError(err)
 	rExecutable = "R"
-	temporaryLibPath = "/tmp/scribe/installed_packages"
-	rLibsPaths = "/tmp/scribe/installed_packages:/usr/local/lib/R/site-library:/usr/lib/R/site-library:/usr/lib/R/library"
-	localOutputDirectory = defaultDownloadDirectory
+	setWorkDirectoryPaths("/tmp/scribe", "", "")
 	downloadFile(
 		"https://cran.r-project.org/src/contrib/Archive/bitops/bitops_1.0-6.tar.gz",
 		"testdata/targz/bitops_1.0-6.tar.gz",
@@ -63,7 +64,7 @@ func Test_executeInstallationFromTargz(t *testing.T) {
 		{"testdata/targz/tripack_1.3-9.tar.gz", "tripack"},
 	}
 	for _, v := range cases {
-		_, err := executeInstallation(v.targz, v.packageName, v.packageName+".out", "build-"+v.packageName+".out", "tar.gz", "--no-manual", "--no-docs")
+		_, err := executeInstallation(context.Background(), v.targz, v.packageName, v.packageName+".out", "build-"+v.packageName+".out", "tar.gz", "--no-manual", "--no-docs")
 		assert.NoError(t, err)
 	}
 }
@@ -115,12 +116,20 @@ func Test_getPackageToInstall(t *testing.T) {
 	readyPackages["package4"] = false
 	readyPackages["package5"] = false
 	readyPackages["package6"] = true
-	packageName := getPackageToInstall(packagesBeingInstalled, readyPackages)
+	packageName := getPackageToInstall(packagesBeingInstalled, readyPackages, nil)
 	assert.Equal(t, packageName, "package6")
 	assert.True(t, packagesBeingInstalled["package6"])
 	assert.False(t, readyPackages["package6"])
-	packageName = getPackageToInstall(packagesBeingInstalled, readyPackages)
+	packageName = getPackageToInstall(packagesBeingInstalled, readyPackages, nil)
 	assert.Equal(t, packageName, "")
+	readyPackages["package7"] = true
+	readyPackages["package8"] = true
+	readyPackages["package9"] = true
+	priorities := map[string]int{"package7": 2, "package8": 5, "package9": 5}
+	assert.Equal(t, getPackageToInstall(packagesBeingInstalled, readyPackages, priorities), "package8")
+	assert.Equal(t, getPackageToInstall(packagesBeingInstalled, readyPackages, priorities), "package9")
+	assert.Equal(t, getPackageToInstall(packagesBeingInstalled, readyPackages, priorities), "package7")
+	assert.Equal(t, getPackageToInstall(packagesBeingInstalled, readyPackages, priorities), "")
 }
 
 func Test_getPackagesReadyToInstall(t *testing.T) {
@@ -143,3 +152,160 @@ func Test_getPackagesReadyToInstall(t *testing.T) {
 	_, ok = readyPackages["package1"]
 	assert.False(t, ok)
 }
+
+func Test_getReverseDependencies(t *testing.T) {
+	dependencies := make(map[string][]string)
+	dependencies["package1"] = []string{"package2", "package3"}
+	dependencies["package2"] = []string{"package4"}
+	dependencies["package3"] = []string{}
+	dependencies["package4"] = []string{}
+	dependencies["package5"] = []string{"package3"}
+	assert.Equal(t, getReverseDependencies(map[string]bool{"package4": true}, dependencies),
+		map[string]bool{"package4": true, "package2": true, "package1": true})
+	assert.Equal(t, getReverseDependencies(map[string]bool{"package3": true}, dependencies),
+		map[string]bool{"package3": true, "package1": true, "package5": true})
+}
+
+func Test_getReusableInstallResults(t *testing.T) {
+	libPath := t.TempDir()
+	for _, p := range []struct{ name, version string }{
+		{"package1", "1.0.0"}, {"package2", "2.0.0"}, {"package3", "3.0.0"}, {"package4", "4.0.0"},
+	} {
+		err := os.MkdirAll(filepath.Join(libPath, p.name), os.ModePerm)
+		checkError(err)
+		err = os.WriteFile(filepath.Join(libPath, p.name, "DESCRIPTION"),
+			[]byte("Package: "+p.name+"\nVersion: "+p.version+"\n"), 0600)
+		checkError(err)
+	}
+	renvLockPackages := make(map[string]Rpackage)
+	renvLockPackages["package1"] = Rpackage{Package: "package1", Version: "1.0.0", Hash: "aaa"}
+	// package2 has been changed in renv.lock.
+	renvLockPackages["package2"] = Rpackage{Package: "package2", Version: "2.0.1", Hash: "bbb"}
+	renvLockPackages["package3"] = Rpackage{Package: "package3", Version: "3.0.0", RemoteSha: "ccc"}
+	renvLockPackages["package4"] = Rpackage{Package: "package4", Version: "4.0.0", Hash: "ddd"}
+	renvLockPackages["package5"] = Rpackage{Package: "package5", Version: "5.0.0", Hash: "eee"}
+	previousInstallInfo := []InstallResultInfo{
+		{PackageName: "package1", PackageVersion: "1.0.0", Status: InstallResultInfoStatusSucceeded,
+			LockfileVersion: "1.0.0", Hash: "aaa"},
+		{PackageName: "package2", PackageVersion: "2.0.0", Status: InstallResultInfoStatusSucceeded,
+			LockfileVersion: "2.0.0", Hash: "bbb"},
+		{PackageName: "package3", PackageVersion: "3.0.0", Status: InstallResultInfoStatusSucceeded,
+			LockfileVersion: "3.0.0", RemoteSha: "ccc"},
+		{PackageName: "package4", PackageVersion: "4.0.0", Status: InstallResultInfoStatusFailed,
+			LockfileVersion: "4.0.0", Hash: "ddd"},
+		// package5 is not installed in libPath.
+		{PackageName: "package5", PackageVersion: "5.0.0", Status: InstallResultInfoStatusSucceeded,
+			LockfileVersion: "5.0.0", Hash: "eee"},
+	}
+	dependencies := make(map[string][]string)
+	dependencies["package1"] = []string{"package3"}
+	dependencies["package2"] = []string{}
+	dependencies["package3"] = []string{}
+	dependencies["package4"] = []string{}
+	dependencies["package5"] = []string{}
+	dependencies["package6"] = []string{"package2"}
+	reusableResults := getReusableInstallResults(renvLockPackages, previousInstallInfo, dependencies, libPath)
+	assert.Equal(t, len(reusableResults), 2)
+	assert.True(t, reusableResults["package1"].Reused)
+	assert.True(t, reusableResults["package3"].Reused)
+}
+
+func Test_getUninstallablePackages(t *testing.T) {
+	dependencies := make(map[string][]string)
+	dependencies["package1"] = []string{"package2", "package3"}
+	dependencies["package2"] = []string{}
+	dependencies["package3"] = []string{"package4"}
+	dependencies["package5"] = []string{}
+	downloadedPackages := make(map[string]DownloadedPackage)
+	downloadedPackages["package1"] = DownloadedPackage{"tar.gz", "1.0.0", "CRAN", "/tmp/package1.tar.gz"}
+	downloadedPackages["package2"] = DownloadedPackage{"tar.gz", "2.0.0", "CRAN", ""}
+	downloadedPackages["package3"] = DownloadedPackage{"tar.gz", "3.0.0", "CRAN", "/tmp/package3.tar.gz"}
+	downloadedPackages["package4"] = DownloadedPackage{"git", "4.0.0", "GitHub", ""}
+	downloadedPackages["package5"] = DownloadedPackage{"tar.gz", "5.0.0", "CRAN", ""}
+	assert.Equal(t, getUninstallablePackages(dependencies, downloadedPackages, []string{"package5"}),
+		[]string{"package2", "package4"})
+}
+
+func Test_skipPackagesWithFailedDependencies(t *testing.T) {
+	dependencies := make(map[string][]string)
+	dependencies["package1"] = []string{"package2"}
+	dependencies["package2"] = []string{"package3"}
+	dependencies["package3"] = []string{}
+	dependencies["package4"] = []string{"package5"}
+	dependencies["package5"] = []string{}
+	dependencies["package6"] = []string{"package3"}
+	downloadedPackages := make(map[string]DownloadedPackage)
+	downloadedPackages["package1"] = DownloadedPackage{"tar.gz", "1.0.0", "CRAN", "/tmp/package1.tar.gz"}
+	installedPackages := []string{"package3", "package5"}
+	packagesBeingInstalled := map[string]bool{"package6": true}
+	failedPackages := map[string][]string{"package3": {"package3"}}
+	skippedPackages := skipPackagesWithFailedDependencies(dependencies, downloadedPackages,
+		&installedPackages, packagesBeingInstalled, failedPackages)
+	skippedChains := make(map[string][]string)
+	for _, p := range skippedPackages {
+		assert.Equal(t, p.Status, InstallResultInfoStatusSkipped)
+		assert.Equal(t, p.BuildStatus, buildStatusNotBuilt)
+		skippedChains[p.PackageName] = p.FailedDependencyChain
+	}
+	assert.Equal(t, skippedChains, map[string][]string{
+		"package1": {"package2", "package3"},
+		"package2": {"package3"},
+	})
+	assert.True(t, stringInSlice("package1", installedPackages))
+	assert.True(t, stringInSlice("package2", installedPackages))
+	assert.False(t, stringInSlice("package4", installedPackages))
+	assert.Equal(t, failedPackages["package1"], []string{"package1", "package2", "package3"})
+}
+
+func Test_cancelPackagesWithCancelledDependencies(t *testing.T) {
+	dependencies := make(map[string][]string)
+	dependencies["package1"] = []string{"package2"}
+	dependencies["package2"] = []string{"package3"}
+	dependencies["package4"] = []string{"package5"}
+	dependencies["package5"] = []string{}
+	downloadedPackages := make(map[string]DownloadedPackage)
+	downloadedPackages["package1"] = DownloadedPackage{"tar.gz", "1.0.0", "CRAN", "/tmp/package1.tar.gz"}
+	// Download of package3 has been cancelled.
+	installedPackages := []string{"package3"}
+	cancelledPackages := map[string]bool{"package3": true}
+	cancelledResults := cancelPackagesWithCancelledDependencies(dependencies, downloadedPackages,
+		&installedPackages, cancelledPackages)
+	var cancelledPackageNames []string
+	for _, p := range cancelledResults {
+		assert.Equal(t, p.Status, InstallResultInfoStatusCancelled)
+		assert.Equal(t, p.BuildStatus, buildStatusNotBuilt)
+		cancelledPackageNames = append(cancelledPackageNames, p.PackageName)
+	}
+	sort.Strings(cancelledPackageNames)
+	assert.Equal(t, cancelledPackageNames, []string{"package1", "package2"})
+	assert.True(t, stringInSlice("package1", installedPackages))
+	assert.False(t, stringInSlice("package4", installedPackages))
+}
+
+func Test_getPackagesNotInstalled(t *testing.T) {
+	dependencies := make(map[string][]string)
+	dependencies["package1"] = []string{"package2"}
+	dependencies["package2"] = []string{"package1"}
+	dependencies["package3"] = []string{}
+	assert.Equal(t, getPackagesNotInstalled(dependencies, []string{"package3"}), map[string][]string{
+		"package1": {"package2"},
+		"package2": {"package1"},
+	})
+	assert.Equal(t, len(getPackagesNotInstalled(dependencies, []string{"package1", "package2", "package3"})), 0)
+}
+
+func Test_getNotInstalledDependencyChain(t *testing.T) {
+	packagesNotInstalled := map[string][]string{
+		"package1": {"package2"},
+		"package2": {"package3", "package4"},
+		"package3": {"package5"},
+		"package6": {"package7"},
+		"package7": {"package6"},
+	}
+	assert.Equal(t, getNotInstalledDependencyChain("package1", packagesNotInstalled),
+		[]string{"package2", "package3", "package5"})
+	assert.Equal(t, getNotInstalledDependencyChain("package3", packagesNotInstalled), []string{"package5"})
+	assert.Equal(t, getNotInstalledDependencyChain("package6", packagesNotInstalled),
+		[]string{"package7", "package6"})
+	assert.Equal(t, len(getNotInstalledDependencyChain("package5", packagesNotInstalled)), 0)
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/md5" // #nosec
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// Download status of packages whose downloaded archive doesn't match the checksum from
+// the PACKAGES file or Hash from renv.lock, or whose cloned git repository doesn't match RemoteSha from renv.lock.
+const downloadStatusIntegrityError = -7
+
+// Fields of the DESCRIPTION file from which renv computes the Hash of the package, in the order used by renv.
+var renvHashFields = []string{"Package", "Version", "Title", "Author", "Maintainer", "Description",
+	"Depends", "Imports", "Suggests", "LinkingTo"}
+
+// Maximum size of the DESCRIPTION file read from the package archive.
+const maxDescriptionFileSize = 1 << 20
+
+// getFileMD5 returns MD5 checksum of the file contents, or empty string if the file can't be read.
+func getFileMD5(filePath string) string {
+	file, err := os.Open(filePath)
+	checkError(err)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	hash := md5.New() // #nosec
+	_, err = io.Copy(hash, file)
+	checkError(err)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// getExpectedChecksum returns the MD5 checksum which the package archive downloaded from packageURL
+// should have, according to the PACKAGES file of the repository. Returns empty string if the checksum
+// is unknown, e.g. because the archive is not the current package version in the repository.
+func getExpectedChecksum(packageName string, packageURL string, repoURL string,
+	repositoryPackageInfo map[string]map[string]*PackageInfo, biocPackageInfo map[string]map[string]*PackageInfo,
+	biocUrls map[string]string) string {
+	if packageInfo, ok := repositoryPackageInfo[repoURL][packageName]; ok &&
+		packageURL == repoURL+srcContrib+packageName+"_"+packageInfo.Version+tarGzExtension {
+		return packageInfo.Checksum
+	}
+	for _, biocCategory := range bioconductorCategories {
+		if packageInfo, ok := biocPackageInfo[biocCategory][packageName]; ok &&
+			packageURL == biocUrls[biocCategory]+"/"+packageName+"_"+packageInfo.Version+tarGzExtension {
+			return packageInfo.Checksum
+		}
+	}
+	return ""
+}
+
+// verifyChecksum checks whether the file has the expected MD5 checksum. Returns an error message
+// (empty if the checksum matches or the expected checksum is unknown).
+func verifyChecksum(filePath string, expectedChecksum string) string {
+	if expectedChecksum == "" {
+		return ""
+	}
+	checksum := getFileMD5(filePath)
+	if checksum != expectedChecksum {
+		return "MD5 checksum of " + filePath + " is " + checksum + " instead of " + expectedChecksum +
+			" expected by the PACKAGES file."
+	}
+	return ""
+}
+
+// getVerifiableRenvHash returns Hash from the renv.lock entry, if it can be verified against the DESCRIPTION
+// file of the package archive. That's the case for packages from package repositories, for which renv computes
+// the Hash only from renvHashFields. For other packages (e.g. with RemoteSha being a commit SHA, as for packages
+// from r-universe), renv includes Remote fields added to the DESCRIPTION file during the installation,
+// which are not present in the archive. Returns empty string for such packages.
+func getVerifiableRenvHash(rPackage Rpackage) string {
+	if rPackage.Source != "Repository" && rPackage.Source != "Bioconductor" {
+		return ""
+	}
+	switch strings.ToLower(rPackage.RemoteType) {
+	case "", "standard", "repository", "cran", "bioconductor":
+	default:
+		return ""
+	}
+	if len(rPackage.RemoteSha) >= 40 {
+		return ""
+	}
+	return rPackage.Hash
+}
+
+// parseDescriptionFields returns the map of fields from the contents of the DESCRIPTION file.
+// As with read.dcf in R, leading and trailing whitespace is removed from each line of the value,
+// and continuation lines are joined with newlines.
+func parseDescriptionFields(description string) map[string]string {
+	fields := make(map[string]string)
+	var fieldName string
+	for _, line := range strings.Split(strings.ReplaceAll(description, "\r\n", "\n"), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if fieldName != "" {
+				fields[fieldName] += "\n" + strings.TrimSpace(line)
+			}
+			continue
+		}
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		fieldName = strings.TrimSpace(name)
+		fields[fieldName] = strings.TrimSpace(value)
+	}
+	for fieldName, value := range fields {
+		fields[fieldName] = strings.TrimSpace(value)
+	}
+	return fields
+}
+
+// getRenvHash computes the Hash of the package in the same way as renv, i.e. as the MD5 checksum
+// of renvHashFields from the contents of the DESCRIPTION file, written as "Field: value" lines.
+func getRenvHash(description string) string {
+	fields := parseDescriptionFields(description)
+	var lines []string
+	for _, fieldName := range renvHashFields {
+		if value, ok := fields[fieldName]; ok {
+			lines = append(lines, fieldName+": "+value)
+		}
+	}
+	hash := md5.Sum([]byte(strings.Join(lines, "\n") + "\n")) // #nosec
+	return hex.EncodeToString(hash[:])
+}
+
+// getArchiveDescription returns the contents of the DESCRIPTION file from the package archive.
+func getArchiveDescription(filePath string, packageName string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return "", err
+	}
+	defer gzipReader.Close()
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err != nil {
+			return "", errors.New("DESCRIPTION file not found")
+		}
+		if header.Name == packageName+"/DESCRIPTION" {
+			contents, err := io.ReadAll(io.LimitReader(tarReader, maxDescriptionFileSize))
+			return string(contents), err
+		}
+	}
+}
+
+// verifyRenvHash checks whether the package archive has the expected Hash from renv.lock.
+// Returns an error message (empty if the Hash matches or the expected Hash is unknown).
+func verifyRenvHash(filePath string, packageName string, expectedHash string) string {
+	if expectedHash == "" {
+		return ""
+	}
+	description, err := getArchiveDescription(filePath, packageName)
+	if err != nil {
+		return "Hash of " + filePath + " couldn't be computed: " + err.Error() + "."
+	}
+	hash := getRenvHash(description)
+	if hash != expectedHash {
+		return "Hash of " + filePath + " is " + hash + " instead of " + expectedHash + " expected by renv.lock."
+	}
+	return ""
+}
+
+// verifyDownloadedArchive checks whether the package archive matches the MD5 checksum from the PACKAGES
+// file (expectedChecksum), and Hash from renv.lock (expectedHash). Hash is verified only if the archive
+// is the package version requested by renv.lock (requestedVersion is true). Returns an error message
+// (empty if the archive matches or there is nothing to verify it against). If there is nothing to verify
+// the archive against, a warning is shown in case strictIntegrity is set.
+func verifyDownloadedArchive(filePath string, packageName string, expectedChecksum string, expectedHash string,
+	requestedVersion bool) string {
+	if !requestedVersion {
+		expectedHash = ""
+	}
+	if expectedChecksum == "" && expectedHash == "" && strictIntegrity {
+		log.Warn("Integrity of ", filePath, " can't be verified, because neither the PACKAGES file ",
+			"nor renv.lock provide a checksum of ", packageName, " in this version.")
+	}
+	integrityError := verifyChecksum(filePath, expectedChecksum)
+	if integrityError == "" {
+		integrityError = verifyRenvHash(filePath, packageName, expectedHash)
+	}
+	return integrityError
+}
+
+// verifyGitSha checks whether the commit checked out in the cloned repository matches RemoteSha
+// from renv.lock. RemoteSha may be an abbreviated SHA. Returns an error message (empty if the
+// SHA matches or RemoteSha hasn't been provided).
+func verifyGitSha(repoURL string, expectedSha string, checkedOutSha string) string {
+	if expectedSha == "" || (checkedOutSha != "" && strings.HasPrefix(checkedOutSha, expectedSha)) {
+		return ""
+	}
+	return "Commit " + checkedOutSha + " checked out in " + repoURL + " instead of " + expectedSha +
+		" expected by renv.lock."
+}
+
+// getIntegrityErrors returns the list of error messages for packages which failed integrity verification.
+func getIntegrityErrors(allDownloadInfo []DownloadInfo) []string {
+	var integrityErrors []string
+	for _, p := range allDownloadInfo {
+		if p.StatusCode == downloadStatusIntegrityError {
+			integrityErrors = append(integrityErrors, p.PackageName+": "+p.Message)
+		}
+	}
+	return integrityErrors
+}
+
+// checkIntegrity terminates scribe if any package failed integrity verification and strictIntegrity is set.
+func checkIntegrity(allDownloadInfo []DownloadInfo) {
+	integrityErrors := getIntegrityErrors(allDownloadInfo)
+	if len(integrityErrors) > 0 && strictIntegrity {
+		log.Fatal("Integrity verification failed for the following packages:\n",
+			strings.Join(integrityErrors, "\n"))
+	}
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/md5" // #nosec
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Contents of the DESCRIPTION file used in the tests, and the Hash which renv computes for it.
+const testDescription = `Package: package1
+Type: Package
+Title: Test Package
+Version: 1.0.0
+Authors@R: person("First", "Last", email = "first.last@example.com", role = c("aut", "cre"))
+Author: First Last [aut, cre]
+Maintainer: First Last <first.last@example.com>
+Description: The first line of the description,
+    and the second line.
+Imports:
+    package2 (>= 1.0.0),
+    package3
+Suggests: testthat
+Packaged: 2024-01-01 00:00:00 UTC; user
+`
+
+// The lines hashed by renv.
+const testDescriptionHashedFields = `Package: package1
+Version: 1.0.0
+Title: Test Package
+Author: First Last [aut, cre]
+Maintainer: First Last <first.last@example.com>
+Description: The first line of the description,
+and the second line.
+Imports: package2 (>= 1.0.0),
+package3
+Suggests: testthat
+`
+
+// writeTestDescriptionArchive writes tar.gz file containing the DESCRIPTION file of the package.
+func writeTestDescriptionArchive(t *testing.T, filePath string, packageName string, description string) {
+	file, err := os.Create(filePath)
+	assert.NoError(t, err)
+	defer file.Close()
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+	err = tarWriter.WriteHeader(&tar.Header{Name: packageName + "/DESCRIPTION", Mode: 0600,
+		Typeflag: tar.TypeReg, Size: int64(len(description))})
+	assert.NoError(t, err)
+	_, err = tarWriter.Write([]byte(description))
+	assert.NoError(t, err)
+	assert.NoError(t, tarWriter.Close())
+	assert.NoError(t, gzipWriter.Close())
+}
+
+func Test_getFileMD5(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "file.txt")
+	err := os.WriteFile(filePath, []byte("hello"), 0600)
+	assert.NoError(t, err)
+	assert.Equal(t, getFileMD5(filePath), "5d41402abc4b2a76b9719d911017c592")
+	assert.Equal(t, getFileMD5(filepath.Join(t.TempDir(), "nonexistent.txt")), "")
+}
+
+func Test_getExpectedChecksum(t *testing.T) {
+	repositoryPackageInfo := map[string]map[string]*PackageInfo{defaultCranMirrorURL: {"package1": {"1.0.0", "aaa", ""}}}
+	biocPackageInfo := map[string]map[string]*PackageInfo{"bioc": {"package2": {"2.0.0", "bbb", ""}}}
+	biocUrls := make(map[string]string)
+	getBiocUrls("3.18", biocUrls)
+	assert.Equal(t, getExpectedChecksum("package1",
+		"https://cloud.r-project.org/src/contrib/package1_1.0.0.tar.gz", defaultCranMirrorURL,
+		repositoryPackageInfo, biocPackageInfo, biocUrls), "aaa")
+	// Archived package versions don't have checksums in PACKAGES file.
+	assert.Equal(t, getExpectedChecksum("package1",
+		"https://cloud.r-project.org/src/contrib/Archive/package1/package1_0.9.0.tar.gz", defaultCranMirrorURL,
+		repositoryPackageInfo, biocPackageInfo, biocUrls), "")
+	assert.Equal(t, getExpectedChecksum("package1",
+		"https://example.com/src/contrib/package1_1.0.0.tar.gz", "https://example.com",
+		repositoryPackageInfo, biocPackageInfo, biocUrls), "")
+	assert.Equal(t, getExpectedChecksum("package2",
+		"https://www.bioconductor.org/packages/3.18/bioc/src/contrib/package2_2.0.0.tar.gz", bioConductorURL,
+		repositoryPackageInfo, biocPackageInfo, biocUrls), "bbb")
+}
+
+func Test_verifyChecksum(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "package1_1.0.0.tar.gz")
+	err := os.WriteFile(filePath, []byte("hello"), 0600)
+	assert.NoError(t, err)
+	assert.Equal(t, verifyChecksum(filePath, "5d41402abc4b2a76b9719d911017c592"), "")
+	assert.Equal(t, verifyChecksum(filePath, ""), "")
+	assert.Equal(t, verifyChecksum(filePath, "aaa"), "MD5 checksum of "+filePath+
+		" is 5d41402abc4b2a76b9719d911017c592 instead of aaa expected by the PACKAGES file.")
+}
+
+func Test_verifyGitSha(t *testing.T) {
+	assert.Equal(t, verifyGitSha("https://github.com/a/b", "", "v0.0.1"), "")
+	assert.Equal(t, verifyGitSha("https://github.com/a/b", "aaabbb", "aaabbbccc"), "")
+	assert.Equal(t, verifyGitSha("https://github.com/a/b", "aaabbb", "cccddd"),
+		"Commit cccddd checked out in https://github.com/a/b instead of aaabbb expected by renv.lock.")
+}
+
+func Test_getIntegrityErrors(t *testing.T) {
+	assert.Equal(t, getIntegrityErrors([]DownloadInfo{
+		{StatusCode: 200, PackageName: "package1"},
+		{StatusCode: downloadStatusIntegrityError, PackageName: "package2", Message: "Checksum mismatch."},
+		{StatusCode: -4, PackageName: "package3"},
+	}), []string{"package2: Checksum mismatch."})
+	assert.Equal(t, len(getIntegrityErrors([]DownloadInfo{{StatusCode: 200, PackageName: "package1"}})), 0)
+}
+
+func Test_downloadSinglePackageIntegrityError(t *testing.T) {
+	previousOutputDirectory := localOutputDirectory
+	localOutputDirectory = t.TempDir()
+	defer func() { localOutputDirectory = previousOutputDirectory }()
+	err := os.MkdirAll(localOutputDirectory+archivesSubdirectory, os.ModePerm)
+	assert.NoError(t, err)
+	downloadFileFunction := func(_ string, outputFile string) (int, int64) {
+		err := os.WriteFile(outputFile, []byte("hello"), 0600)
+		assert.NoError(t, err)
+		return 200, 5
+	}
+	repositoryPackageInfo := map[string]map[string]*PackageInfo{defaultCranMirrorURL: {"package1": {"1.0.0", "aaa", ""}}}
+	messages := make(chan DownloadInfo, 1)
+	guard := make(chan struct{}, 1)
+	guard <- struct{}{}
+	downloadSinglePackage(context.Background(), "package1", "1.0.0", defaultCranMirrorURL, "", "",
+		"Repository", "CRAN", "", "", repositoryPackageInfo, nil, nil, map[string]*CacheInfo{},
+		downloadFileFunction, mockedCloneGitRepo, messages, guard)
+	msg := <-messages
+	assert.Equal(t, msg.StatusCode, downloadStatusIntegrityError)
+	assert.Equal(t, msg.OutputLocation, "")
+	// The corrupted file should be removed.
+	_, err = os.Stat(localOutputDirectory + archivesSubdirectory + "package1_1.0.0.tar.gz")
+	assert.True(t, os.IsNotExist(err))
+
+	// Git repository checked out at a different commit than requested.
+	gitCloneFunction := func(_ string, _ string, _ string, _ string, _ string) (string, int64, int64, string) {
+		return "", 1, 0, "cccddd"
+	}
+	guard <- struct{}{}
+	downloadSinglePackage(context.Background(), "package2", "1.0.0", "https://github.com/a/package2",
+		"aaabbb", "main", GitHub, "", "", "", repositoryPackageInfo, nil, nil, map[string]*CacheInfo{},
+		downloadFileFunction, gitCloneFunction, messages, guard)
+	msg = <-messages
+	assert.Equal(t, msg.StatusCode, downloadStatusIntegrityError)
+	assert.Equal(t, msg.GitPackageShaOrRef, "cccddd")
+}
+
+func Test_getRenvHash(t *testing.T) {
+	hash := md5.Sum([]byte(testDescriptionHashedFields)) // #nosec
+	assert.Equal(t, getRenvHash(testDescription), hex.EncodeToString(hash[:]))
+	// Fields not used by renv, and line endings don't affect the Hash.
+	assert.Equal(t, getRenvHash(strings.ReplaceAll(testDescription, "\n", "\r\n")+"Built: R 4.3.2\n"),
+		hex.EncodeToString(hash[:]))
+	assert.NotEqual(t, getRenvHash(strings.ReplaceAll(testDescription, "1.0.0", "1.0.1")),
+		hex.EncodeToString(hash[:]))
+}
+
+func Test_getVerifiableRenvHash(t *testing.T) {
+	assert.Equal(t, getVerifiableRenvHash(Rpackage{Source: "Repository", Hash: "aaa"}), "aaa")
+	assert.Equal(t, getVerifiableRenvHash(Rpackage{Source: "Repository", RemoteType: "standard",
+		RemoteSha: "1.0.0", Hash: "aaa"}), "aaa")
+	assert.Equal(t, getVerifiableRenvHash(Rpackage{Source: "Bioconductor", Hash: "aaa"}), "aaa")
+	// Package from r-universe built from a git commit.
+	assert.Equal(t, getVerifiableRenvHash(Rpackage{Source: "Repository", RemoteType: "github",
+		RemoteSha: "0123456789012345678901234567890123456789", Hash: "aaa"}), "")
+	assert.Equal(t, getVerifiableRenvHash(Rpackage{Source: "GitHub", RemoteSha: "aaabbb", Hash: "aaa"}), "")
+}
+
+func Test_verifyRenvHash(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "package1_1.0.0.tar.gz")
+	writeTestDescriptionArchive(t, filePath, "package1", testDescription)
+	expectedHash := getRenvHash(testDescription)
+	assert.Equal(t, verifyRenvHash(filePath, "package1", expectedHash), "")
+	assert.Equal(t, verifyRenvHash(filePath, "package1", ""), "")
+	assert.Equal(t, verifyRenvHash(filePath, "package1", "aaa"), "Hash of "+filePath+" is "+expectedHash+
+		" instead of aaa expected by renv.lock.")
+	assert.Contains(t, verifyRenvHash(filePath, "package2", expectedHash), "DESCRIPTION file not found")
+}
+
+func Test_downloadSinglePackageRenvHash(t *testing.T) {
+	previousOutputDirectory := localOutputDirectory
+	localOutputDirectory = t.TempDir()
+	defer func() { localOutputDirectory = previousOutputDirectory }()
+	err := os.MkdirAll(localOutputDirectory+archivesSubdirectory, os.ModePerm)
+	assert.NoError(t, err)
+	downloadFileFunction := func(url string, outputFile string) (int, int64) {
+		if strings.Contains(url, "/Archive/") {
+			writeTestDescriptionArchive(t, outputFile, "package1", testDescription)
+			return 200, 1
+		}
+		return 404, 0
+	}
+	// Archived package version doesn't have a checksum in the PACKAGES file.
+	repositoryPackageInfo := map[string]map[string]*PackageInfo{defaultCranMirrorURL: {"package1": {"2.0.0", "aaa", ""}}}
+	messages := make(chan DownloadInfo, 1)
+	guard := make(chan struct{}, 1)
+	guard <- struct{}{}
+	downloadSinglePackage(context.Background(), "package1", "1.0.0", defaultCranMirrorURL, "", "",
+		"Repository", "CRAN", "", getRenvHash(testDescription), repositoryPackageInfo, nil, nil,
+		map[string]*CacheInfo{}, downloadFileFunction, mockedCloneGitRepo, messages, guard)
+	msg := <-messages
+	assert.Equal(t, msg.StatusCode, 200)
+
+	guard <- struct{}{}
+	downloadSinglePackage(context.Background(), "package1", "1.0.0", defaultCranMirrorURL, "", "",
+		"Repository", "CRAN", "", "aaa", repositoryPackageInfo, nil, nil,
+		map[string]*CacheInfo{}, downloadFileFunction, mockedCloneGitRepo, messages, guard)
+	msg = <-messages
+	assert.Equal(t, msg.StatusCode, downloadStatusIntegrityError)
+	assert.Contains(t, msg.Message, "expected by renv.lock")
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Download status of packages which couldn't be found in the package cache in offline mode.
+const downloadStatusNotInOfflineCache = -9
+
+// Action for packages which have to be downloaded, but can't be because of offline mode.
+const notInOfflineCacheAction = "notfound_offline"
+
+const notInOfflineCache = "not in offline cache"
+
+// Subdirectory of localOutputDirectory with copies of PACKAGES files downloaded from package repositories.
+// They are preserved between runs and used instead of the repositories in offline mode.
+const packagesSnapshotsSubdirectory = "/packages_snapshots"
+
+var errOfflineNetworkAccess = errors.New("network access is disabled in offline mode")
+
+// offlineTransport fails all HTTP requests, so that no request can reach the network in offline mode.
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	log.Error("Refusing to download ", req.URL.Redacted(), " in offline mode.")
+	return nil, errOfflineNetworkAccess
+}
+
+// getPackagesSnapshotPath returns the path where the copy of the PACKAGES file at url is stored.
+func getPackagesSnapshotPath(url string) string {
+	fileName := strings.NewReplacer("://", "_", "/", "_", ":", "_", "?", "_", "&", "_").Replace(url)
+	return filepath.Join(localOutputDirectory+packagesSnapshotsSubdirectory, fileName)
+}
+
+// savePackagesSnapshot saves the contents of the PACKAGES file downloaded from url.
+func savePackagesSnapshot(url string, contents []byte) {
+	snapshotPath := getPackagesSnapshotPath(url)
+	err := os.MkdirAll(filepath.Dir(snapshotPath), os.ModePerm)
+	checkError(err)
+	err = os.WriteFile(snapshotPath, contents, 0644) //#nosec
+	checkError(err)
+}
+
+// withPackagesSnapshots returns the function downloading PACKAGES files with downloadFileFunction,
+// which saves the copies of the downloaded files. In offline mode, the returned function
+// copies the previously saved file to outputFile instead of downloading it.
+func withPackagesSnapshots(downloadFileFunction func(string, string) (int, int64)) func(string, string) (int, int64) {
+	return func(url string, outputFile string) (int, int64) {
+		if offline {
+			contents, err := os.ReadFile(getPackagesSnapshotPath(url))
+			if err != nil {
+				log.Warn(url, " is ", notInOfflineCache, ".")
+				return http.StatusNotFound, 0
+			}
+			err = os.WriteFile(outputFile, contents, 0644) //#nosec
+			checkError(err)
+			return http.StatusOK, 0
+		}
+		statusCode, contentLength := downloadFileFunction(url, outputFile)
+		if statusCode == http.StatusOK {
+			contents, err := os.ReadFile(outputFile)
+			checkError(err)
+			if err == nil {
+				savePackagesSnapshot(url, contents)
+			}
+		}
+		return statusCode, contentLength
+	}
+}
+
+// withPackagesTextSnapshots works like withPackagesSnapshots, but for functions returning
+// the contents of PACKAGES files, such as downloadTextFile.
+func withPackagesTextSnapshots(
+	downloadFileFunction func(string, map[string]string) (int64, string, error),
+) func(string, map[string]string) (int64, string, error) {
+	return func(url string, parameters map[string]string) (int64, string, error) {
+		if offline {
+			contents, err := os.ReadFile(getPackagesSnapshotPath(url))
+			if err != nil {
+				return 0, "", errors.New(url + " is " + notInOfflineCache)
+			}
+			return int64(len(contents)), string(contents), nil
+		}
+		contentLength, contents, err := downloadFileFunction(url, parameters)
+		if err == nil {
+			savePackagesSnapshot(url, []byte(contents))
+		}
+		return contentLength, contents, err
+	}
+}
+
+// getOfflinePackageDetails returns the action, URL, location and size of the package which should
+// be downloaded to outputLocation (or fallbackOutputLocation), but can't be because of offline mode.
+// If the archive has been downloaded to package_archives in one of the previous runs, it's used
+// as a cached package. Otherwise, notInOfflineCacheAction is returned.
+func getOfflinePackageDetails(packageURL string, fallbackPackageURL string, outputLocation string,
+	fallbackOutputLocation string) (string, string, string, int64) {
+	if info, err := os.Stat(outputLocation); err == nil && !info.IsDir() {
+		return cache, packageURL, outputLocation, info.Size()
+	}
+	if fallbackOutputLocation != "" {
+		if info, err := os.Stat(fallbackOutputLocation); err == nil && !info.IsDir() {
+			return cache, fallbackPackageURL, fallbackOutputLocation, info.Size()
+		}
+	}
+	return notInOfflineCacheAction, packageURL, "", 0
+}
+
+// resolveFromOfflineGitCache finds the commit to be checked out (commitSha, or the commit which
+// branchOrTagName or the default branch point to) in the git cache, without contacting the remote.
+// Returns the cached repository, the SHA of the commit, and the size of the cache.
+func resolveFromOfflineGitCache(gitCacheDirectory string, repoURL string, commitSha string,
+	branchOrTagName string) (*git.Repository, plumbing.Hash, int64, error) {
+	repository, err := git.PlainOpen(gitCacheDirectory)
+	if err != nil {
+		return nil, plumbing.ZeroHash, 0, errors.New(repoURL + " is " + notInOfflineCache)
+	}
+	cachedBytes := getGitCacheSize(gitCacheDirectory)
+	revision := commitSha
+	if revision == "" {
+		revision = branchOrTagName
+		if revision == "" || revision == plumbing.HEAD.String() {
+			// The default branch fetched in one of the previous runs.
+			revision = gitCacheRefPrefix + plumbing.HEAD.String()
+		}
+		for _, prefix := range gitRefNamePrefixes {
+			ref, err2 := repository.Reference(plumbing.ReferenceName(prefix+revision), true)
+			if err2 == nil {
+				log.Info(ref.Name(), " of ", repoURL, " found in git cache.")
+				hash, err2 := peelGitTag(repository, ref.Hash())
+				return repository, hash, cachedBytes, err2
+			}
+		}
+	}
+	hash, err := repository.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return nil, plumbing.ZeroHash, cachedBytes,
+			errors.New(revision + " of " + repoURL + " is " + notInOfflineCache)
+	}
+	log.Info(revision, " of ", repoURL, " found in git cache.")
+	return repository, *hash, cachedBytes, nil
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_withPackagesSnapshots(t *testing.T) {
+	previousOutputDirectory := localOutputDirectory
+	localOutputDirectory = t.TempDir()
+	defer func() {
+		localOutputDirectory = previousOutputDirectory
+		offline = false
+	}()
+	downloadFileFunction := func(_ string, outputFile string) (int, int64) {
+		err := os.WriteFile(outputFile, []byte("Package: package1\nVersion: 1.0.0\n"), 0600)
+		assert.NoError(t, err)
+		return http.StatusOK, 33
+	}
+	outputFile := filepath.Join(t.TempDir(), "CRAN_PACKAGES")
+	statusCode, _ := withPackagesSnapshots(downloadFileFunction)(
+		"https://cran.example.com/src/contrib/PACKAGES", outputFile)
+	assert.Equal(t, statusCode, http.StatusOK)
+
+	offline = true
+	failingDownloadFunction := func(_ string, _ string) (int, int64) {
+		t.Error("File downloaded in offline mode.")
+		return -4, 0
+	}
+	outputFile = filepath.Join(t.TempDir(), "CRAN_PACKAGES")
+	statusCode, _ = withPackagesSnapshots(failingDownloadFunction)(
+		"https://cran.example.com/src/contrib/PACKAGES", outputFile)
+	assert.Equal(t, statusCode, http.StatusOK)
+	contents, err := os.ReadFile(outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, string(contents), "Package: package1\nVersion: 1.0.0\n")
+	statusCode, _ = withPackagesSnapshots(failingDownloadFunction)(
+		"https://other.example.com/src/contrib/PACKAGES", outputFile)
+	assert.Equal(t, statusCode, http.StatusNotFound)
+}
+
+func Test_withPackagesTextSnapshots(t *testing.T) {
+	previousOutputDirectory := localOutputDirectory
+	localOutputDirectory = t.TempDir()
+	defer func() {
+		localOutputDirectory = previousOutputDirectory
+		offline = false
+	}()
+	_, _, err := withPackagesTextSnapshots(mockedDownloadTextFile)(
+		"https://repository1.example.com/src/contrib/PACKAGES", map[string]string{})
+	assert.NoError(t, err)
+
+	offline = true
+	failingDownloadFunction := func(_ string, _ map[string]string) (int64, string, error) {
+		t.Error("File downloaded in offline mode.")
+		return 0, "", errors.New("network error")
+	}
+	_, contents, err := withPackagesTextSnapshots(failingDownloadFunction)(
+		"https://repository1.example.com/src/contrib/PACKAGES", map[string]string{})
+	assert.NoError(t, err)
+	assert.Contains(t, contents, "Package: package1")
+	_, _, err = withPackagesTextSnapshots(failingDownloadFunction)(
+		"https://repository2.example.com/src/contrib/PACKAGES", map[string]string{})
+	assert.ErrorContains(t, err, notInOfflineCache)
+}
+
+func Test_downloadSinglePackageOffline(t *testing.T) {
+	previousOutputDirectory := localOutputDirectory
+	localOutputDirectory = t.TempDir()
+	offline = true
+	defer func() {
+		localOutputDirectory = previousOutputDirectory
+		offline = false
+	}()
+	err := os.MkdirAll(localOutputDirectory+archivesSubdirectory, os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(localOutputDirectory+archivesSubdirectory+"package1_1.0.0.tar.gz", []byte("hello"), 0600)
+	assert.NoError(t, err)
+	downloadFileFunction := func(_ string, _ string) (int, int64) {
+		t.Error("Package downloaded in offline mode.")
+		return -4, 0
+	}
+	messages := make(chan DownloadInfo, 1)
+	guard := make(chan struct{}, 1)
+
+	guard <- struct{}{}
+	downloadSinglePackage(context.Background(), "package1", "1.0.0", "https://cran.example.com", "", "",
+		"Repository", "internal", "", "", map[string]map[string]*PackageInfo{}, nil, nil,
+		map[string]*CacheInfo{}, downloadFileFunction, mockedCloneGitRepo, messages, guard)
+	msg := <-messages
+	assert.Equal(t, msg.StatusCode, http.StatusOK)
+	assert.Equal(t, msg.OutputLocation, localOutputDirectory+archivesSubdirectory+"package1_1.0.0.tar.gz")
+	assert.Equal(t, msg.SavedBandwidth, int64(5))
+
+	guard <- struct{}{}
+	downloadSinglePackage(context.Background(), "package2", "1.0.0", "https://cran.example.com", "", "",
+		"Repository", "internal", "", "", map[string]map[string]*PackageInfo{}, nil, nil,
+		map[string]*CacheInfo{}, downloadFileFunction, mockedCloneGitRepo, messages, guard)
+	msg = <-messages
+	assert.Equal(t, msg.StatusCode, downloadStatusNotInOfflineCache)
+	assert.Equal(t, msg.Message, "package2 version 1.0.0 is not in offline cache.")
+}
+
+func Test_downloadSinglePackageOfflineBioconductor(t *testing.T) {
+	previousOutputDirectory := localOutputDirectory
+	localOutputDirectory = t.TempDir()
+	offline = true
+	defer func() {
+		localOutputDirectory = previousOutputDirectory
+		offline = false
+	}()
+	err := os.MkdirAll(localOutputDirectory+archivesSubdirectory, os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(localOutputDirectory+archivesSubdirectory+"biocPackage1_1.0.0.tar.gz", []byte("hello"), 0600)
+	assert.NoError(t, err)
+	biocUrls := make(map[string]string)
+	getBiocUrls("3.18", biocUrls)
+	// PACKAGES snapshots of Bioconductor are not in offline cache.
+	biocPackageInfo := make(map[string]map[string]*PackageInfo)
+	messages := make(chan DownloadInfo, 1)
+	guard := make(chan struct{}, 1)
+
+	guard <- struct{}{}
+	downloadSinglePackage(context.Background(), "biocPackage1", "1.0.0", bioConductorURL, "", "",
+		"Bioconductor", "", "", "", map[string]map[string]*PackageInfo{}, biocPackageInfo, biocUrls,
+		map[string]*CacheInfo{}, mockedDownloadFile, mockedCloneGitRepo, messages, guard)
+	msg := <-messages
+	assert.Equal(t, msg.StatusCode, http.StatusOK)
+	assert.Equal(t, msg.OutputLocation, localOutputDirectory+archivesSubdirectory+"biocPackage1_1.0.0.tar.gz")
+	assert.Equal(t, msg.DownloadedPackageType, "bioconductor")
+
+	guard <- struct{}{}
+	downloadSinglePackage(context.Background(), "biocPackage2", "1.0.0", bioConductorURL, "", "",
+		"Bioconductor", "", "", "", map[string]map[string]*PackageInfo{}, biocPackageInfo, biocUrls,
+		map[string]*CacheInfo{}, mockedDownloadFile, mockedCloneGitRepo, messages, guard)
+	msg = <-messages
+	assert.Equal(t, msg.StatusCode, downloadStatusNotInOfflineCache)
+}
+
+func Test_cloneGitRepoOffline(t *testing.T) {
+	previousOutputDirectory := localOutputDirectory
+	localOutputDirectory = t.TempDir()
+	defer func() {
+		localOutputDirectory = previousOutputDirectory
+		offline = false
+	}()
+	repoDirectory, firstSha, secondSha := createTestGitRepo(t, true)
+	repoURL := "file://" + repoDirectory
+	for _, ref := range []string{"", "v1.0"} {
+		message, _, _, _ := cloneGitRepo(filepath.Join(t.TempDir(), "package"), repoURL, "", "", ref)
+		assert.Equal(t, message, "")
+	}
+
+	// The remote is not accessible anymore.
+	offline = true
+	err := os.RemoveAll(repoDirectory)
+	assert.NoError(t, err)
+	for ref, expectedSha := range map[string]string{"": secondSha, "HEAD": secondSha, "v1.0": firstSha,
+		"refs/tags/v1.0": firstSha, firstSha[:10]: firstSha} {
+		gitDirectory := filepath.Join(t.TempDir(), "package")
+		message, transferredBytes, savedBandwidth, gitPackageShaOrRef := cloneGitRepo(gitDirectory, repoURL, "",
+			"", ref)
+		assert.Equal(t, message, "", ref)
+		assert.Equal(t, transferredBytes, int64(0), ref)
+		assert.Greater(t, savedBandwidth, int64(0), ref)
+		assert.Equal(t, gitPackageShaOrRef, expectedSha, ref)
+	}
+	message, _, _, gitPackageShaOrRef := cloneGitRepo(filepath.Join(t.TempDir(), "package"), repoURL, "",
+		firstSha, "")
+	assert.Equal(t, message, "")
+	assert.Equal(t, gitPackageShaOrRef, firstSha)
+
+	// The branch has never been fetched.
+	message, _, _, _ = cloneGitRepo(filepath.Join(t.TempDir(), "package"), repoURL, "", "", "release-2024")
+	assert.Contains(t, message, notInOfflineCache)
+	assert.False(t, isTransientCloneError(message))
+	message, _, _, _ = cloneGitRepo(filepath.Join(t.TempDir(), "package"), "https://git.example.com/group/repo",
+		"", "", "main")
+	assert.Contains(t, message, notInOfflineCache)
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Text replacing proxy credentials in logs and in the report.
+const maskedCredentials = "*****"
+
+// Returns the URL of the proxy which should be used for the request to the given URL,
+// or nil if no proxy should be used. Replaced in initializeRun by the function
+// configured according to proxy settings.
+var proxyFunc = httpproxy.FromEnvironment().ProxyFunc()
+
+// Names of environment variables which may contain proxy URLs with credentials.
+var proxyEnvVariableRegex = regexp.MustCompile(`(?i)^(https?|all)_proxy$`)
+
+// newProxyFunc returns function determining which proxy should be used for a given URL.
+// If proxyURL is empty, proxy is configured with HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+// environment variables. Otherwise, proxyURL is used for both HTTP and HTTPS requests,
+// except for hosts matching noProxy (or NO_PROXY environment variable, if noProxy is empty).
+// If username is not empty, the credentials replace the ones in the proxy URL.
+func newProxyFunc(proxyURL string, noProxy string, username string,
+	password string) (func(*url.URL) (*url.URL, error), error) {
+	config := httpproxy.FromEnvironment()
+	if proxyURL != "" {
+		config.HTTPProxy = proxyURL
+		config.HTTPSProxy = proxyURL
+	}
+	if noProxy != "" {
+		config.NoProxy = noProxy
+	}
+	if username != "" {
+		for _, p := range []*string{&config.HTTPProxy, &config.HTTPSProxy} {
+			if *p == "" {
+				continue
+			}
+			parsedURL, err := url.Parse(*p)
+			if err != nil {
+				return nil, err
+			}
+			parsedURL.User = url.UserPassword(username, password)
+			*p = parsedURL.String()
+		}
+	}
+	for _, p := range []string{config.HTTPProxy, config.HTTPSProxy} {
+		if _, err := url.Parse(p); err != nil {
+			return nil, err
+		}
+	}
+	return config.ProxyFunc(), nil
+}
+
+// getRequestProxy returns the proxy for HTTP request, and is used as http.Transport Proxy function.
+func getRequestProxy(req *http.Request) (*url.URL, error) {
+	return proxyFunc(req.URL)
+}
+
+// getGitProxyOptions returns go-git proxy options for cloning the repository at repoURL.
+func getGitProxyOptions(repoURL string) transport.ProxyOptions {
+	parsedURL, err := url.Parse(repoURL)
+	if err != nil {
+		return transport.ProxyOptions{}
+	}
+	proxyURL, err := proxyFunc(parsedURL)
+	if err != nil {
+		// Not logging the error, because it quotes the proxy URL, which may contain the credentials.
+		log.Error("Incorrect proxy URL configured for ", parsedURL.Redacted(), ".")
+	}
+	if err != nil || proxyURL == nil {
+		return transport.ProxyOptions{}
+	}
+	proxyOptions := transport.ProxyOptions{}
+	if proxyURL.User != nil {
+		proxyOptions.Username = proxyURL.User.Username()
+		proxyOptions.Password, _ = proxyURL.User.Password()
+	}
+	proxyURLWithoutCredentials := *proxyURL
+	proxyURLWithoutCredentials.User = nil
+	proxyOptions.URL = proxyURLWithoutCredentials.String()
+	return proxyOptions
+}
+
+// maskURLCredentials replaces credentials in the URL with asterisks. If the value
+// is not a URL with credentials, it's returned unchanged.
+func maskURLCredentials(value string) string {
+	parsedURL, err := url.Parse(value)
+	if err != nil || parsedURL.User == nil {
+		// Values such as 'user:password@proxy:3128' are not parsed as URLs with credentials.
+		if before, after, found := strings.Cut(value, "@"); found && !strings.Contains(before, "/") {
+			return maskedCredentials + "@" + after
+		}
+		return value
+	}
+	parsedURL.User = nil
+	return strings.Replace(parsedURL.String(), "://", "://"+maskedCredentials+"@", 1)
+}
+
+// getProxyDescription returns description of proxy settings displayed in the report, with masked credentials.
+func getProxyDescription() string {
+	if proxy != "" {
+		description := maskURLCredentials(proxy)
+		if proxyUsername != "" {
+			description += " (user " + maskedCredentials + ")"
+		}
+		if noProxy != "" {
+			description += ", except for: " + noProxy
+		}
+		return description
+	}
+	return "configured with environment variables"
+}
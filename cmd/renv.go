@@ -18,6 +18,7 @@ package cmd
 import (
 	"encoding/json"
 	"os"
+	"sort"
 )
 
 type Renvlock struct {
@@ -56,6 +57,8 @@ type Rpackage struct {
 	RemoteRef      string `json:",omitempty"`
 	RemoteSha      string `json:",omitempty"`
 	RemoteSubdir   string `json:",omitempty"`
+	// Only exists in renv.lock if package comes from any git repository ("Source": "git").
+	RemoteURL string `json:"RemoteUrl,omitempty"`
 }
 
 func getRenvLock(filename string, renvLock *Renvlock) {
@@ -76,6 +79,29 @@ func getRenvRepositoryURL(renvLockRepositories []Rrepository, repositoryName str
 	return defaultCranMirrorURL
 }
 
+// getRenvRepositories returns the repositories defined in the renv.lock header, and r-universe
+// repositories which packages refer to by URL (in the Repository field) instead of by name.
+func getRenvRepositories(renvLock Renvlock) []Rrepository {
+	repositories := append([]Rrepository{}, renvLock.R.Repositories...)
+	var repositoryNames []string
+	for _, v := range repositories {
+		repositoryNames = append(repositoryNames, v.Name)
+	}
+	var packageNames []string
+	for packageName := range renvLock.Packages {
+		packageNames = append(packageNames, packageName)
+	}
+	sort.Strings(packageNames)
+	for _, packageName := range packageNames {
+		repository := renvLock.Packages[packageName].Repository
+		if isRUniverseRepository(repository) && !stringInSlice(repository, repositoryNames) {
+			repositories = append(repositories, Rrepository{repository, repository})
+			repositoryNames = append(repositoryNames, repository)
+		}
+	}
+	return repositories
+}
+
 // appendIfNotInSlice checks whether itemToAppend already exists in slice.
 // If not, it appends itemToAppend to slice.
 func appendIfNotInSlice(itemToAppend string, slice *[]string) {
@@ -108,10 +134,7 @@ func validatePackageFields(packageName string, packageFields Rpackage,
 		case packageFields.Source == "Repository":
 			log.Warn("Package ", packageName, " doesn't have the Repository field set.")
 			numberOfWarnings++
-		case (packageFields.Source == GitHub || packageFields.Source == GitLab) &&
-			(packageFields.RemoteType == "" || packageFields.RemoteHost == "" ||
-				packageFields.RemoteRepo == "" || packageFields.RemoteUsername == "" ||
-				(packageFields.RemoteRef == "" && packageFields.RemoteSha == "")):
+		case isGitSource(packageFields.Source) && !hasRemoteDetails(packageFields):
 			log.Warn("Package ", packageName, " with source ", packageFields.Source,
 				" doesn't have the required Remote details provided.")
 			numberOfWarnings++
@@ -125,11 +148,25 @@ func validatePackageFields(packageName string, packageFields Rpackage,
 	return numberOfWarnings
 }
 
+// hasRemoteDetails checks whether renv.lock contains the Remote fields required to clone the package
+// from git repository. Packages with "git" source are identified by RemoteUrl, and other packages
+// by RemoteHost (which defaults to bitbucket.org for Bitbucket), RemoteUsername and RemoteRepo.
+func hasRemoteDetails(packageFields Rpackage) bool {
+	if packageFields.RemoteRef == "" && packageFields.RemoteSha == "" {
+		return false
+	}
+	if packageFields.Source == Git {
+		return packageFields.RemoteURL != ""
+	}
+	return packageFields.RemoteType != "" && (packageFields.RemoteHost != "" || packageFields.Source == Bitbucket) &&
+		packageFields.RemoteRepo != "" && packageFields.RemoteUsername != ""
+}
+
 // validateRenvLock returns number of warnings during validation of renv.lock file.
 func validateRenvLock(renvLock Renvlock, erroneousRepositoryNames *[]string) int {
 	var repositories []string
 	var numberOfWarnings int
-	for _, v := range renvLock.R.Repositories {
+	for _, v := range getRenvRepositories(renvLock) {
 		repositories = append(repositories, v.Name)
 	}
 	for k, v := range renvLock.Packages {
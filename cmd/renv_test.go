@@ -38,6 +38,8 @@ func Test_getRenvLock(t *testing.T) {
 	assert.Equal(t, renvLock.Packages["SomeOtherPackage"].RemoteType, "github")
 	assert.Equal(t, renvLock.Packages["SomeOtherPackage"].RemoteHost, "api.github.com")
 	assert.Equal(t, renvLock.Packages["SomeOtherPackage"].RemoteUsername, "RemoteUsername")
+	assert.Equal(t, renvLock.Packages["GitPackage1"].Source, "git")
+	assert.Equal(t, renvLock.Packages["GitPackage1"].RemoteURL, "git@git.example.com:group/GitPackage1.git")
 }
 
 func Test_validateRenvLock(t *testing.T) {
@@ -47,4 +49,36 @@ func Test_validateRenvLock(t *testing.T) {
 	numberOfWarnings := validateRenvLock(renvLock, &erroneousRepositoryNames)
 	assert.Equal(t, numberOfWarnings, 4)
 	assert.Equal(t, len(erroneousRepositoryNames), 2)
+	numberOfWarnings = validatePackageFields("GitPackage2", Rpackage{Package: "GitPackage2", Version: "1.0.0",
+		Source: Git, RemoteRef: "main"}, []string{}, &erroneousRepositoryNames)
+	assert.Equal(t, numberOfWarnings, 1)
+	numberOfWarnings = validatePackageFields("BitbucketPackage2", Rpackage{Package: "BitbucketPackage2",
+		Version: "1.0.0", Source: Bitbucket, RemoteType: "bitbucket", RemoteUsername: "user", RemoteRepo: "repo",
+		RemoteSha: "aaabbbccc"}, []string{}, &erroneousRepositoryNames)
+	assert.Equal(t, numberOfWarnings, 0)
+}
+
+func Test_getRenvRepositories(t *testing.T) {
+	renvLock := Renvlock{
+		R: Rversion{Repositories: []Rrepository{{"CRAN", "https://cloud.r-project.org"},
+			{"insightsengineering", "https://insightsengineering.r-universe.dev"}}},
+		Packages: map[string]Rpackage{
+			"package1": {Package: "package1", Version: "1.0.0", Source: "Repository",
+				Repository: "CRAN"},
+			"package2": {Package: "package2", Version: "1.0.0", Source: "Repository",
+				Repository: "insightsengineering"},
+			"package3": {Package: "package3", Version: "1.0.0", Source: "Repository",
+				Repository: "https://pharmaverse.r-universe.dev"},
+			"package4": {Package: "package4", Version: "1.0.0", Source: "Repository",
+				Repository: "https://pharmaverse.r-universe.dev"},
+			"package5": {Package: "package5", Version: "1.0.0", Source: "Repository",
+				Repository: "https://cran.example.com"},
+		},
+	}
+	assert.Equal(t, getRenvRepositories(renvLock), []Rrepository{{"CRAN", "https://cloud.r-project.org"},
+		{"insightsengineering", "https://insightsengineering.r-universe.dev"},
+		{"https://pharmaverse.r-universe.dev", "https://pharmaverse.r-universe.dev"}})
+	var erroneousRepositoryNames []string
+	assert.Equal(t, validateRenvLock(renvLock, &erroneousRepositoryNames), 1)
+	assert.Equal(t, erroneousRepositoryNames, []string{"https://cran.example.com"})
 }
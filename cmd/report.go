@@ -22,6 +22,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 )
 
 type PackagesData struct {
@@ -34,6 +35,7 @@ type PackagesData struct {
 	BuildStatusText    string `json:"buildStatusText"`
 	CheckTime          string `json:"checkTime"`
 	PackageRepository  string `json:"packageRepository"`
+	MissingSuggests    string `json:"missingSuggests"`
 }
 
 type ReportInfo struct {
@@ -84,12 +86,24 @@ func processDownloadInfo(allDownloadInfo []DownloadInfo) map[string]string {
 				statusDescription = "GitHub clone error"
 			case -3:
 				statusDescription = "GitLab clone error"
+			case downloadStatusGitCloneError:
+				statusDescription = p.PackageRepository + " clone error"
 			case -4:
 				statusDescription = "network error"
+			case downloadStatusCancelled:
+				statusDescription = "cancelled"
+			case downloadStatusNotInOfflineCache:
+				statusDescription = notInOfflineCache
+			case downloadStatusIntegrityError:
+				statusDescription = "checksum mismatch"
 			case 404:
 				statusDescription = "package not found"
 			}
-			downloadStatusText = "<span class=\"badge bg-danger\">" + statusDescription + "</span>"
+			badgeClass := "bg-danger"
+			if p.StatusCode == downloadStatusCancelled {
+				badgeClass = "bg-secondary"
+			}
+			downloadStatusText = "<span class=\"badge " + badgeClass + "\">" + statusDescription + "</span>"
 		} else {
 			downloadStatusText = HTMLStatusOK
 		}
@@ -114,6 +128,28 @@ func processInstallInfo(allInstallInfo []InstallResultInfo) map[string]string {
 		case InstallResultInfoStatusBuildFailed:
 			// If build failed, there is no link to installation logs.
 			installStatusText = "<span class=\"badge bg-danger\">build failed</span>"
+		case InstallResultInfoStatusTimeout:
+			// If build timed out, there is no link to installation logs.
+			if p.BuildStatus == buildStatusTimeout {
+				installStatusText = "<span class=\"badge bg-danger\">build timeout</span>"
+			} else {
+				installStatusText = filePath + "<span class=\"badge bg-danger\">timeout</span></a>"
+			}
+		case InstallResultInfoStatusCancelled:
+			// Logs of cancelled installation are partial, or don't exist if it hasn't been started.
+			if p.LogFilePath != "" {
+				installStatusText = filePath + "<span class=\"badge bg-secondary\">cancelled</span></a>"
+			} else {
+				installStatusText = "<span class=\"badge bg-secondary\">cancelled</span>"
+			}
+		case InstallResultInfoStatusSkipped:
+			// Installation hasn't been attempted, so instead of the link to logs,
+			// the chain of dependencies leading to the failed package is shown.
+			installStatusText = "<span class=\"badge bg-secondary\">skipped: dependency failed</span>" +
+				"<br><small>" + strings.Join(p.FailedDependencyChain, " → ") + "</small>"
+		case InstallResultInfoStatusDependencyCycle:
+			installStatusText = "<span class=\"badge bg-danger\">dependency cycle</span>" +
+				"<br><small>" + strings.Join(p.DependencyCycle, " → ") + "</small>"
 		}
 		installStatuses[p.PackageName] = installStatusText
 	}
@@ -133,12 +169,30 @@ func processBuildInfo(allInstallInfo []InstallResultInfo) map[string]string {
 			buildStatusText = filePath + HTMLStatusOK + HTMLLinkEnd
 		case buildStatusFailed:
 			buildStatusText = filePath + "<span class=\"badge bg-danger\">failed</span></a>"
+		case buildStatusTimeout:
+			// The link points to the partial log.
+			buildStatusText = filePath + "<span class=\"badge bg-danger\">timeout</span></a>"
 		}
 		buildStatuses[p.PackageName] = buildStatusText
 	}
 	return buildStatuses
 }
 
+// processMissingSuggests, for each item from installation info JSON, generates HTML code
+// listing Suggested packages which are not present in renv.lock. Returns map from package
+// name to HTML code.
+func processMissingSuggests(allInstallInfo []InstallResultInfo) map[string]string {
+	missingSuggests := make(map[string]string)
+	for _, p := range allInstallInfo {
+		if len(p.MissingSuggests) > 0 {
+			missingSuggests[p.PackageName] = "<span class=\"badge bg-warning text-dark\">" +
+				strconv.Itoa(len(p.MissingSuggests)) + " missing</span><br><small>" +
+				strings.Join(p.MissingSuggests, ", ") + "</small>"
+		}
+	}
+	return missingSuggests
+}
+
 // processCheckInfo, for each item from R CMD check info JSON, generates HTML code for badge
 // in the report corresponding to the package. Returns map from package name to HTML code
 // for both check statuses and check times, as well as total check time.
@@ -161,6 +215,16 @@ func processCheckInfo(allCheckInfo []PackageCheckInfo) (map[string]string, map[s
 		case "ERROR":
 			checkStatusText = filePath +
 				"<span class=\"badge bg-danger\">check error(s)</span></a>"
+		case cancelledConst:
+			if p.LogFilePath != "" {
+				checkStatusText = filePath + "<span class=\"badge bg-secondary\">cancelled</span></a>"
+			} else {
+				checkStatusText = "<span class=\"badge bg-secondary\">cancelled</span>"
+			}
+		case timeoutConst:
+			// The link points to the partial log.
+			checkStatusText = filePath +
+				"<span class=\"badge bg-danger\">check timeout</span></a>"
 		}
 		checkStatuses[p.PackageName] = checkStatusText
 		checkTimes[p.PackageName] = strconv.Itoa(p.CheckTime)
@@ -169,6 +233,20 @@ func processCheckInfo(allCheckInfo []PackageCheckInfo) (map[string]string, map[s
 	return checkStatuses, checkTimes, strconv.Itoa(totalCheckTime)
 }
 
+// getReportRepository returns the text shown in the repository column of the report.
+// For packages cloned from git repositories, the host and the path of the repository is shown
+// next to the package source, e.g. "Gitea (gitea.example.com/group/repo)".
+func getReportRepository(p DownloadInfo) string {
+	if (!isGitSource(p.PackageRepository) && p.DownloadedPackageType != gitConst) || p.SuccessfulURL == "" {
+		return p.PackageRepository
+	}
+	host, _, path := parseGitRemoteURL(p.SuccessfulURL)
+	if host == "" {
+		return p.PackageRepository
+	}
+	return p.PackageRepository + " (" + host + "/" + strings.TrimSuffix(path, ".git") + ")"
+}
+
 // processReportData returns processed download, installation and check information in a structure that
 // can be consumed by Go templating engine.
 func processReportData(allDownloadInfo []DownloadInfo, allInstallInfo []InstallResultInfo,
@@ -179,6 +257,7 @@ func processReportData(allDownloadInfo []DownloadInfo, allInstallInfo []InstallR
 	installStatuses := processInstallInfo(allInstallInfo)
 	// Builiding packages is done as part of install step, so build status is stored in installation info structure.
 	buildStatuses := processBuildInfo(allInstallInfo)
+	missingSuggests := processMissingSuggests(allInstallInfo)
 	checkStatuses, checkTimes, totalCheckTime := processCheckInfo(allCheckInfo)
 	reportOutput.TotalCheckTime = totalCheckTime
 
@@ -188,7 +267,7 @@ func processReportData(allDownloadInfo []DownloadInfo, allInstallInfo []InstallR
 			reportOutput.PackagesInformation,
 			PackagesData{p.PackageName, p.PackageVersion, p.GitPackageShaOrRef, downloadStatuses[p.PackageName],
 				installStatuses[p.PackageName], checkStatuses[p.PackageName], buildStatuses[p.PackageName],
-				checkTimes[p.PackageName], p.PackageRepository},
+				checkTimes[p.PackageName], getReportRepository(p), missingSuggests[p.PackageName]},
 		)
 	}
 	reportOutput.SystemInformation = systemInfo
@@ -111,6 +111,7 @@ const HTMLReportTemplate = `<!doctype html>
                     <th>Check</th>
                     <th>Check time (s) (Total: {{.TotalCheckTime}})</th>
                     <th>Git Ref</th>
+                    <th>Missing Suggests</th>
                 </tr>
             </thead>
             <tbody>
@@ -119,13 +120,14 @@ const HTMLReportTemplate = `<!doctype html>
                 <tr>
                     <td>{{.PackageName}}</td>
                     <td>{{.PackageVersion}}</td>
-                    <td>{{.PackageRepository | safe}}</td>
+                    <td>{{.PackageRepository}}</td>
                     <td>{{.DownloadStatusText | safe}}</td>
                     <td>{{.BuildStatusText | safe}}</td>
                     <td>{{.InstallStatusText | safe}}</td>
                     <td>{{.CheckStatusText | safe}}</td>
                     <td>{{.CheckTime}}</td>
                     <td><code>{{.GitPackageShaOrRef}}</code></td>
+                    <td>{{.MissingSuggests | safe}}</td>
                 </tr>
                 {{end}}
                 <!-- end go template iteration -->
@@ -140,6 +142,7 @@ const HTMLReportTemplate = `<!doctype html>
                         <th>Check</th>
                         <th>Check time (s) (Total: {{.TotalCheckTime}})</th>
                         <th>Git Ref</th>
+                        <th>Missing Suggests</th>
                     </tr>
                 </tfoot>
             </tbody>
@@ -231,6 +234,26 @@ const HTMLReportTemplate = `<!doctype html>
                     </code>
                 </div>
             </div>
+            <div class="row">
+                <div class="col">
+                    <p class="fw-bold">TLS Verification</p>
+                </div>
+                <div class="col">
+                    <code>
+                    {{.SystemInformation.TLSVerification}}
+                    </code>
+                </div>
+            </div>
+            <div class="row">
+                <div class="col">
+                    <p class="fw-bold">Proxy</p>
+                </div>
+                <div class="col">
+                    <code>
+                    {{.SystemInformation.Proxy}}
+                    </code>
+                </div>
+            </div>
             <div class="row">
                 <div class="col">
                     <p class="fw-bold">Time</p>
@@ -32,6 +32,33 @@ func Test_processDownloadInfo(t *testing.T) {
 	assert.Equal(t, downloadStatuses["teal.reporter"], "<span class=\"badge bg-danger\">GitHub clone error</span>")
 	assert.Equal(t, downloadStatuses["teal.widgets"], "<span class=\"badge bg-danger\">GitLab clone error</span>")
 	assert.Equal(t, downloadStatuses["httr"], "<span class=\"badge bg-danger\">BioC package not found</span>")
+	downloadStatuses = processDownloadInfo([]DownloadInfo{{StatusCode: downloadStatusCancelled, PackageName: "package1"}})
+	assert.Equal(t, downloadStatuses["package1"], "<span class=\"badge bg-secondary\">cancelled</span>")
+	downloadStatuses = processDownloadInfo([]DownloadInfo{{StatusCode: downloadStatusIntegrityError, PackageName: "package2"}})
+	assert.Equal(t, downloadStatuses["package2"], "<span class=\"badge bg-danger\">checksum mismatch</span>")
+	downloadStatuses = processDownloadInfo([]DownloadInfo{{StatusCode: downloadStatusGitCloneError,
+		PackageName: "package3", PackageRepository: Gitea}})
+	assert.Equal(t, downloadStatuses["package3"], "<span class=\"badge bg-danger\">Gitea clone error</span>")
+	downloadStatuses = processDownloadInfo([]DownloadInfo{{StatusCode: downloadStatusNotInOfflineCache,
+		PackageName: "package4"}})
+	assert.Equal(t, downloadStatuses["package4"], "<span class=\"badge bg-danger\">not in offline cache</span>")
+}
+
+func Test_getReportRepository(t *testing.T) {
+	assert.Equal(t, getReportRepository(DownloadInfo{PackageRepository: "CRAN",
+		SuccessfulURL: "https://cloud.r-project.org/src/contrib/package1_1.0.0.tar.gz"}), "CRAN")
+	assert.Equal(t, getReportRepository(DownloadInfo{PackageRepository: Gitea,
+		SuccessfulURL: "https://gitea.example.com/group/repo"}), "Gitea (gitea.example.com/group/repo)")
+	assert.Equal(t, getReportRepository(DownloadInfo{PackageRepository: Git,
+		SuccessfulURL: "git@git.example.com:group/repo.git"}), "git (git.example.com/group/repo)")
+	assert.Equal(t, getReportRepository(DownloadInfo{PackageRepository: Bitbucket}), "Bitbucket")
+	// Package from r-universe cloned from git repository.
+	assert.Equal(t, getReportRepository(DownloadInfo{PackageRepository: "insightsengineering",
+		DownloadedPackageType: "git", SuccessfulURL: "https://github.com/insightsengineering/teal"}),
+		"insightsengineering (github.com/insightsengineering/teal)")
+	// The path is escaped when the report is rendered, rather than here.
+	assert.Equal(t, getReportRepository(DownloadInfo{PackageRepository: Git,
+		SuccessfulURL: "https://git.example.com/r&d/o'repo"}), "git (git.example.com/r&d/o'repo)")
 }
 
 func Test_processInstallInfo(t *testing.T) {
@@ -40,6 +67,33 @@ func Test_processInstallInfo(t *testing.T) {
 	installStatuses := processInstallInfo(allInstallInfo)
 	assert.Equal(t, installStatuses["Matrix"], "<a href=\"./logs/install-Matrix.html\"><span class=\"badge bg-success\">OK</span></a>")
 	assert.Equal(t, installStatuses["package2"], "<a href=\"./logs/install-package2.html\"><span class=\"badge bg-danger\">failed</span></a>")
+	installStatuses = processInstallInfo([]InstallResultInfo{
+		{PackageName: "package1", Status: InstallResultInfoStatusSkipped,
+			FailedDependencyChain: []string{"package2", "package3"}},
+	})
+	assert.Equal(t, installStatuses["package1"], "<span class=\"badge bg-secondary\">skipped: dependency failed</span>"+
+		"<br><small>package2 → package3</small>")
+	installStatuses = processInstallInfo([]InstallResultInfo{
+		{PackageName: "package4", Status: InstallResultInfoStatusDependencyCycle,
+			DependencyCycle: []string{"package4", "package5", "package4"}},
+	})
+	assert.Equal(t, installStatuses["package4"], "<span class=\"badge bg-danger\">dependency cycle</span>"+
+		"<br><small>package4 → package5 → package4</small>")
+	installStatuses = processInstallInfo([]InstallResultInfo{
+		{PackageName: "package6", Status: InstallResultInfoStatusTimeout, LogFilePath: "/tmp/package6.html",
+			BuildStatus: buildStatusNotBuilt},
+		{PackageName: "package7", Status: InstallResultInfoStatusTimeout, BuildStatus: buildStatusTimeout},
+	})
+	assert.Equal(t, installStatuses["package6"],
+		"<a href=\"./logs/install-package6.html\"><span class=\"badge bg-danger\">timeout</span></a>")
+	assert.Equal(t, installStatuses["package7"], "<span class=\"badge bg-danger\">build timeout</span>")
+	installStatuses = processInstallInfo([]InstallResultInfo{
+		{PackageName: "package8", Status: InstallResultInfoStatusCancelled, LogFilePath: "/tmp/package8.html"},
+		{PackageName: "package9", Status: InstallResultInfoStatusCancelled},
+	})
+	assert.Equal(t, installStatuses["package8"],
+		"<a href=\"./logs/install-package8.html\"><span class=\"badge bg-secondary\">cancelled</span></a>")
+	assert.Equal(t, installStatuses["package9"], "<span class=\"badge bg-secondary\">cancelled</span>")
 }
 
 func Test_processCheckInfo(t *testing.T) {
@@ -55,6 +109,12 @@ func Test_processCheckInfo(t *testing.T) {
 	assert.Equal(t, checkTimes["package3"], "35")
 	assert.Equal(t, checkTimes["package4"], "40")
 	assert.Equal(t, totalCheckTime, "125")
+	checkStatuses, _, _ = processCheckInfo([]PackageCheckInfo{
+		{PackageName: "package5", LogFilePath: "/tmp/package5.html", MostSevereCheckItem: timeoutConst},
+		{PackageName: "package6", MostSevereCheckItem: cancelledConst},
+	})
+	assert.Equal(t, checkStatuses["package6"], "<span class=\"badge bg-secondary\">cancelled</span>")
+	assert.Equal(t, checkStatuses["package5"], "<a href=\"./logs/check-package5.html\"><span class=\"badge bg-danger\">check timeout</span></a>")
 }
 
 func Test_processBuildInfo(t *testing.T) {
@@ -63,4 +123,18 @@ func Test_processBuildInfo(t *testing.T) {
 	buildStatuses := processBuildInfo(allInstallInfo)
 	assert.Equal(t, buildStatuses["Matrix"], "<a href=\"./logs/build-Matrix.html\"><span class=\"badge bg-success\">OK</span></a>")
 	assert.Equal(t, buildStatuses["package2"], "<a href=\"./logs/build-package2.html\"><span class=\"badge bg-success\">OK</span></a>")
+	buildStatuses = processBuildInfo([]InstallResultInfo{
+		{PackageName: "package3", BuildStatus: buildStatusTimeout, BuildLogFilePath: "/tmp/package3.html"},
+	})
+	assert.Equal(t, buildStatuses["package3"], "<a href=\"./logs/build-package3.html\"><span class=\"badge bg-danger\">timeout</span></a>")
+}
+
+func Test_processMissingSuggests(t *testing.T) {
+	missingSuggests := processMissingSuggests([]InstallResultInfo{
+		{PackageName: "package1", MissingSuggests: []string{"package2", "package3"}},
+		{PackageName: "package4"},
+	})
+	assert.Equal(t, missingSuggests["package1"], "<span class=\"badge bg-warning text-dark\">2 missing</span><br><small>package2, package3</small>")
+	_, ok := missingSuggests["package4"]
+	assert.False(t, ok)
 }
@@ -0,0 +1,200 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Maximum time to wait between consecutive attempts, regardless of the number of attempts.
+const maxRetryDelay = time.Minute
+
+// parseRepositoryMirrors parses expression such as 'CRAN=https://mirror1.example.com|https://mirror2.example.com,BioC=...'
+// and returns map from repository name to the ordered list of its mirror URLs.
+func parseRepositoryMirrors(expression string) (map[string][]string, error) {
+	repositoryMirrors := make(map[string][]string)
+	if expression == "" {
+		return repositoryMirrors, nil
+	}
+	for _, item := range strings.Split(expression, ",") {
+		repositoryName, mirrors, found := strings.Cut(strings.TrimSpace(item), "=")
+		if !found || repositoryName == "" || mirrors == "" {
+			return nil, errors.New("invalid repository mirrors definition: " + item)
+		}
+		for _, mirror := range strings.Split(mirrors, "|") {
+			if mirror == "" {
+				return nil, errors.New("empty mirror URL for repository " + repositoryName)
+			}
+			repositoryMirrors[repositoryName] = append(repositoryMirrors[repositoryName],
+				strings.TrimSuffix(mirror, "/"))
+		}
+	}
+	return repositoryMirrors, nil
+}
+
+// getMirrorURLs returns the list of URLs from which the file at packageURL can be downloaded:
+// packageURL itself followed by the corresponding URLs in mirrors of the repository at repoURL,
+// as defined for repositoryName in repositoryMirrors.
+func getMirrorURLs(packageURL string, repoURL string, repositoryName string) []string {
+	urls := []string{packageURL}
+	if repoURL == "" || !strings.HasPrefix(packageURL, repoURL) {
+		return urls
+	}
+	for _, mirror := range repositoryMirrors[repositoryName] {
+		urls = append(urls, mirror+strings.TrimPrefix(packageURL, repoURL))
+	}
+	return urls
+}
+
+// isTransientStatusCode checks whether the download with the status code may succeed if it's retried.
+func isTransientStatusCode(statusCode int) bool {
+	switch statusCode {
+	case -4, http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// isTransientCloneError checks whether cloning the git repository which failed with the error message
+// may succeed if it's retried. Only network errors, timeouts and server errors are considered transient.
+func isTransientCloneError(message string) bool {
+	if isPermanentCloneError(message) {
+		return false
+	}
+	for _, transientError := range []string{
+		"unexpected EOF", "connection reset", "connection refused", "broken pipe", "network is unreachable",
+		"no route to host", "temporary failure in name resolution", "timeout", "timed out",
+		// HTTP 5xx responses returned by go-git.
+		"status code: 5", "Internal Server Error", "Bad Gateway", "Service Unavailable", "Gateway Timeout",
+	} {
+		if strings.Contains(message, transientError) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPermanentCloneError checks whether cloning the git repository which failed with the error message
+// won't succeed regardless of how the repository is fetched.
+func isPermanentCloneError(message string) bool {
+	for _, permanentError := range []string{
+		"repository not found", "authentication required", "authorization failed", "not found in remote",
+		notInOfflineCache,
+		// SSH authentication errors.
+		"unable to authenticate", "SSH_AUTH_SOCK", "knownhosts",
+	} {
+		if strings.Contains(message, permanentError) {
+			return true
+		}
+	}
+	return false
+}
+
+// getRetryDelay returns the time to wait before the retry number attempt (counting from 1).
+// The delay grows exponentially with each attempt, and is randomized, so that downloads
+// failing at the same time are not retried at the same time.
+func getRetryDelay(attempt int) time.Duration {
+	delay := maxRetryDelay
+	if attempt < 32 && retryBackoff<<(attempt-1) > 0 && retryBackoff<<(attempt-1) < maxRetryDelay {
+		delay = retryBackoff << (attempt - 1)
+	}
+	return delay/2 + rand.N(delay/2+1) // #nosec G404
+}
+
+// waitBeforeRetry waits before the retry number attempt. Returns false if ctx has been cancelled.
+func waitBeforeRetry(ctx context.Context, attempt int) bool {
+	timer := time.NewTimer(getRetryDelay(attempt))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// downloadFileWithRetries tries to download the file to outputFile from each of urls in turn, until the download
+// succeeds. Each URL is retried up to downloadRetries times in case of transient errors.
+// Returns the HTTP status code and number of bytes of the last download attempt, URL from which
+// the file has been downloaded (or the last URL tried), and the total number of attempts.
+func downloadFileWithRetries(ctx context.Context, urls []string, outputFile string,
+	downloadFileFunction func(string, string) (int, int64)) (int, int64, string, int) {
+	var statusCode int
+	var contentLength int64
+	var url string
+	attempts := 0
+	for _, url = range urls {
+		for retry := 0; retry <= downloadRetries; retry++ {
+			if retry > 0 {
+				log.Warn("Retrying download of ", url, " after status ", statusCode, ".")
+				if !waitBeforeRetry(ctx, retry) {
+					return statusCode, contentLength, url, attempts
+				}
+			}
+			statusCode, contentLength = downloadFileFunction(url, outputFile)
+			attempts++
+			if statusCode == http.StatusOK {
+				return statusCode, contentLength, url, attempts
+			}
+			if !isTransientStatusCode(statusCode) || isCancelled(ctx) {
+				break
+			}
+		}
+		if isCancelled(ctx) {
+			break
+		}
+	}
+	return statusCode, contentLength, url, attempts
+}
+
+// cloneGitRepoWithRetries clones the git repository with gitCloneFunction, retrying up to downloadRetries
+// times in case of transient errors. Returns the values returned by the last gitCloneFunction call,
+// and the number of attempts.
+func cloneGitRepoWithRetries(ctx context.Context, gitDirectory string, repoURL string,
+	environmentCredentialsType string, commitSha string, branchOrTagName string,
+	gitCloneFunction func(string, string, string, string, string) (string, int64, int64, string),
+) (string, int64, int64, string, int) {
+	var message string
+	var gitRepoSize int64
+	var gitSavedBandwidth int64
+	var gitPackageShaOrRef string
+	attempts := 0
+	for retry := 0; retry <= downloadRetries; retry++ {
+		if retry > 0 {
+			log.Warn("Retrying clone of ", repoURL, " after error: ", message)
+			if !waitBeforeRetry(ctx, retry) {
+				break
+			}
+			// Remove the files checked out during the failed attempt.
+			err := os.RemoveAll(gitDirectory)
+			checkError(err)
+		}
+		message, gitRepoSize, gitSavedBandwidth, gitPackageShaOrRef = gitCloneFunction(gitDirectory, repoURL,
+			environmentCredentialsType, commitSha, branchOrTagName)
+		attempts++
+		if message == "" || !isTransientCloneError(message) || isCancelled(ctx) {
+			break
+		}
+	}
+	return message, gitRepoSize, gitSavedBandwidth, gitPackageShaOrRef, attempts
+}
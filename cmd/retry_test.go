@@ -0,0 +1,170 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseRepositoryMirrors(t *testing.T) {
+	repositoryMirrors, err := parseRepositoryMirrors(
+		"CRAN=https://mirror1.example.com/|https://mirror2.example.com, Bioconductor=https://bioc.example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, repositoryMirrors, map[string][]string{
+		"CRAN":         {"https://mirror1.example.com", "https://mirror2.example.com"},
+		"Bioconductor": {"https://bioc.example.com"},
+	})
+	repositoryMirrors, err = parseRepositoryMirrors("")
+	assert.NoError(t, err)
+	assert.Equal(t, len(repositoryMirrors), 0)
+	_, err = parseRepositoryMirrors("CRAN")
+	assert.Error(t, err)
+	_, err = parseRepositoryMirrors("CRAN=https://mirror1.example.com||https://mirror2.example.com")
+	assert.Error(t, err)
+}
+
+func Test_getMirrorURLs(t *testing.T) {
+	repositoryMirrors = map[string][]string{
+		"CRAN": {"https://mirror1.example.com", "https://mirror2.example.com"},
+	}
+	defer func() { repositoryMirrors = nil }()
+	assert.Equal(t, getMirrorURLs("https://cran.example.com/src/contrib/package1_1.0.0.tar.gz",
+		"https://cran.example.com", "CRAN"), []string{
+		"https://cran.example.com/src/contrib/package1_1.0.0.tar.gz",
+		"https://mirror1.example.com/src/contrib/package1_1.0.0.tar.gz",
+		"https://mirror2.example.com/src/contrib/package1_1.0.0.tar.gz",
+	})
+	assert.Equal(t, getMirrorURLs("https://repo.example.com/src/contrib/package1_1.0.0.tar.gz",
+		"https://repo.example.com", "Other"), []string{
+		"https://repo.example.com/src/contrib/package1_1.0.0.tar.gz",
+	})
+}
+
+func Test_isTransientStatusCode(t *testing.T) {
+	assert.True(t, isTransientStatusCode(-4))
+	assert.True(t, isTransientStatusCode(502))
+	assert.True(t, isTransientStatusCode(429))
+	assert.False(t, isTransientStatusCode(404))
+	assert.False(t, isTransientStatusCode(200))
+}
+
+func Test_isTransientCloneError(t *testing.T) {
+	assert.True(t, isTransientCloneError("Error while cloning repo https://github.com/a/b: unexpected EOF"))
+	assert.False(t, isTransientCloneError("Error while cloning repo https://github.com/a/b: repository not found"))
+	assert.False(t, isTransientCloneError("Error while cloning repo https://github.com/a/b: authentication required"))
+	assert.False(t, isTransientCloneError("Error while cloning repo git@gitlab.example.com:a/b: "+
+		"ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey]"))
+	assert.True(t, isTransientCloneError("Error while cloning repo https://github.com/a/b: unexpected client error: "+
+		"unexpected requesting \"https://github.com/a/b/info/refs\" status code: 502"))
+	assert.True(t, isTransientCloneError("Error while cloning repo https://github.com/a/b: "+
+		"dial tcp 140.82.121.4:443: i/o timeout"))
+	assert.False(t, isTransientCloneError("Error while cloning repo https://github.com/a/b: object not found"))
+	assert.False(t, isTransientCloneError("Error while cloning repo https://github.com/a/b: "+
+		"unexpected client error: unexpected requesting \"https://github.com/a/b/info/refs\" status code: 400"))
+}
+
+func Test_isPermanentCloneError(t *testing.T) {
+	assert.True(t, isPermanentCloneError("Error while cloning repo https://github.com/a/b: repository not found"))
+	assert.True(t, isPermanentCloneError("Error while cloning repo https://github.com/a/b: "+notInOfflineCache))
+	assert.False(t, isPermanentCloneError("Error while cloning repo https://github.com/a/b: object not found"))
+}
+
+func Test_getRetryDelay(t *testing.T) {
+	retryBackoff = time.Second
+	for attempt := 1; attempt <= 3; attempt++ {
+		delay := getRetryDelay(attempt)
+		expectedDelay := time.Second << (attempt - 1)
+		assert.GreaterOrEqual(t, delay, expectedDelay/2)
+		assert.LessOrEqual(t, delay, expectedDelay)
+	}
+	assert.LessOrEqual(t, getRetryDelay(100), maxRetryDelay)
+	assert.GreaterOrEqual(t, getRetryDelay(100), maxRetryDelay/2)
+}
+
+func Test_downloadFileWithRetries(t *testing.T) {
+	downloadRetries = 2
+	retryBackoff = time.Millisecond
+	defer func() { downloadRetries = 0 }()
+	var requestedURLs []string
+	statusCodes := map[string][]int{
+		"https://repo.example.com/package1.tar.gz":    {502, 503, 502},
+		"https://mirror1.example.com/package1.tar.gz": {404},
+		"https://mirror2.example.com/package1.tar.gz": {-4, 200},
+	}
+	downloadFileFunction := func(url string, _ string) (int, int64) {
+		requestedURLs = append(requestedURLs, url)
+		statusCode := statusCodes[url][0]
+		statusCodes[url] = statusCodes[url][1:]
+		return statusCode, 10
+	}
+	statusCode, contentLength, url, attempts := downloadFileWithRetries(context.Background(), []string{
+		"https://repo.example.com/package1.tar.gz",
+		"https://mirror1.example.com/package1.tar.gz",
+		"https://mirror2.example.com/package1.tar.gz",
+	}, "/tmp/package1.tar.gz", downloadFileFunction)
+	assert.Equal(t, statusCode, 200)
+	assert.Equal(t, contentLength, int64(10))
+	assert.Equal(t, url, "https://mirror2.example.com/package1.tar.gz")
+	assert.Equal(t, attempts, 6)
+	assert.Equal(t, requestedURLs, []string{
+		"https://repo.example.com/package1.tar.gz",
+		"https://repo.example.com/package1.tar.gz",
+		"https://repo.example.com/package1.tar.gz",
+		"https://mirror1.example.com/package1.tar.gz",
+		"https://mirror2.example.com/package1.tar.gz",
+		"https://mirror2.example.com/package1.tar.gz",
+	})
+
+	// No retries are made after cancellation.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	statusCode, _, _, attempts = downloadFileWithRetries(ctx, []string{
+		"https://repo.example.com/package2.tar.gz",
+		"https://mirror1.example.com/package2.tar.gz",
+	}, "/tmp/package2.tar.gz", func(_ string, _ string) (int, int64) { return -4, 0 })
+	assert.Equal(t, statusCode, -4)
+	assert.Equal(t, attempts, 1)
+}
+
+func Test_cloneGitRepoWithRetries(t *testing.T) {
+	downloadRetries = 3
+	retryBackoff = time.Millisecond
+	defer func() { downloadRetries = 0 }()
+	messages := []string{"Error while cloning repo: unexpected EOF", ""}
+	gitCloneFunction := func(_ string, _ string, _ string, _ string, _ string) (string, int64, int64, string) {
+		message := messages[0]
+		messages = messages[1:]
+		return message, 1, 0, "v0.0.1"
+	}
+	message, _, _, gitPackageShaOrRef, attempts := cloneGitRepoWithRetries(context.Background(),
+		t.TempDir(), "https://github.com/a/b", github, "", "v0.0.1", gitCloneFunction)
+	assert.Equal(t, message, "")
+	assert.Equal(t, gitPackageShaOrRef, "v0.0.1")
+	assert.Equal(t, attempts, 2)
+
+	// Permanent errors are not retried.
+	message, _, _, _, attempts = cloneGitRepoWithRetries(context.Background(), t.TempDir(),
+		"https://github.com/a/b", github, "", "",
+		func(_ string, _ string, _ string, _ string, _ string) (string, int64, int64, string) {
+			return "Error while cloning repo: repository not found", 0, 0, ""
+		})
+	assert.Equal(t, message, "Error while cloning repo: repository not found")
+	assert.Equal(t, attempts, 1)
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Domain of r-universe repositories, e.g. https://insightsengineering.r-universe.dev
+const rUniverseDomain = ".r-universe.dev"
+
+// isRUniverseRepository checks whether repoURL points to r-universe repository.
+func isRUniverseRepository(repoURL string) bool {
+	parsedURL, err := url.Parse(repoURL)
+	if err != nil || (parsedURL.Scheme != "https" && parsedURL.Scheme != "http") {
+		return false
+	}
+	return strings.HasSuffix(parsedURL.Hostname(), rUniverseDomain)
+}
+
+// getRUniverseGitSource returns the source and the URL of the git repository from which the package
+// should be cloned, in case the package comes from r-universe repository (repoURL), but the universe
+// doesn't serve the package version required by renv.lock anymore. r-universe only serves the newest
+// version of each package built from the git repository, so older versions are cloned from the git
+// remote at the commit recorded in renv.lock (RemoteUrl and RemoteSha fields). The same applies
+// if the universe serves the required version, but built from a different commit than the one in renv.lock.
+// packageInfo contains the current package versions and commits from the PACKAGES file of the universe.
+// Returns empty strings if the package should be downloaded from the repository.
+func getRUniverseGitSource(v Rpackage, repoURL string, packageInfo map[string]*PackageInfo) (string, string) {
+	if !isRUniverseRepository(repoURL) || v.RemoteURL == "" || v.RemoteSha == "" || packageInfo == nil {
+		return "", ""
+	}
+	currentPackageInfo, ok := packageInfo[v.Package]
+	switch {
+	case !ok || currentPackageInfo.Version != v.Version:
+		log.Info(v.Package, " version ", v.Version, " is not available in ", repoURL, ", so it will be cloned from ",
+			v.RemoteURL, " at commit ", v.RemoteSha, ".")
+	case currentPackageInfo.RemoteSha != "" && !strings.HasPrefix(currentPackageInfo.RemoteSha, v.RemoteSha):
+		log.Info(v.Package, " version ", v.Version, " in ", repoURL, " has been built from commit ",
+			currentPackageInfo.RemoteSha, ", so it will be cloned from ", v.RemoteURL, " at commit ", v.RemoteSha, ".")
+	default:
+		return "", ""
+	}
+	if strings.HasPrefix(v.RemoteURL, "https://github.com/") {
+		return GitHub, strings.TrimSuffix(v.RemoteURL, ".git")
+	}
+	return Git, v.RemoteURL
+}
+
+// getRUniversePackageSha returns the commit from which the package downloaded from repoURL
+// has been built, according to the PACKAGES file of the universe (packageInfo), if repoURL
+// is r-universe repository. Otherwise, returns empty string.
+func getRUniversePackageSha(repoURL string, packageName string, packageInfo map[string]*PackageInfo) string {
+	if currentPackageInfo, ok := packageInfo[packageName]; ok && isRUniverseRepository(repoURL) {
+		return currentPackageInfo.RemoteSha
+	}
+	return ""
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_isRUniverseRepository(t *testing.T) {
+	assert.True(t, isRUniverseRepository("https://insightsengineering.r-universe.dev"))
+	assert.True(t, isRUniverseRepository("https://pharmaverse.r-universe.dev/"))
+	assert.False(t, isRUniverseRepository("https://cloud.r-project.org"))
+	assert.False(t, isRUniverseRepository("https://r-universe.dev.example.com"))
+	assert.False(t, isRUniverseRepository("insightsengineering"))
+}
+
+func Test_getRUniverseGitSource(t *testing.T) {
+	packageInfo := map[string]*PackageInfo{"teal": {"0.15.2", "aaabbbccc", "aaabbbccc111222"}}
+	repoURL := "https://insightsengineering.r-universe.dev"
+	v := Rpackage{Package: "teal", Version: "0.15.2", Source: "Repository", Repository: "insightsengineering",
+		RemoteURL: "https://github.com/insightsengineering/teal", RemoteSha: "aaabbbccc"}
+	// The version is served by the universe.
+	packageSource, gitRepoURL := getRUniverseGitSource(v, repoURL, packageInfo)
+	assert.Equal(t, packageSource, "")
+	assert.Equal(t, gitRepoURL, "")
+
+	// The version is served by the universe, but built from a different commit.
+	v.RemoteSha = "dddeeefff"
+	packageSource, gitRepoURL = getRUniverseGitSource(v, repoURL, packageInfo)
+	assert.Equal(t, packageSource, GitHub)
+	assert.Equal(t, gitRepoURL, "https://github.com/insightsengineering/teal")
+
+	v.RemoteSha = "aaabbbccc"
+	v.Version = "0.15.0"
+	packageSource, gitRepoURL = getRUniverseGitSource(v, repoURL, packageInfo)
+	assert.Equal(t, packageSource, GitHub)
+	assert.Equal(t, gitRepoURL, "https://github.com/insightsengineering/teal")
+	v.RemoteURL = "https://gitlab.example.com/group/teal.git"
+	packageSource, gitRepoURL = getRUniverseGitSource(v, repoURL, packageInfo)
+	assert.Equal(t, packageSource, Git)
+	assert.Equal(t, gitRepoURL, "https://gitlab.example.com/group/teal.git")
+
+	// PACKAGES file of the universe couldn't be retrieved.
+	packageSource, _ = getRUniverseGitSource(v, repoURL, nil)
+	assert.Equal(t, packageSource, "")
+	// Not a universe.
+	packageSource, _ = getRUniverseGitSource(v, "https://cran.example.com", packageInfo)
+	assert.Equal(t, packageSource, "")
+}
+
+func Test_downloadPackagesRUniverse(t *testing.T) {
+	previousOutputDirectory := localOutputDirectory
+	localOutputDirectory = t.TempDir()
+	defer func() { localOutputDirectory = previousOutputDirectory }()
+	maxDownloadRoutines = 10
+	renvLock := Renvlock{
+		R: Rversion{Repositories: []Rrepository{
+			{"insightsengineering", "https://insightsengineering.r-universe.dev"}}},
+		Packages: map[string]Rpackage{
+			"teal": {Package: "teal", Version: "0.15.2", Source: "Repository", Repository: "insightsengineering",
+				RemoteURL: "https://github.com/insightsengineering/teal", RemoteSha: "aaa111"},
+			"tern": {Package: "tern", Version: "0.9.0", Source: "Repository",
+				Repository: "https://pharmaverse.r-universe.dev",
+				RemoteURL:  "https://github.com/insightsengineering/tern", RemoteSha: "bbb222"},
+			"teal.code": {Package: "teal.code", Version: "0.5.0", Source: "Repository", Repository: "insightsengineering",
+				RemoteURL: "https://github.com/insightsengineering/teal.code", RemoteSha: "ccc333"},
+		},
+	}
+	// Universes serve teal 0.15.2, tern 0.9.3 and teal.code 0.5.0 built from a different commit.
+	downloadFileFunction := func(url string, outputFile string) (int, int64) {
+		var contents string
+		switch url {
+		case "https://insightsengineering.r-universe.dev/src/contrib/PACKAGES":
+			contents = "Package: teal\nVersion: 0.15.2\nRemoteSha: aaa111222333\n\n" +
+				"Package: teal.code\nVersion: 0.5.0\nRemoteSha: ddd444555666\n"
+		case "https://pharmaverse.r-universe.dev/src/contrib/PACKAGES":
+			contents = "Package: tern\nVersion: 0.9.3\n"
+		case "https://insightsengineering.r-universe.dev/src/contrib/teal_0.15.2.tar.gz":
+			contents = "teal"
+		default:
+			return http.StatusNotFound, 0
+		}
+		err := os.WriteFile(outputFile, []byte(contents), 0600)
+		assert.NoError(t, err)
+		return http.StatusOK, int64(len(contents))
+	}
+	var allDownloadInfo []DownloadInfo
+	downloadPackages(context.Background(), renvLock, &allDownloadInfo, downloadFileFunction, mockedCloneGitRepo)
+	sort.Slice(allDownloadInfo, func(i, j int) bool {
+		return allDownloadInfo[i].PackageName < allDownloadInfo[j].PackageName
+	})
+	assert.Equal(t, len(allDownloadInfo), 3)
+
+	// The commit from which the package has been built is taken from the PACKAGES file of the universe.
+	assert.Equal(t, allDownloadInfo[0].StatusCode, http.StatusOK)
+	assert.Equal(t, allDownloadInfo[0].OutputLocation,
+		localOutputDirectory+archivesSubdirectory+"teal_0.15.2.tar.gz")
+	assert.Equal(t, allDownloadInfo[0].PackageRepository, "insightsengineering")
+	assert.Equal(t, allDownloadInfo[0].GitPackageShaOrRef, "aaa111222333")
+
+	// teal.code 0.5.0 served by the universe has been built from a different commit than the one
+	// in renv.lock, so it's cloned from git repository.
+	assert.Equal(t, allDownloadInfo[1].StatusCode, http.StatusOK)
+	assert.Equal(t, allDownloadInfo[1].DownloadedPackageType, "git")
+	assert.Equal(t, allDownloadInfo[1].GitPackageShaOrRef, "ccc333")
+
+	// tern 0.9.0 is not served by the universe anymore, so it's cloned from git repository.
+	assert.Equal(t, allDownloadInfo[2].StatusCode, http.StatusOK)
+	assert.Equal(t, allDownloadInfo[2].OutputLocation, localOutputDirectory+"/github/insightsengineering/tern")
+	assert.Equal(t, allDownloadInfo[2].DownloadedPackageType, "git")
+	assert.Equal(t, allDownloadInfo[2].PackageRepository, "https://pharmaverse.r-universe.dev")
+	assert.Equal(t, allDownloadInfo[2].GitPackageShaOrRef, "bbb222")
+	assert.Equal(t, getReportRepository(allDownloadInfo[2]),
+		"https://pharmaverse.r-universe.dev (github.com/insightsengineering/tern)")
+}
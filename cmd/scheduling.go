@@ -0,0 +1,103 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+// Strategies determining which of the packages ready to be installed is installed first.
+const schedulingStrategyRandom = "random"
+const schedulingStrategyDependents = "dependents"
+const schedulingStrategyCriticalPath = "critical-path"
+
+var schedulingStrategies = []string{
+	schedulingStrategyRandom, schedulingStrategyDependents, schedulingStrategyCriticalPath,
+}
+
+// getPreviousInstallTimes returns the map from package name to the time (in seconds) its installation
+// took, according to the installation results from the previous run.
+func getPreviousInstallTimes(previousInstallInfo []InstallResultInfo) map[string]int {
+	installTimes := make(map[string]int)
+	for _, p := range previousInstallInfo {
+		if p.Status == InstallResultInfoStatusSucceeded && p.InstallTime > 0 {
+			installTimes[p.PackageName] = p.InstallTime
+		}
+	}
+	return installTimes
+}
+
+// getDependentsCounts returns the map from package name to the number of packages which
+// directly or transitively depend on it.
+func getDependentsCounts(dependencies map[string][]string) map[string]int {
+	dependentsCounts := make(map[string]int)
+	for packageName := range dependencies {
+		// getReverseDependencies includes the package itself.
+		dependentsCounts[packageName] = len(getReverseDependencies(map[string]bool{packageName: true}, dependencies)) - 1
+	}
+	return dependentsCounts
+}
+
+// getCriticalPathLengths returns the map from package name to the length of the longest chain
+// of packages which can't be installed before the package is installed, including the package itself.
+// The length is the total installation time of packages in the chain, as given by installTimes.
+// Packages with unknown installation time are counted as taking 1 second.
+func getCriticalPathLengths(dependencies map[string][]string, installTimes map[string]int) map[string]int {
+	reverseDependencies := make(map[string][]string)
+	for packageName, packageDeps := range dependencies {
+		for _, d := range packageDeps {
+			reverseDependencies[d] = append(reverseDependencies[d], packageName)
+		}
+	}
+	criticalPathLengths := make(map[string]int)
+	// Guards against infinite recursion in case of dependency cycles.
+	visiting := make(map[string]bool)
+	var getLength func(string) int
+	getLength = func(packageName string) int {
+		if length, ok := criticalPathLengths[packageName]; ok {
+			return length
+		}
+		if visiting[packageName] {
+			return 0
+		}
+		visiting[packageName] = true
+		var longestDependentPath int
+		for _, r := range reverseDependencies[packageName] {
+			longestDependentPath = max(longestDependentPath, getLength(r))
+		}
+		visiting[packageName] = false
+		installTime, ok := installTimes[packageName]
+		if !ok || installTime < 1 {
+			installTime = 1
+		}
+		criticalPathLengths[packageName] = installTime + longestDependentPath
+		return criticalPathLengths[packageName]
+	}
+	for packageName := range dependencies {
+		getLength(packageName)
+	}
+	return criticalPathLengths
+}
+
+// getInstallationPriorities returns the map from package name to its installation priority according
+// to the strategy. Out of packages ready to be installed, the one with the highest priority is installed first.
+// For the random strategy, nil is returned.
+func getInstallationPriorities(strategy string, dependencies map[string][]string,
+	installTimes map[string]int) map[string]int {
+	switch strategy {
+	case schedulingStrategyDependents:
+		return getDependentsCounts(dependencies)
+	case schedulingStrategyCriticalPath:
+		return getCriticalPathLengths(dependencies, installTimes)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getSchedulingTestDependencies() map[string][]string {
+	dependencies := make(map[string][]string)
+	dependencies["package1"] = []string{"package2", "package3"}
+	dependencies["package2"] = []string{"package4"}
+	dependencies["package3"] = []string{"package4"}
+	dependencies["package4"] = []string{}
+	dependencies["package5"] = []string{}
+	dependencies["package6"] = []string{"package5"}
+	return dependencies
+}
+
+func Test_getPreviousInstallTimes(t *testing.T) {
+	previousInstallInfo := []InstallResultInfo{
+		{PackageName: "package1", Status: InstallResultInfoStatusSucceeded, InstallTime: 10},
+		{PackageName: "package2", Status: InstallResultInfoStatusFailed, InstallTime: 3},
+		{PackageName: "package3", Status: InstallResultInfoStatusSucceeded},
+	}
+	assert.Equal(t, getPreviousInstallTimes(previousInstallInfo), map[string]int{"package1": 10})
+}
+
+func Test_getDependentsCounts(t *testing.T) {
+	assert.Equal(t, getDependentsCounts(getSchedulingTestDependencies()), map[string]int{
+		"package1": 0, "package2": 1, "package3": 1, "package4": 3, "package5": 1, "package6": 0,
+	})
+}
+
+func Test_getCriticalPathLengths(t *testing.T) {
+	dependencies := getSchedulingTestDependencies()
+	assert.Equal(t, getCriticalPathLengths(dependencies, map[string]int{}), map[string]int{
+		"package1": 1, "package2": 2, "package3": 2, "package4": 3, "package5": 2, "package6": 1,
+	})
+	// A long-running package with few dependents should be installed before a quick one with many dependents.
+	installTimes := map[string]int{"package1": 5, "package3": 20, "package4": 1, "package6": 600}
+	assert.Equal(t, getCriticalPathLengths(dependencies, installTimes), map[string]int{
+		"package1": 5, "package2": 6, "package3": 25, "package4": 26, "package5": 601, "package6": 600,
+	})
+	// Dependency cycles don't cause infinite recursion.
+	dependencies["package4"] = []string{"package1"}
+	assert.Equal(t, len(getCriticalPathLengths(dependencies, map[string]int{})), 6)
+}
+
+func Test_getInstallationPriorities(t *testing.T) {
+	dependencies := getSchedulingTestDependencies()
+	assert.Nil(t, getInstallationPriorities(schedulingStrategyRandom, dependencies, nil))
+	assert.Equal(t, getInstallationPriorities(schedulingStrategyDependents, dependencies, nil)["package4"], 3)
+	assert.Equal(t, getInstallationPriorities(schedulingStrategyCriticalPath, dependencies,
+		map[string]int{"package6": 600})["package5"], 601)
+}
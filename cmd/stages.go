@@ -0,0 +1,312 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+// Names of files in tempCacheDirectory storing results of each pipeline stage.
+const downloadInfoFileName = "downloadInfo.json"
+const installInfoFileName = "installResultInfo.json"
+const checkInfoFileName = "checkInfo.json"
+
+// getSystemInfo collects information about the system for the report.
+func getSystemInfo() SystemInfo {
+	var systemInfo SystemInfo
+	getOsInformation(&systemInfo, maskedEnvVars)
+	systemInfo.TLSVerification = getTLSVerificationDescription()
+	systemInfo.Proxy = getProxyDescription()
+	return systemInfo
+}
+
+// loadRenvLock reads and validates the renv.lock. Returns its contents and the list of
+// repository names which packages refer to, but which are not defined in the renv.lock header.
+func loadRenvLock() (Renvlock, []string) {
+	var renvLock Renvlock
+	var erroneousRepositoryNames []string
+	getRenvLock(renvLockFilename, &renvLock)
+	validateRenvLock(renvLock, &erroneousRepositoryNames)
+	initializeRepositoryCredentials(getRenvRepositories(renvLock))
+	return renvLock, erroneousRepositoryNames
+}
+
+// readStageResults reads results of a previously executed stage from the JSON file
+// into j. Returns false if the file doesn't exist.
+func readStageResults(fileName string, j interface{}) bool {
+	if _, err := os.Stat(fileName); err != nil {
+		log.Info(fileName, " doesn't exist.")
+		return false
+	}
+	readJSON(fileName, j)
+	return true
+}
+
+// readCachedStageResults reads results of a previously executed stage from the JSON file
+// into j, but only if they have been computed from inputs matching the fingerprint.
+// Returns false if the results don't exist or are outdated.
+func readCachedStageResults(fileName string, fingerprint StageFingerprint, j interface{}) bool {
+	if _, err := os.Stat(fileName); err != nil {
+		log.Info(fileName, " doesn't exist.")
+		return false
+	}
+	if !isStageCacheValid(fileName, fingerprint) {
+		return false
+	}
+	readJSON(fileName, j)
+	return true
+}
+
+// getUpstreamFingerprint returns the fingerprint stored together with the results of a preceding
+// stage. If there's no such fingerprint, the fingerprint computed from current inputs is returned.
+func getUpstreamFingerprint(stageResultsFileName string, current StageFingerprint) StageFingerprint {
+	var stored StageFingerprint
+	if readStageFingerprint(stageResultsFileName, &stored) {
+		return stored
+	}
+	return current
+}
+
+// saveStageFingerprint saves the fingerprint of stage results stored in stageResultsFileName.
+// If the stage has been cancelled, its results are incomplete, so instead any previous fingerprint
+// is removed to prevent the results from being reused.
+func saveStageFingerprint(ctx context.Context, stageResultsFileName string, fingerprint StageFingerprint) {
+	if isCancelled(ctx) {
+		removeStageFingerprint(stageResultsFileName)
+		return
+	}
+	writeStageFingerprint(stageResultsFileName, fingerprint)
+}
+
+// runDownloadStage downloads packages from renv.lock. If useCache is true and the cache
+// contains JSON with previous download results computed from the same inputs,
+// these results are returned instead.
+func runDownloadStage(ctx context.Context, renvLock Renvlock, fingerprint StageFingerprint,
+	useCache bool) []DownloadInfo {
+	downloadInfoFile := filepath.Join(tempCacheDirectory, downloadInfoFileName)
+	var allDownloadInfo []DownloadInfo
+	if useCache && readCachedStageResults(downloadInfoFile, fingerprint, &allDownloadInfo) {
+		checkIntegrity(allDownloadInfo)
+		return allDownloadInfo
+	}
+	downloadFileFunction := func(url string, outputFile string) (int, int64) {
+		return downloadFileContext(ctx, url, outputFile)
+	}
+	gitCloneFunction := func(gitDirectory string, repoURL string, environmentCredentialsType string,
+		commitSha string, branchOrTagName string) (string, int64, int64, string) {
+		return cloneGitRepoContext(ctx, gitDirectory, repoURL, environmentCredentialsType, commitSha, branchOrTagName)
+	}
+	downloadPackages(ctx, renvLock, &allDownloadInfo, downloadFileFunction, gitCloneFunction)
+	writeJSON(downloadInfoFile, &allDownloadInfo)
+	saveStageFingerprint(ctx, downloadInfoFile, fingerprint)
+	checkIntegrity(allDownloadInfo)
+	return allDownloadInfo
+}
+
+// runInstallStage builds and installs downloaded packages. If useCache is true and the cache
+// contains JSON with previous installation results computed from the same inputs,
+// these results are returned instead. If only the results of download stage changed,
+// previous installation results of packages not affected by these changes are reused.
+func runInstallStage(ctx context.Context, renvLock Renvlock, allDownloadInfo []DownloadInfo,
+	erroneousRepositoryNames []string, fingerprint StageFingerprint, useCache bool) []InstallResultInfo {
+	err := os.MkdirAll(buildLogPath, os.ModePerm)
+	checkError(err)
+	installInfoFile := filepath.Join(tempCacheDirectory, installInfoFileName)
+	var allInstallInfo []InstallResultInfo
+	if useCache && readCachedStageResults(installInfoFile, fingerprint, &allInstallInfo) {
+		return allInstallInfo
+	}
+	// Installation times from the previous run are used to schedule the installation,
+	// regardless of whether the previous results are reused.
+	var previousInstallInfo []InstallResultInfo
+	var previousInstallTimes map[string]int
+	if readStageResults(installInfoFile, &previousInstallInfo) {
+		previousInstallTimes = getPreviousInstallTimes(previousInstallInfo)
+	}
+	if !useCache || !isOnlyUpstreamChanged(installInfoFile, fingerprint) {
+		previousInstallInfo = nil
+	}
+	allInstallInfo = nil
+	installPackages(ctx, renvLock, &allDownloadInfo, &allInstallInfo, buildOptions,
+		installOptions, erroneousRepositoryNames, previousInstallInfo, previousInstallTimes)
+	saveStageFingerprint(ctx, installInfoFile, fingerprint)
+	return allInstallInfo
+}
+
+// getReusableCheckResults returns the previous R CMD check results of packages
+// whose installation results have been reused from the previous run.
+func getReusableCheckResults(previousCheckInfo []PackageCheckInfo,
+	allInstallInfo []InstallResultInfo) []PackageCheckInfo {
+	reusedPackages := make(map[string]bool)
+	for _, p := range allInstallInfo {
+		if p.Reused {
+			reusedPackages[p.PackageName] = true
+		}
+	}
+	var reusableCheckInfo []PackageCheckInfo
+	for _, p := range previousCheckInfo {
+		if reusedPackages[p.PackageName] {
+			reusableCheckInfo = append(reusableCheckInfo, p)
+		}
+	}
+	return reusableCheckInfo
+}
+
+// runCheckStage runs R CMD check on the built packages. If useCache is true and the cache
+// contains JSON with previous check results computed from the same inputs,
+// these results are returned instead. If only the results of installation stage changed,
+// previous check results of packages which haven't been reinstalled are reused.
+func runCheckStage(ctx context.Context, allInstallInfo []InstallResultInfo, fingerprint StageFingerprint,
+	useCache bool) []PackageCheckInfo {
+	checkInfoFile := filepath.Join(tempCacheDirectory, checkInfoFileName)
+	var allCheckInfo []PackageCheckInfo
+	if useCache && readCachedStageResults(checkInfoFile, fingerprint, &allCheckInfo) {
+		return allCheckInfo
+	}
+	var reusableCheckInfo []PackageCheckInfo
+	if useCache && isOnlyUpstreamChanged(checkInfoFile, fingerprint) {
+		var previousCheckInfo []PackageCheckInfo
+		readJSON(checkInfoFile, &previousCheckInfo)
+		reusableCheckInfo = getReusableCheckResults(previousCheckInfo, allInstallInfo)
+	}
+	// Results of previous check are removed, so that they are not mistaken for
+	// the current ones in case no packages are checked this time.
+	err := os.RemoveAll(checkInfoFile)
+	checkError(err)
+	checkPackages(ctx, checkInfoFile, checkOptions, reusableCheckInfo)
+	// If no packages were checked (e.g. because their names didn't match the CLI parameter)
+	// the file with check results will not be generated, so we're checking
+	// its existence once again.
+	allCheckInfo = nil
+	if readStageResults(checkInfoFile, &allCheckInfo) {
+		saveStageFingerprint(ctx, checkInfoFile, fingerprint)
+	}
+	return allCheckInfo
+}
+
+// runReportStage generates the HTML report, together with the logs linked from it.
+func runReportStage(allDownloadInfo []DownloadInfo, allInstallInfo []InstallResultInfo,
+	allCheckInfo []PackageCheckInfo, systemInfo *SystemInfo, renvLock Renvlock) {
+	var reportData ReportInfo
+	processReportData(allDownloadInfo, allInstallInfo, allCheckInfo, systemInfo, &reportData, renvLock)
+	err := os.RemoveAll(filepath.Join(outputReportDirectory, "logs"))
+	checkError(err)
+	err = os.MkdirAll(filepath.Join(outputReportDirectory, "logs"), os.ModePerm)
+	checkError(err)
+	// Copy log files so that they can be accessed from the HTML report.
+	copyFiles(packageLogPath, "install-", filepath.Join(outputReportDirectory, "logs"))
+	copyFiles(buildLogPath, "build-", filepath.Join(outputReportDirectory, "logs"))
+	copyFiles(checkLogPath, "check-", filepath.Join(outputReportDirectory, "logs"))
+	writeReport(reportData, filepath.Join(outputReportDirectory, "index.html"))
+}
+
+func newDownloadCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "download",
+		Short: "Download packages from renv.lock",
+		Long: `Downloads packages defined in renv.lock and saves the download results
+to ` + downloadInfoFileName + ` in the cache directory, regardless of whether
+previous download results exist.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			initializeRun()
+			ctx, stop := newRunContext()
+			defer stop()
+			renvLock, _ := loadRenvLock()
+			downloadFingerprint := getDownloadFingerprint(getFileHash(renvLockFilename), getSystemRVersion())
+			runDownloadStage(ctx, renvLock, downloadFingerprint, false)
+			exitIfCancelled(ctx)
+		},
+	}
+}
+
+func newInstallCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "install",
+		Short: "Build and install previously downloaded packages",
+		Long: `Builds and installs packages based on the download results stored in
+` + downloadInfoFileName + ` and saves the installation results to ` + installInfoFileName + `
+in the cache directory, regardless of whether previous installation results exist.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			initializeRun()
+			ctx, stop := newRunContext()
+			defer stop()
+			renvLock, erroneousRepositoryNames := loadRenvLock()
+			downloadInfoFile := filepath.Join(tempCacheDirectory, downloadInfoFileName)
+			var allDownloadInfo []DownloadInfo
+			if !readStageResults(downloadInfoFile, &allDownloadInfo) {
+				log.Fatal("No download results found. Please run 'scribe download' first.")
+			}
+			rVersion := getSystemRVersion()
+			downloadFingerprint := getUpstreamFingerprint(downloadInfoFile,
+				getDownloadFingerprint(getFileHash(renvLockFilename), rVersion))
+			runInstallStage(ctx, renvLock, allDownloadInfo, erroneousRepositoryNames,
+				getInstallFingerprint(downloadFingerprint, rVersion), false)
+			exitIfCancelled(ctx)
+		},
+	}
+}
+
+func newCheckCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "check",
+		Short: "Run R CMD check on previously built packages",
+		Long: `Runs R CMD check on packages matching --checkPackage or --checkAllPackages
+and saves the check results to ` + checkInfoFileName + ` in the cache directory,
+regardless of whether previous check results exist.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			initializeRun()
+			ctx, stop := newRunContext()
+			defer stop()
+			rVersion := getSystemRVersion()
+			downloadFingerprint := getUpstreamFingerprint(filepath.Join(tempCacheDirectory, downloadInfoFileName),
+				getDownloadFingerprint(getFileHash(renvLockFilename), rVersion))
+			installFingerprint := getUpstreamFingerprint(filepath.Join(tempCacheDirectory, installInfoFileName),
+				getInstallFingerprint(downloadFingerprint, rVersion))
+			runCheckStage(ctx, nil, getCheckFingerprint(installFingerprint, rVersion), false)
+			exitIfCancelled(ctx)
+		},
+	}
+}
+
+func newReportCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "report",
+		Short: "Generate the HTML report from previous results",
+		Long: `Generates the HTML report based on the download, installation and check results
+stored in the cache directory. Missing results are shown as empty statuses in the report.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			initializeRun()
+			systemInfo := getSystemInfo()
+			renvLock, _ := loadRenvLock()
+			var allDownloadInfo []DownloadInfo
+			var allInstallInfo []InstallResultInfo
+			var allCheckInfo []PackageCheckInfo
+			readStageResults(filepath.Join(tempCacheDirectory, downloadInfoFileName), &allDownloadInfo)
+			readStageResults(filepath.Join(tempCacheDirectory, installInfoFileName), &allInstallInfo)
+			readStageResults(filepath.Join(tempCacheDirectory, checkInfoFileName), &allCheckInfo)
+			runReportStage(allDownloadInfo, allInstallInfo, allCheckInfo, &systemInfo, renvLock)
+
+			if failOnError {
+				exitStatus := getExitStatus(allInstallInfo, allCheckInfo)
+				os.Exit(exitStatus)
+			}
+		},
+	}
+}
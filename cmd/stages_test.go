@@ -0,0 +1,72 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_readStageResults(t *testing.T) {
+	var allInstallInfo []InstallResultInfo
+	assert.True(t, readStageResults("testdata/installInfo.json", &allInstallInfo))
+	assert.Equal(t, len(allInstallInfo), 2)
+	var allCheckInfo []PackageCheckInfo
+	assert.False(t, readStageResults("testdata/nonExistentCheckInfo.json", &allCheckInfo))
+	assert.Equal(t, len(allCheckInfo), 0)
+}
+
+func Test_newRootCommand(t *testing.T) {
+	newRootCommand()
+	var subcommands []string
+	for _, c := range rootCmd.Commands() {
+		subcommands = append(subcommands, c.Name())
+	}
+	for _, stage := range []string{"download", "install", "check", "report"} {
+		assert.Contains(t, subcommands, stage)
+	}
+}
+
+func Test_setWorkDirectoryPaths(t *testing.T) {
+	setWorkDirectoryPaths("/tmp/scribe-run1", "", "")
+	assert.Equal(t, tempCacheDirectory, "/tmp/scribe-run1/cache")
+	assert.Equal(t, packageLogPath, "/tmp/scribe-run1/installed_logs")
+	assert.Equal(t, buildLogPath, "/tmp/scribe-run1/build_logs")
+	assert.Equal(t, checkLogPath, "/tmp/scribe-run1/check_logs")
+	assert.Equal(t, temporaryLibPath, "/tmp/scribe-run1/installed_packages")
+	assert.Equal(t, localOutputDirectory, "/tmp/scribe-run1/downloaded_packages")
+	assert.Equal(t, rLibsPaths, "/tmp/scribe-run1/installed_packages:/usr/local/lib/R/site-library:"+
+		"/usr/lib/R/site-library:/usr/lib/R/library")
+	setWorkDirectoryPaths("/tmp/scribe-run2", "/opt/R/library", "/mnt/package-cache")
+	assert.Equal(t, tempCacheDirectory, "/tmp/scribe-run2/cache")
+	assert.Equal(t, temporaryLibPath, "/opt/R/library")
+	assert.Equal(t, localOutputDirectory, "/mnt/package-cache")
+	setWorkDirectoryPaths("/tmp/scribe", "", "")
+}
+
+func Test_getReusableCheckResults(t *testing.T) {
+	allInstallInfo := []InstallResultInfo{
+		{PackageName: "package1", Reused: true},
+		{PackageName: "package2"},
+	}
+	previousCheckInfo := []PackageCheckInfo{
+		{PackageName: "package1", MostSevereCheckItem: "OK"},
+		{PackageName: "package2", MostSevereCheckItem: "ERROR"},
+	}
+	assert.Equal(t, getReusableCheckResults(previousCheckInfo, allInstallInfo),
+		[]PackageCheckInfo{{PackageName: "package1", MostSevereCheckItem: "OK"}})
+}
@@ -34,6 +34,8 @@ type SystemInfo struct {
 	Time            string `json:"time"`
 	EnvVariables    string `json:"envVariables"`
 	Hostname        string `json:"hostname"`
+	TLSVerification string `json:"tlsVerification"`
+	Proxy           string `json:"proxy"`
 }
 
 func getSystemRVersion() string {
@@ -42,8 +44,30 @@ func getSystemRVersion() string {
 	return strings.Split(string(out), "\n")[0]
 }
 
+// Environment variables with proxy credentials, which are never shown in the report.
+var secretEnvVariables = []string{"SCRIBE_PROXYUSERNAME", "SCRIBE_PROXYPASSWORD"}
+
+// Prefixes of environment variables with repository and git credentials, which are never shown in the report.
+var secretEnvVariablePrefixes = []string{repositoryCredentialsEnvPrefix, gitCredentialsEnvPrefix}
+
+// isSecretEnvVariable checks whether the environment variable contains credentials
+// and should be omitted from the report.
+func isSecretEnvVariable(variableName string) bool {
+	if stringInSlice(variableName, secretEnvVariables) {
+		return true
+	}
+	for _, prefix := range secretEnvVariablePrefixes {
+		if strings.HasPrefix(variableName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 // If regex is not equal to empty string, only environment variables
 // with names NOT matching the regex will be returned.
+// Credentials in proxy environment variables (including SCRIBE_PROXY) are masked,
+// and environment variables with credentials (see isSecretEnvVariable) are omitted.
 func getEnvironmentVariables(regex string) string {
 	r, err := regexp.Compile(regex)
 	checkError(err)
@@ -51,8 +75,10 @@ func getEnvironmentVariables(regex string) string {
 	for _, e := range os.Environ() {
 		values := strings.Split(e, "=")
 		variableName := values[0]
-		if regex != "" && r.MatchString(variableName) {
+		if (regex != "" && r.MatchString(variableName)) || isSecretEnvVariable(variableName) {
 			log.Info("Masking environment variable ", variableName)
+		} else if proxyEnvVariableRegex.MatchString(variableName) || variableName == "SCRIBE_PROXY" {
+			envVariables.WriteString(variableName + "=" + maskURLCredentials(strings.TrimPrefix(e, variableName+"=")) + "\n")
 		} else {
 			envVariables.WriteString(e + "\n")
 		}
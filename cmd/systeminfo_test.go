@@ -54,6 +54,22 @@ func Test_getEnvironmentVariables(t *testing.T) {
 	assert.False(t, strings.Contains(envVars, envVar2))
 }
 
+func Test_getEnvironmentVariablesMasksRepositoryCredentials(t *testing.T) {
+	t.Setenv("SCRIBE_REPOSITORY_INTERNAL_TOKEN", "secret-token")
+	t.Setenv("SCRIBE_REPOSITORY_INTERNAL_PASSWORD", "secret-password")
+	envVars := getEnvironmentVariables("")
+	assert.NotContains(t, envVars, "SCRIBE_REPOSITORY_INTERNAL")
+	assert.NotContains(t, envVars, "secret-token")
+	assert.NotContains(t, envVars, "secret-password")
+}
+
+func Test_getEnvironmentVariablesMasksGitCredentials(t *testing.T) {
+	t.Setenv("SCRIBE_GIT_GITLAB_EXAMPLE_COM_TOKEN", "secret-git-token")
+	envVars := getEnvironmentVariables("")
+	assert.NotContains(t, envVars, "SCRIBE_GIT_GITLAB_EXAMPLE_COM_TOKEN")
+	assert.NotContains(t, envVars, "secret-git-token")
+}
+
 func Test_parseEtcReleaseFile(t *testing.T) {
 	prettyName := parseEtcReleaseFile("testdata/etc-os-release")
 	assert.Equal(t, prettyName, "Ubuntu 20.04.5 LTS")
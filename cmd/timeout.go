@@ -0,0 +1,76 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Names of stages for which the timeouts can be set.
+const buildStage = "build"
+const installStage = "install"
+const checkStage = "check"
+
+var errCommandTimeout = errors.New("command timed out")
+
+// parsePackageTimeouts parses the value of --packageTimeouts, i.e. comma-separated list of
+// <package name>:<stage>=<duration> items, e.g. "Rcpp:install=1h,arrow:check=90m".
+// Returns the map from package name to the map from stage name to timeout.
+func parsePackageTimeouts(packageTimeoutsExpression string) (map[string]map[string]time.Duration, error) {
+	packageTimeouts := make(map[string]map[string]time.Duration)
+	if strings.TrimSpace(packageTimeoutsExpression) == "" {
+		return packageTimeouts, nil
+	}
+	for _, item := range strings.Split(packageTimeoutsExpression, ",") {
+		packageName, stageTimeout, found := strings.Cut(strings.TrimSpace(item), ":")
+		if !found || packageName == "" {
+			return nil, errors.New("invalid package timeout " + item + ", expected <package>:<stage>=<duration>")
+		}
+		stage, durationString, found := strings.Cut(stageTimeout, "=")
+		if !found || !stringInSlice(stage, []string{buildStage, installStage, checkStage}) {
+			return nil, errors.New("invalid package timeout " + item + ", stage should be one of: " +
+				buildStage + ", " + installStage + ", " + checkStage)
+		}
+		duration, err := time.ParseDuration(durationString)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := packageTimeouts[packageName]; !ok {
+			packageTimeouts[packageName] = make(map[string]time.Duration)
+		}
+		packageTimeouts[packageName][stage] = duration
+	}
+	return packageTimeouts, nil
+}
+
+// getTimeout returns the timeout of the stage for the package. Timeouts set with --packageTimeouts
+// take precedence over the ones set for all packages. Zero means no timeout.
+func getTimeout(packageName string, stage string) time.Duration {
+	if timeout, ok := packageTimeouts[packageName][stage]; ok {
+		return timeout
+	}
+	switch stage {
+	case buildStage:
+		return buildTimeout
+	case installStage:
+		return installTimeout
+	case checkStage:
+		return checkTimeout
+	}
+	return 0
+}
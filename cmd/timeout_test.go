@@ -0,0 +1,53 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parsePackageTimeouts(t *testing.T) {
+	packageTimeouts, err := parsePackageTimeouts("Rcpp:install=1h, arrow:build=30m,arrow:check=1h30m")
+	assert.Nil(t, err)
+	assert.Equal(t, packageTimeouts, map[string]map[string]time.Duration{
+		"Rcpp":  {installStage: time.Hour},
+		"arrow": {buildStage: 30 * time.Minute, checkStage: 90 * time.Minute},
+	})
+	packageTimeouts, err = parsePackageTimeouts("")
+	assert.Nil(t, err)
+	assert.Empty(t, packageTimeouts)
+	for _, expression := range []string{"Rcpp", "Rcpp:install", "Rcpp:download=1h", "Rcpp:install=1 hour", ":check=1h"} {
+		_, err = parsePackageTimeouts(expression)
+		assert.NotNil(t, err, expression)
+	}
+}
+
+func Test_getTimeout(t *testing.T) {
+	buildTimeout = 10 * time.Minute
+	installTimeout = 20 * time.Minute
+	checkTimeout = 0
+	packageTimeouts = map[string]map[string]time.Duration{"arrow": {checkStage: time.Hour}}
+	defer func() {
+		buildTimeout, installTimeout, checkTimeout, packageTimeouts = 0, 0, 0, nil
+	}()
+	assert.Equal(t, getTimeout("arrow", checkStage), time.Hour)
+	assert.Equal(t, getTimeout("arrow", buildStage), 10*time.Minute)
+	assert.Equal(t, getTimeout("Rcpp", installStage), 20*time.Minute)
+	assert.Equal(t, getTimeout("Rcpp", checkStage), time.Duration(0))
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// HTTP client used for all downloads. Replaced in initializeRun by the client configured
+// according to caBundle and insecureSkipTLSVerify.
+var httpClient = &http.Client{}
+
+// Contents of the file pointed to by caBundle, passed to go-git.
+var caBundleContents []byte
+
+// newTLSConfig returns TLS configuration trusting certificates from the system certificate pool,
+// and from caBundleFile (if not empty). If insecureSkipVerify is true, certificates are not verified.
+func newTLSConfig(caBundleFile string, insecureSkipVerify bool) (*tls.Config, []byte, error) {
+	// #nosec G402 -- skipping the verification has to be explicitly requested by the user.
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caBundleFile == "" {
+		return tlsConfig, nil, nil
+	}
+	contents, err := os.ReadFile(caBundleFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPool, err := x509.SystemCertPool()
+	if err != nil {
+		certPool = x509.NewCertPool()
+	}
+	if !certPool.AppendCertsFromPEM(contents) {
+		return nil, nil, errors.New("no PEM certificates found in " + caBundleFile)
+	}
+	tlsConfig.RootCAs = certPool
+	return tlsConfig, contents, nil
+}
+
+// newHTTPClient returns HTTP client using TLS configuration according to caBundleFile and insecureSkipVerify.
+// Also returns the contents of caBundleFile.
+func newHTTPClient(caBundleFile string, insecureSkipVerify bool) (*http.Client, []byte, error) {
+	tlsConfig, contents, err := newTLSConfig(caBundleFile, insecureSkipVerify)
+	if err != nil {
+		return nil, nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = getRequestProxy
+	return &http.Client{Transport: &credentialsTransport{base: transport}}, contents, nil
+}
+
+// getTLSVerificationDescription returns description of TLS settings displayed in the report.
+func getTLSVerificationDescription() string {
+	switch {
+	case insecureSkipTLSVerify:
+		return "disabled (--insecureSkipTLSVerify)"
+	case caBundle != "":
+		return "enabled (system certificates and CA bundle " + caBundle + ")"
+	}
+	return "enabled (system certificates)"
+}
+
+// downloadTextFile returns the contents and length of the text file at url, using the HTTP client
+// configured according to TLS settings. It has the same signature as locksmith.DownloadTextFile,
+// which doesn't verify TLS certificates.
+func downloadTextFile(url string, parameters map[string]string) (int64, string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	for k, v := range parameters {
+		req.Header.Add(k, v)
+	}
+	setLinuxBinaryUserAgent(req)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, "", fmt.Errorf("received status code %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, "", err
+	}
+	return resp.ContentLength, string(body), nil
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2023 F. Hoffmann-La Roche AG
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeServerCertificate saves the certificate of the test server to a PEM file and returns its path.
+func writeServerCertificate(t *testing.T, server *httptest.Server) string {
+	caBundleFile := filepath.Join(t.TempDir(), "ca.pem")
+	contents := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	err := os.WriteFile(caBundleFile, contents, 0600)
+	assert.NoError(t, err)
+	return caBundleFile
+}
+
+func Test_newTLSConfig(t *testing.T) {
+	tlsConfig, contents, err := newTLSConfig("", false)
+	assert.NoError(t, err)
+	assert.False(t, tlsConfig.InsecureSkipVerify)
+	assert.Nil(t, tlsConfig.RootCAs)
+	assert.Nil(t, contents)
+	tlsConfig, _, err = newTLSConfig("", true)
+	assert.NoError(t, err)
+	assert.True(t, tlsConfig.InsecureSkipVerify)
+	_, _, err = newTLSConfig(filepath.Join(t.TempDir(), "nonexistent.pem"), false)
+	assert.Error(t, err)
+	invalidBundle := filepath.Join(t.TempDir(), "invalid.pem")
+	err = os.WriteFile(invalidBundle, []byte("not a certificate"), 0600)
+	assert.NoError(t, err)
+	_, _, err = newTLSConfig(invalidBundle, false)
+	assert.Error(t, err)
+}
+
+func Test_downloadTextFileTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "Package: package1")
+	}))
+	defer server.Close()
+	previousHTTPClient := httpClient
+	defer func() { httpClient = previousHTTPClient }()
+
+	// Certificate of the test server is not trusted by default.
+	var err error
+	httpClient, _, err = newHTTPClient("", false)
+	assert.NoError(t, err)
+	_, _, err = downloadTextFile(server.URL+"/PACKAGES", map[string]string{})
+	assert.Error(t, err)
+
+	caBundleFile := writeServerCertificate(t, server)
+	var contents []byte
+	httpClient, contents, err = newHTTPClient(caBundleFile, false)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, contents)
+	_, body, err := downloadTextFile(server.URL+"/PACKAGES", map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, body, "Package: package1")
+
+	httpClient, _, err = newHTTPClient("", true)
+	assert.NoError(t, err)
+	_, body, err = downloadTextFile(server.URL+"/PACKAGES", map[string]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, body, "Package: package1")
+}
+
+func Test_getTLSVerificationDescription(t *testing.T) {
+	defer func() {
+		caBundle = ""
+		insecureSkipTLSVerify = false
+	}()
+	assert.Equal(t, getTLSVerificationDescription(), "enabled (system certificates)")
+	caBundle = "/etc/ssl/corporate.pem"
+	assert.Equal(t, getTLSVerificationDescription(),
+		"enabled (system certificates and CA bundle /etc/ssl/corporate.pem)")
+	insecureSkipTLSVerify = true
+	assert.Equal(t, getTLSVerificationDescription(), "disabled (--insecureSkipTLSVerify)")
+}
@@ -16,12 +16,14 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
+	"time"
 	"unicode"
 
 	locksmith "github.com/insightsengineering/locksmith/cmd"
@@ -43,23 +45,14 @@ func checkError(err error) {
 	}
 }
 
-func clearCachedData() {
-	err := os.RemoveAll("/tmp/scribe")
+// clearCachedData removes the work directory. Custom library and download directories
+// located outside of the work directory are not removed.
+func clearCachedData(workDir string) {
+	log.Info("Removing ", workDir)
+	err := os.RemoveAll(workDir)
 	checkError(err)
 }
 
-func dirSize(path string) (int64, error) {
-	var size int64
-	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
-		checkError(err)
-		if !info.IsDir() {
-			size += info.Size()
-		}
-		return err
-	})
-	return size, err
-}
-
 // Returns number of bytes written to a file
 func writeJSON(filename string, j interface{}) int {
 	s, err := json.MarshalIndent(j, "", "  ")
@@ -100,8 +93,33 @@ func getTimeMinutesAndSeconds(seconds int) string {
 }
 
 // Execute a system command
-// nolint: gocyclo
 func execCommand(command string, returnOutput bool, envs []string, file *os.File, escapeHTMLTags bool) (string, error) {
+	return execCommandContext(context.Background(), command, 0, returnOutput, envs, file, escapeHTMLTags)
+}
+
+// getCommandContextError returns the error describing why the command has been killed,
+// or err if the command hasn't been killed. Commands which have succeeded are not reported
+// as killed, even if ctx has been cancelled in the meantime.
+func getCommandContextError(ctx context.Context, commandCtx context.Context, err error,
+	timeout time.Duration, command string) error {
+	switch {
+	case err == nil:
+		return nil
+	case ctx.Err() != nil:
+		return fmt.Errorf("%w: %s", errCommandCancelled, command)
+	case commandCtx.Err() == context.DeadlineExceeded:
+		return fmt.Errorf("%w after %s: %s", errCommandTimeout, timeout, command)
+	}
+	return err
+}
+
+// execCommandContext executes a system command. If the command doesn't complete within
+// the timeout, or ctx is cancelled, it's killed together with all processes it spawned, and the error
+// wrapping errCommandTimeout or errCommandCancelled respectively is returned together with
+// the output produced so far. Zero timeout means no timeout.
+// nolint: gocyclo
+func execCommandContext(ctx context.Context, command string, timeout time.Duration, returnOutput bool,
+	envs []string, file *os.File, escapeHTMLTags bool) (string, error) {
 	lastQuote := rune(0)
 	f := func(c rune) bool {
 		switch {
@@ -125,9 +143,22 @@ func execCommand(command string, returnOutput bool, envs []string, file *os.File
 		parts = append(parts, strings.ReplaceAll(part, "'", ""))
 	}
 
+	commandCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		commandCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 	// nolint: gosec
-	cmd := exec.Command(parts[0], parts[1:]...)
+	cmd := exec.CommandContext(commandCtx, parts[0], parts[1:]...)
 	cmd.Env = os.Environ()
+	// R CMD spawns further R processes, so all of them have to be killed.
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+	// Don't wait for output of processes which could have escaped the process group.
+	cmd.WaitDelay = 10 * time.Second
 
 	for _, env := range fillEnvFromSystem(envs) {
 		if env != "" {
@@ -136,11 +167,12 @@ func execCommand(command string, returnOutput bool, envs []string, file *os.File
 	}
 	if returnOutput {
 		data, err := cmd.Output()
-		return string(data), err
+		return string(data), getCommandContextError(ctx, commandCtx, err, timeout, command)
 	}
 
 	log.Trace("Command to execute: ", cmd)
 	out, errCombinedOutput := cmd.CombinedOutput()
+	errCombinedOutput = getCommandContextError(ctx, commandCtx, errCombinedOutput, timeout, command)
 	checkError(errCombinedOutput)
 
 	outStr := string(out)
@@ -152,6 +184,11 @@ func execCommand(command string, returnOutput bool, envs []string, file *os.File
 
 	_, errWriteString := file.WriteString(outStr)
 	checkError(errWriteString)
+	if errors.Is(errCombinedOutput, errCommandTimeout) || errors.Is(errCombinedOutput, errCommandCancelled) {
+		// Mark the log as partial.
+		_, errWriteString = file.WriteString("\n" + errCombinedOutput.Error() + "\n")
+		checkError(errWriteString)
+	}
 
 	return outStr, errCombinedOutput
 }
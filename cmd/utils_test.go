@@ -16,9 +16,14 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -71,6 +76,60 @@ func Test_execCommandWithEnvs(t *testing.T) {
 
 }
 
+func Test_execCommandContext(t *testing.T) {
+	if runtime.GOOS == windows {
+		t.Skip("skipping test requiring sh")
+	}
+	logFile, err := os.Create(filepath.Join(t.TempDir(), "Test_execCommandContext.log"))
+	assert.Nil(t, err)
+	defer logFile.Close()
+	start := time.Now()
+	// The child process spawned in the background should be killed as well,
+	// otherwise the command would only return after it completes.
+	res, err := execCommandContext(context.Background(), `sh -c 'echo partial; sleep 30 & sleep 30'`, time.Second,
+		false, nil, logFile, false)
+	assert.True(t, errors.Is(err, errCommandTimeout))
+	assert.Less(t, time.Since(start), 10*time.Second)
+	assert.Equal(t, res, "partial\n")
+	content, err := os.ReadFile(logFile.Name())
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "partial\n\ncommand timed out after 1s")
+
+	res, err = execCommandContext(context.Background(), `sh -c 'echo done'`, 10*time.Second, false, nil, logFile, false)
+	assert.Nil(t, err)
+	assert.Equal(t, res, "done\n")
+}
+
+func Test_execCommandContextCancelled(t *testing.T) {
+	if runtime.GOOS == windows {
+		t.Skip("skipping test requiring sh")
+	}
+	logFile, err := os.Create(filepath.Join(t.TempDir(), "Test_execCommandContextCancelled.log"))
+	assert.Nil(t, err)
+	defer logFile.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Second, cancel)
+	start := time.Now()
+	_, err = execCommandContext(ctx, `sh -c 'sleep 30 & sleep 30'`, time.Minute, false, nil, logFile, false)
+	assert.True(t, errors.Is(err, errCommandCancelled))
+	assert.Less(t, time.Since(start), 10*time.Second)
+}
+
+func Test_getCommandContextError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// The command succeeded before it could be killed.
+	assert.Nil(t, getCommandContextError(ctx, ctx, nil, time.Minute, "R CMD INSTALL"))
+	err := getCommandContextError(ctx, ctx, errors.New("signal: killed"), time.Minute, "R CMD INSTALL")
+	assert.True(t, errors.Is(err, errCommandCancelled))
+	commandCtx, commandCancel := context.WithTimeout(context.Background(), 0)
+	defer commandCancel()
+	<-commandCtx.Done()
+	err = getCommandContextError(context.Background(), commandCtx, errors.New("signal: killed"), time.Second,
+		"R CMD INSTALL")
+	assert.True(t, errors.Is(err, errCommandTimeout))
+}
+
 func Test_fillEnvFromSystem(t *testing.T) {
 	os.Setenv("LANG", "en_US.UTF-8")
 	envs := fillEnvFromSystem([]string{"LANG"})